@@ -0,0 +1,841 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v3"
+	"github.com/cristim67/med-monitor/backend/config"
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/routes"
+	"github.com/cristim67/med-monitor/backend/rxsign"
+	"github.com/cristim67/med-monitor/backend/services"
+	"github.com/cristim67/med-monitor/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testLocalAuthSecret = "local-auth-secret-for-the-api-tests"
+	testSessionSecret   = "session-secret-for-the-api-tests-only"
+)
+
+// testAPI is the full router with the default policies, backed by a memory store.
+// Callers sign in through the dev login, as integration tests of a real deployment would.
+type testAPI struct {
+	t      *testing.T
+	router *gin.Engine
+	store  *memoryStore
+
+	localJWT *utils.LocalJWT // stands in for an identity provider at the token exchange
+}
+
+// testUser is an account signed in through the dev login
+type testUser struct {
+	id    uint
+	token string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	signingKey, err := rxsign.GenerateSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig = config.Config{
+		Environment:            "test",
+		ClinicTimezone:         "UTC",
+		PrescriptionValidity:   30 * 24 * time.Hour,
+		PrescriptionSigningKey: signingKey,
+		LocalAuthSecret:        testLocalAuthSecret,
+		LocalAuthTokenTTL:      time.Hour,
+		SessionSecret:          testSessionSecret,
+		AccessTokenTTL:         15 * time.Minute,
+		RefreshTokenTTL:        24 * time.Hour,
+	}
+
+	enforcer, err := casbin.NewEnforcer("casbin/model.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seed := range policySeeds {
+		for _, p := range seed.policies {
+			if _, err := enforcer.AddPolicy(p[0], p[1], p[2]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	store := newMemoryStore()
+	repos := store.repositories()
+	uow := &memoryUnitOfWork{s: store}
+	userService := services.NewUserService(repos.Users, repos.Medical, uow)
+	medicalService := services.NewMedicalService(repos.Medical, uow)
+
+	localJWT, err := utils.NewLocalJWT(config.AppConfig.LocalAuthSecret, config.AppConfig.LocalAuthTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := routes.SetupRouter(enforcer, userService, medicalService, utils.Verifiers{localJWT}, true)
+	return &testAPI{t: t, router: router, store: store, localJWT: localJWT}
+}
+
+func (a *testAPI) do(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+	if token != "" {
+		token = "Bearer " + token
+	}
+	return a.send(token, method, path, body)
+}
+
+// doWithAPIKey sends a request as a service account
+func (a *testAPI) doWithAPIKey(key, method, path string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+	return a.send("ApiKey "+key, method, path, body)
+}
+
+func (a *testAPI) send(authorization, method, path string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect fails the test unless the response has the status, and decodes its body into out when given
+func expect(t *testing.T, rec *httptest.ResponseRecorder, status int, out interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+		}
+	}
+}
+
+func (a *testAPI) login(email string, role models.UserRole, departmentID uint) testUser {
+	a.t.Helper()
+	var body struct {
+		AccessToken string      `json:"access_token"`
+		User        models.User `json:"user"`
+	}
+	rec := a.do("", http.MethodPost, "/api/v1/auth/dev-login", gin.H{"email": email, "role": role, "department_id": departmentID})
+	expect(a.t, rec, http.StatusOK, &body)
+	if body.User.Role != role {
+		a.t.Fatalf("signed in as %s, want %s", body.User.Role, role)
+	}
+	return testUser{id: body.User.ID, token: body.AccessToken}
+}
+
+func (a *testAPI) department(name string) uint {
+	a.t.Helper()
+	dept := &models.Department{Name: name}
+	if err := a.store.repositories().Medical.CreateDepartment(dept); err != nil {
+		a.t.Fatal(err)
+	}
+	return dept.ID
+}
+
+// bookableSlot returns a slot of the default schedule: the given hour, in UTC, on the first Monday
+// at least two days ahead
+func bookableSlot(hour int) time.Time {
+	day := time.Now().UTC().AddDate(0, 0, 2)
+	for day.Weekday() != time.Monday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, time.UTC)
+}
+
+func (a *testAPI) book(patient testUser, doctorID uint, at time.Time) *httptest.ResponseRecorder {
+	a.t.Helper()
+	return a.do(patient.token, http.MethodPost, "/api/v1/appointments", gin.H{"doctor_id": doctorID, "date": at.Format(time.RFC3339)})
+}
+
+func errorMessage(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Error
+}
+
+func TestAuthentication(t *testing.T) {
+	api := newTestAPI(t)
+	patient := api.login("alice@example.org", models.RolePatient, 0)
+
+	foreign, err := utils.NewLocalJWT(strings.Repeat("x", 32), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _, err := foreign.Issue(utils.Claims{Email: "alice@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, _, err := api.localJWT.Issue(utils.Claims{Email: "alice@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "dev login token", token: patient.token, want: http.StatusOK},
+		{name: "without a token", token: "", want: http.StatusUnauthorized},
+		{name: "malformed token", token: "not-a-token", want: http.StatusUnauthorized},
+		{name: "token signed with another secret", token: forged, want: http.StatusUnauthorized},
+		{name: "identity provider token instead of a session", token: idToken, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := api.do(tt.token, http.MethodGet, "/api/v1/profile", nil); rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("dev login validates the role", func(t *testing.T) {
+		rec := api.do("", http.MethodPost, "/api/v1/auth/dev-login", gin.H{"email": "eve@example.org", "role": "superuser"})
+		expect(t, rec, http.StatusBadRequest, nil)
+		rec = api.do("", http.MethodPost, "/api/v1/auth/dev-login", gin.H{"email": "eve@example.org", "role": models.RoleDoctor})
+		expect(t, rec, http.StatusBadRequest, nil)
+	})
+
+	t.Run("accounts stay with the identity they signed up with", func(t *testing.T) {
+		repos := api.store.repositories()
+		users := services.NewUserService(repos.Users, repos.Medical, &memoryUnitOfWork{s: api.store})
+		var forbidden *services.ForbiddenError
+
+		// alice signed up through the dev login, whose identities are local ones
+		other := &utils.Claims{Issuer: "https://sso.other.example.org", Subject: "alice", Email: "alice@example.org"}
+		if _, err := users.GetOrCreateUserByClaims(other); !errors.As(err, &forbidden) {
+			t.Errorf("expected a sign-in through another issuer to be refused, got %v", err)
+		}
+		reassigned := &utils.Claims{Issuer: utils.LocalIssuer, Subject: "someone-else", Email: "alice@example.org"}
+		if _, err := users.GetOrCreateUserByClaims(reassigned); !errors.As(err, &forbidden) {
+			t.Errorf("expected a sign-in as another subject to be refused, got %v", err)
+		}
+		if user, err := users.GetOrCreateUserByClaims(&utils.Claims{Issuer: utils.LocalIssuer, Subject: "alice@example.org", Email: "alice@example.org"}); err != nil || user.ID != patient.id {
+			t.Errorf("expected the original identity to sign in, got %+v, %v", user, err)
+		}
+
+		// New accounts record the identity they were created with
+		user, err := users.GetOrCreateUserByClaims(&utils.Claims{Issuer: other.Issuer, Subject: "bob", Email: "bob@example.org"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := api.store.users[user.ID]; stored.Issuer != other.Issuer || stored.Subject != "bob" {
+			t.Errorf("got identity %q/%q, want %q/bob", stored.Issuer, stored.Subject, other.Issuer)
+		}
+	})
+}
+
+func TestRoleAccess(t *testing.T) {
+	api := newTestAPI(t)
+	dept := api.department("Cardiology")
+	roles := []models.UserRole{models.RoleAdmin, models.RoleDoctor, models.RolePatient, models.RolePharmacist}
+	tokens := map[models.UserRole]string{
+		models.RoleAdmin:      api.login("admin@example.org", models.RoleAdmin, 0).token,
+		models.RoleDoctor:     api.login("house@example.org", models.RoleDoctor, dept).token,
+		models.RolePatient:    api.login("alice@example.org", models.RolePatient, 0).token,
+		models.RolePharmacist: api.login("pharmacy@example.org", models.RolePharmacist, 0).token,
+	}
+
+	// Allowed requests may still fail in the handler, e.g. with a 404 for a missing record
+	tests := []struct {
+		method  string
+		path    string
+		body    interface{}
+		allowed []models.UserRole
+	}{
+		{method: http.MethodGet, path: "/api/v1/profile", allowed: roles},
+		{method: http.MethodGet, path: "/api/v1/users", allowed: []models.UserRole{models.RoleAdmin}},
+		{method: http.MethodPost, path: "/api/v1/departments", body: gin.H{"name": "Neurology"}, allowed: []models.UserRole{models.RoleAdmin}},
+		{method: http.MethodGet, path: "/api/v1/departments", allowed: []models.UserRole{models.RoleAdmin, models.RolePatient}},
+		{method: http.MethodGet, path: "/api/v1/patients", allowed: []models.UserRole{models.RoleAdmin, models.RoleDoctor}},
+		{method: http.MethodGet, path: "/api/v1/appointments", allowed: []models.UserRole{models.RoleAdmin, models.RoleDoctor, models.RolePatient}},
+		{method: http.MethodPut, path: "/api/v1/appointments/999/status", body: gin.H{"status": models.StatusCheckedIn}, allowed: []models.UserRole{models.RoleAdmin, models.RoleDoctor}},
+		{method: http.MethodPut, path: "/api/v1/appointments/999/complete", body: gin.H{}, allowed: []models.UserRole{models.RoleAdmin, models.RoleDoctor}},
+		{method: http.MethodGet, path: "/api/v1/prescriptions", allowed: []models.UserRole{models.RoleAdmin, models.RoleDoctor, models.RolePatient}},
+		{method: http.MethodGet, path: "/api/v1/pharmacy/prescriptions/UNKNOWN", allowed: []models.UserRole{models.RoleAdmin, models.RolePharmacist}},
+		{method: http.MethodPost, path: "/api/v1/pharmacy/prescriptions/UNKNOWN/dispenses", body: gin.H{"quantity": 1}, allowed: []models.UserRole{models.RoleAdmin, models.RolePharmacist}},
+	}
+	for _, tt := range tests {
+		for _, role := range roles {
+			t.Run(string(role)+" "+tt.method+" "+tt.path, func(t *testing.T) {
+				rec := api.do(tokens[role], tt.method, tt.path, tt.body)
+				if slices.Contains(tt.allowed, role) {
+					if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden || rec.Code >= 500 {
+						t.Errorf("expected access, got status %d: %s", rec.Code, rec.Body.String())
+					}
+				} else if rec.Code != http.StatusForbidden {
+					t.Errorf("expected status 403, got %d: %s", rec.Code, rec.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.login("admin@example.org", models.RoleAdmin, 0)
+	user := api.login("pharmacy@example.org", models.RolePatient, 0)
+
+	rec := api.do(admin.token, http.MethodPut, "/api/v1/users/"+itoa(user.id)+"/role", gin.H{"role": "pharmacists"})
+	expect(t, rec, http.StatusBadRequest, nil)
+	if role := api.store.users[user.id].Role; role != models.RolePatient {
+		t.Errorf("role changed to %q by a rejected update", role)
+	}
+
+	rec = api.do(admin.token, http.MethodPut, "/api/v1/users/"+itoa(user.id)+"/role", gin.H{"role": models.RolePharmacist})
+	expect(t, rec, http.StatusOK, nil)
+	if role := api.store.users[user.id].Role; role != models.RolePharmacist {
+		t.Errorf("got role %q, want %q", role, models.RolePharmacist)
+	}
+}
+
+func TestRoleRulesOnLogin(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.login("admin@example.org", models.RoleAdmin, 0)
+	cardiology := api.department("Cardiology")
+	neurology := api.department("Neurology")
+
+	const issuer = "https://sso.hospital.example.org"
+	config.AppConfig.RoleRules = []config.RoleRule{
+		{Issuer: issuer, Group: "cardiologists", Role: string(models.RoleDoctor), DepartmentID: cardiology},
+		{Issuer: issuer, Group: "neurologists", Role: string(models.RoleDoctor), DepartmentID: neurology},
+	}
+	repos := api.store.repositories()
+	newService := func(sync bool) services.UserService {
+		config.AppConfig.RoleSyncOnLogin = sync
+		return services.NewUserService(repos.Users, repos.Medical, &memoryUnitOfWork{s: api.store})
+	}
+	signIn := func(users services.UserService, email string, groups ...string) *models.User {
+		t.Helper()
+		user, err := users.GetOrCreateUserByClaims(&utils.Claims{Issuer: issuer, Subject: email, Email: email, Groups: groups})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	events := func(id uint) []models.UserRoleEvent {
+		t.Helper()
+		var events []models.UserRoleEvent
+		expect(t, api.do(admin.token, http.MethodGet, "/api/v1/users/"+itoa(id)+"/role-events", nil), http.StatusOK, &events)
+		return events
+	}
+
+	t.Run("applied on first login only", func(t *testing.T) {
+		users := newService(false)
+		user := signIn(users, "house@example.org", "cardiologists")
+		if user.Role != models.RoleDoctor || user.RoleSource != models.RoleSourceRule || api.store.doctors[user.ID].DepartmentID != cardiology {
+			t.Fatalf("got %+v in department %d, want a cardiologist", user, api.store.doctors[user.ID].DepartmentID)
+		}
+		if got := events(user.ID); len(got) != 1 || got[0].FromRole != "" || got[0].ToRole != models.RoleDoctor || *got[0].DepartmentID != cardiology {
+			t.Errorf("unexpected role events %+v", got)
+		}
+
+		if user = signIn(users, "house@example.org"); user.Role != models.RoleDoctor {
+			t.Errorf("role changed to %s without re-sync", user.Role)
+		}
+		if user = signIn(users, "alice@example.org", "nurses"); user.Role != models.RolePatient || user.RoleSource != models.RoleSourceDefault {
+			t.Errorf("got %+v, want a patient", user)
+		}
+	})
+
+	t.Run("groups of another issuer grant nothing", func(t *testing.T) {
+		users := newService(false)
+		claims := &utils.Claims{Issuer: "https://sso.partner.example.org", Subject: "mallory", Email: "mallory@example.org", Groups: []string{"cardiologists"}}
+		user, err := users.GetOrCreateUserByClaims(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != models.RolePatient || user.RoleSource != models.RoleSourceDefault {
+			t.Errorf("got %+v, want a patient", user)
+		}
+	})
+
+	t.Run("re-synced on every login", func(t *testing.T) {
+		users := newService(true)
+		user := signIn(users, "wilson@example.org", "cardiologists")
+
+		user = signIn(users, "wilson@example.org", "neurologists")
+		if user.Role != models.RoleDoctor || api.store.doctors[user.ID].DepartmentID != neurology {
+			t.Errorf("expected a move to neurology, got department %d", api.store.doctors[user.ID].DepartmentID)
+		}
+		user = signIn(users, "wilson@example.org", "alumni")
+		if user.Role != models.RolePatient || api.store.patients[user.ID] == nil {
+			t.Errorf("expected the rule granted role to be taken back, got %s", user.Role)
+		}
+		got := events(user.ID)
+		if len(got) != 3 || got[2].FromRole != models.RoleDoctor || got[2].ToRole != models.RolePatient {
+			t.Errorf("unexpected role events %+v", got)
+		}
+
+		// Logging in again without changes writes nothing
+		signIn(users, "wilson@example.org", "alumni")
+		if len(events(user.ID)) != 3 {
+			t.Error("expected no role event for an unchanged role")
+		}
+
+		// Roles set by an admin are kept, whether a rule matches or not
+		expect(t, api.do(admin.token, http.MethodPut, "/api/v1/users/"+itoa(user.ID)+"/role", gin.H{"role": models.RolePharmacist}), http.StatusOK, nil)
+		if user = signIn(users, "wilson@example.org", "alumni"); user.Role != models.RolePharmacist {
+			t.Errorf("admin assigned role replaced by %s", user.Role)
+		}
+		if user = signIn(users, "wilson@example.org", "cardiologists"); user.Role != models.RolePharmacist || user.RoleSource != models.RoleSourceAdmin {
+			t.Errorf("admin assigned role replaced by %s from %s", user.Role, user.RoleSource)
+		}
+		if len(events(user.ID)) != 3 {
+			t.Error("expected no role event for an admin assigned role")
+		}
+	})
+}
+
+// sessionResponse is the body of the token exchange, refresh and dev login endpoints
+type sessionResponse struct {
+	AccessToken  string      `json:"access_token"`
+	RefreshToken string      `json:"refresh_token"`
+	User         models.User `json:"user"`
+}
+
+func TestSessions(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.login("admin@example.org", models.RoleAdmin, 0)
+
+	exchange := func(email string) sessionResponse {
+		t.Helper()
+		idToken, _, err := api.localJWT.Issue(utils.Claims{Email: email, Name: "Alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var session sessionResponse
+		expect(t, api.do("", http.MethodPost, "/api/v1/auth/token", gin.H{"id_token": idToken}), http.StatusOK, &session)
+		return session
+	}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return api.do("", http.MethodPost, "/api/v1/auth/refresh", gin.H{"refresh_token": token})
+	}
+	profile := func(token string) int {
+		return api.do(token, http.MethodGet, "/api/v1/profile", nil).Code
+	}
+
+	t.Run("exchange rejects tokens of unknown issuers", func(t *testing.T) {
+		foreign, err := utils.NewLocalJWT(strings.Repeat("x", 32), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged, _, err := foreign.Issue(utils.Claims{Email: "alice@example.org"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, api.do("", http.MethodPost, "/api/v1/auth/token", gin.H{"id_token": forged}), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("refresh tokens rotate and a reused one ends the session", func(t *testing.T) {
+		first := exchange("alice@example.org")
+		if first.User.Email != "alice@example.org" || first.User.Role != models.RolePatient || profile(first.AccessToken) != http.StatusOK {
+			t.Fatalf("unexpected session %+v", first)
+		}
+
+		var second sessionResponse
+		expect(t, refresh(first.RefreshToken), http.StatusOK, &second)
+		if second.RefreshToken == first.RefreshToken || profile(second.AccessToken) != http.StatusOK {
+			t.Fatal("expected a new working token pair")
+		}
+
+		// The first refresh token was used already: whoever presents it again is not the client
+		expect(t, refresh(first.RefreshToken), http.StatusUnauthorized, nil)
+		expect(t, refresh(second.RefreshToken), http.StatusUnauthorized, nil)
+		if got := profile(second.AccessToken); got != http.StatusUnauthorized {
+			t.Errorf("access token of the revoked session got status %d", got)
+		}
+		expect(t, refresh("not-a-token"), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("a guessed secret does not end the session", func(t *testing.T) {
+		session := exchange("grace@example.org")
+		id, _, _ := strings.Cut(session.RefreshToken, ".")
+		expect(t, refresh(id+".x"), http.StatusUnauthorized, nil)
+
+		var next sessionResponse
+		expect(t, refresh(session.RefreshToken), http.StatusOK, &next)
+		if profile(next.AccessToken) != http.StatusOK {
+			t.Error("expected the session to survive a wrong secret")
+		}
+	})
+
+	t.Run("logout ends only the current session", func(t *testing.T) {
+		laptop, phone := exchange("bob@example.org"), exchange("bob@example.org")
+		expect(t, api.do(laptop.AccessToken, http.MethodPost, "/api/v1/auth/logout", nil), http.StatusOK, nil)
+		if profile(laptop.AccessToken) != http.StatusUnauthorized || profile(phone.AccessToken) != http.StatusOK {
+			t.Error("expected only the laptop to be logged out")
+		}
+		expect(t, refresh(laptop.RefreshToken), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("logout of all devices", func(t *testing.T) {
+		laptop, phone := exchange("carol@example.org"), exchange("carol@example.org")
+		var body struct {
+			Sessions int `json:"sessions"`
+		}
+		expect(t, api.do(phone.AccessToken, http.MethodPost, "/api/v1/auth/logout-all", nil), http.StatusOK, &body)
+		if body.Sessions != 2 || profile(laptop.AccessToken) != http.StatusUnauthorized || profile(phone.AccessToken) != http.StatusUnauthorized {
+			t.Errorf("expected both sessions to end, %d were revoked", body.Sessions)
+		}
+	})
+
+	t.Run("admins list and revoke sessions", func(t *testing.T) {
+		laptop, phone := exchange("dave@example.org"), exchange("dave@example.org")
+		var sessions []models.Session
+		expect(t, api.do(admin.token, http.MethodGet, "/api/v1/users/"+itoa(laptop.User.ID)+"/sessions", nil), http.StatusOK, &sessions)
+		if len(sessions) != 2 {
+			t.Fatalf("got %d sessions, want 2", len(sessions))
+		}
+
+		expect(t, api.do(admin.token, http.MethodDelete, "/api/v1/sessions/"+itoa(sessions[0].ID), nil), http.StatusOK, nil)
+		expect(t, api.do(admin.token, http.MethodGet, "/api/v1/users/"+itoa(laptop.User.ID)+"/sessions", nil), http.StatusOK, &sessions)
+		if len(sessions) != 1 {
+			t.Errorf("got %d sessions after revoking one, want 1", len(sessions))
+		}
+
+		expect(t, api.do(admin.token, http.MethodDelete, "/api/v1/users/"+itoa(laptop.User.ID)+"/sessions", nil), http.StatusOK, nil)
+		if profile(laptop.AccessToken) != http.StatusUnauthorized || profile(phone.AccessToken) != http.StatusUnauthorized {
+			t.Error("expected every session to be revoked")
+		}
+		expect(t, api.do(admin.token, http.MethodDelete, "/api/v1/sessions/9999", nil), http.StatusNotFound, nil)
+		expect(t, api.do(laptop.AccessToken, http.MethodGet, "/api/v1/users/"+itoa(laptop.User.ID)+"/sessions", nil), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("role changes apply to open sessions", func(t *testing.T) {
+		session := exchange("erin@example.org")
+		expect(t, api.do(session.AccessToken, http.MethodGet, "/api/v1/pharmacy/prescriptions/UNKNOWN", nil), http.StatusForbidden, nil)
+		expect(t, api.do(admin.token, http.MethodPut, "/api/v1/users/"+itoa(session.User.ID)+"/role", gin.H{"role": models.RolePharmacist}), http.StatusOK, nil)
+		expect(t, api.do(session.AccessToken, http.MethodGet, "/api/v1/pharmacy/prescriptions/UNKNOWN", nil), http.StatusNotFound, nil)
+	})
+}
+
+func TestServiceAccounts(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.login("admin@example.org", models.RoleAdmin, 0)
+
+	var account models.ServiceAccount
+	expect(t, api.do(admin.token, http.MethodPost, "/api/v1/service-accounts", gin.H{"name": "pharmacy-kiosk", "description": "Kiosk at the front desk"}), http.StatusCreated, &account)
+	accountPath := "/api/v1/service-accounts/" + itoa(account.ID)
+
+	type issuedKey struct {
+		ID         uint       `json:"id"`
+		Key        string     `json:"key"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+	}
+	createKey := func(body gin.H) issuedKey {
+		t.Helper()
+		var key issuedKey
+		expect(t, api.do(admin.token, http.MethodPost, accountPath+"/keys", body), http.StatusCreated, &key)
+		return key
+	}
+	lookup := func(key string) int {
+		return api.doWithAPIKey(key, http.MethodGet, "/api/v1/pharmacy/prescriptions/UNKNOWN", nil).Code
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		expect(t, api.do(admin.token, http.MethodPost, "/api/v1/service-accounts", gin.H{"name": "pharmacy-kiosk"}), http.StatusConflict, nil)
+		expect(t, api.do(admin.token, http.MethodPost, "/api/v1/service-accounts", gin.H{"name": "Lab System!"}), http.StatusBadRequest, nil)
+		expect(t, api.do(admin.token, http.MethodPost, accountPath+"/keys", gin.H{"scopes": []string{"everything"}}), http.StatusBadRequest, nil)
+		expect(t, api.do(admin.token, http.MethodPost, accountPath+"/keys", gin.H{"scopes": []string{}}), http.StatusBadRequest, nil)
+		expect(t, api.do(admin.token, http.MethodPost, accountPath+"/keys", gin.H{"scopes": []string{"pharmacy"}, "expires_at": time.Now().Add(-time.Hour)}), http.StatusBadRequest, nil)
+		// The user behind a service account cannot be given a role
+		expect(t, api.do(admin.token, http.MethodPut, "/api/v1/users/"+itoa(account.UserID)+"/role", gin.H{"role": models.RoleAdmin}), http.StatusBadRequest, nil)
+	})
+
+	t.Run("scopes decide what a key may do", func(t *testing.T) {
+		key := createKey(gin.H{"name": "kiosk", "scopes": []string{"pharmacy", "patients:read"}})
+		if got := lookup(key.Key); got != http.StatusNotFound {
+			t.Errorf("pharmacy lookup got status %d, want 404", got)
+		}
+		expect(t, api.doWithAPIKey(key.Key, http.MethodGet, "/api/v1/patients", nil), http.StatusOK, nil)
+		for _, path := range []string{"/api/v1/appointments", "/api/v1/users", "/api/v1/profile", "/api/v1/service-accounts"} {
+			expect(t, api.doWithAPIKey(key.Key, http.MethodGet, path, nil), http.StatusForbidden, nil)
+		}
+		if got := lookup(key.Key + "x"); got != http.StatusUnauthorized {
+			t.Errorf("wrong secret got status %d", got)
+		}
+		if got := lookup("not-a-key"); got != http.StatusUnauthorized {
+			t.Errorf("malformed key got status %d", got)
+		}
+		// API keys are not bearer tokens
+		expect(t, api.do(key.Key, http.MethodGet, "/api/v1/patients", nil), http.StatusUnauthorized, nil)
+
+		var got models.ServiceAccount
+		expect(t, api.do(admin.token, http.MethodGet, accountPath, nil), http.StatusOK, &got)
+		if len(got.Keys) != 1 || got.Keys[0].LastUsedAt == nil {
+			t.Errorf("expected the use of the key to be recorded, got %+v", got.Keys)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		old := createKey(gin.H{"scopes": []string{"pharmacy"}})
+		var rotated issuedKey
+		expect(t, api.do(admin.token, http.MethodPut, accountPath+"/keys/"+itoa(old.ID)+"/rotate", gin.H{"grace_period": "1h"}), http.StatusOK, &rotated)
+		if lookup(old.Key) != http.StatusNotFound || lookup(rotated.Key) != http.StatusNotFound {
+			t.Error("expected both keys to work during the grace period")
+		}
+
+		expect(t, api.do(admin.token, http.MethodPut, accountPath+"/keys/"+itoa(rotated.ID)+"/rotate", nil), http.StatusOK, &rotated)
+		if lookup(rotated.Key) != http.StatusNotFound {
+			t.Error("expected the new key to work")
+		}
+		if got := lookup(old.Key); got != http.StatusNotFound {
+			t.Errorf("first key got status %d, its grace period is not over", got)
+		}
+		expect(t, api.do(admin.token, http.MethodPut, accountPath+"/keys/"+itoa(old.ID)+"/rotate", gin.H{"grace_period": "soon"}), http.StatusBadRequest, nil)
+	})
+
+	t.Run("expiry and revocation", func(t *testing.T) {
+		key := createKey(gin.H{"scopes": []string{"pharmacy"}, "expires_at": time.Now().Add(time.Hour)})
+		expired := time.Now().Add(-time.Second)
+		api.store.apiKeys[key.ID].ExpiresAt = &expired
+		if got := lookup(key.Key); got != http.StatusUnauthorized {
+			t.Errorf("expired key got status %d", got)
+		}
+
+		key = createKey(gin.H{"scopes": []string{"pharmacy"}})
+		expect(t, api.do(admin.token, http.MethodDelete, accountPath+"/keys/"+itoa(key.ID), nil), http.StatusOK, nil)
+		if got := lookup(key.Key); got != http.StatusUnauthorized {
+			t.Errorf("revoked key got status %d", got)
+		}
+		expect(t, api.do(admin.token, http.MethodPut, accountPath+"/keys/"+itoa(key.ID)+"/rotate", nil), http.StatusConflict, nil)
+	})
+
+	t.Run("disabling the account revokes its keys", func(t *testing.T) {
+		key := createKey(gin.H{"scopes": []string{"pharmacy"}})
+		expect(t, api.do(admin.token, http.MethodDelete, accountPath, nil), http.StatusOK, nil)
+		if got := lookup(key.Key); got != http.StatusUnauthorized {
+			t.Errorf("key of a disabled account got status %d", got)
+		}
+		expect(t, api.do(admin.token, http.MethodPost, accountPath+"/keys", gin.H{"scopes": []string{"pharmacy"}}), http.StatusConflict, nil)
+	})
+}
+
+func TestBookingConflicts(t *testing.T) {
+	api := newTestAPI(t)
+	dept := api.department("Cardiology")
+	house := api.login("house@example.org", models.RoleDoctor, dept)
+	wilson := api.login("wilson@example.org", models.RoleDoctor, dept)
+	alice := api.login("alice@example.org", models.RolePatient, 0)
+	bob := api.login("bob@example.org", models.RolePatient, 0)
+	slot := bookableSlot(10)
+
+	var booked models.Appointment
+	expect(t, api.book(alice, house.id, slot), http.StatusCreated, &booked)
+	if booked.Status != models.StatusScheduled || !booked.EndDate.Equal(slot.Add(services.DefaultAppointmentDuration)) {
+		t.Fatalf("unexpected appointment %+v", booked)
+	}
+
+	tests := []struct {
+		name      string
+		patient   testUser
+		doctorID  uint
+		at        time.Time
+		want      int
+		wantError string
+	}{
+		{name: "same patient and doctor", patient: alice, doctorID: house.id, at: slot, want: http.StatusConflict, wantError: "patient already has an appointment with this doctor"},
+		{name: "doctor already booked", patient: bob, doctorID: house.id, at: slot, want: http.StatusConflict, wantError: "doctor already has an appointment"},
+		{name: "patient already booked", patient: alice, doctorID: wilson.id, at: slot, want: http.StatusConflict, wantError: "patient already has an appointment at"},
+		{name: "off the slot grid", patient: bob, doctorID: house.id, at: slot.Add(10 * time.Minute), want: http.StatusBadRequest, wantError: "outside the doctor's schedule"},
+		{name: "in the past", patient: bob, doctorID: house.id, at: slot.AddDate(0, 0, -14), want: http.StatusBadRequest, wantError: "past"},
+		{name: "unknown doctor", patient: bob, doctorID: 999, at: slot, want: http.StatusNotFound},
+		{name: "next slot", patient: bob, doctorID: house.id, at: slot.Add(services.DefaultAppointmentDuration), want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.book(tt.patient, tt.doctorID, tt.at)
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(errorMessage(rec), tt.wantError) {
+				t.Errorf("error %q does not mention %q", errorMessage(rec), tt.wantError)
+			}
+		})
+	}
+
+	t.Run("cancelled slot is free again", func(t *testing.T) {
+		rec := api.do(bob.token, http.MethodPut, "/api/v1/appointments/"+itoa(booked.ID)+"/cancel", nil)
+		expect(t, rec, http.StatusForbidden, nil)
+
+		rec = api.do(alice.token, http.MethodPut, "/api/v1/appointments/"+itoa(booked.ID)+"/cancel", gin.H{"reason": "feeling better"})
+		expect(t, rec, http.StatusOK, nil)
+		expect(t, api.book(bob, house.id, slot.Add(-services.DefaultAppointmentDuration)), http.StatusCreated, nil)
+		expect(t, api.book(alice, house.id, slot), http.StatusCreated, nil)
+	})
+}
+
+func TestAppointmentStateMachine(t *testing.T) {
+	api := newTestAPI(t)
+	dept := api.department("Cardiology")
+	house := api.login("house@example.org", models.RoleDoctor, dept)
+	wilson := api.login("wilson@example.org", models.RoleDoctor, dept)
+	alice := api.login("alice@example.org", models.RolePatient, 0)
+
+	var appt models.Appointment
+	expect(t, api.book(alice, house.id, bookableSlot(11)), http.StatusCreated, &appt)
+	statusPath := "/api/v1/appointments/" + itoa(appt.ID) + "/status"
+
+	steps := []struct {
+		name   string
+		user   testUser
+		status models.AppointmentStatus
+		want   int
+	}{
+		{name: "patient cannot drive the visit", user: alice, status: models.StatusCheckedIn, want: http.StatusForbidden},
+		{name: "another doctor cannot drive the visit", user: wilson, status: models.StatusCheckedIn, want: http.StatusForbidden},
+		{name: "unknown status", user: house, status: "Teleported", want: http.StatusBadRequest},
+		{name: "check in", user: house, status: models.StatusCheckedIn, want: http.StatusOK},
+		{name: "back to scheduled", user: house, status: models.StatusScheduled, want: http.StatusConflict},
+		{name: "start the visit", user: house, status: models.StatusInProgress, want: http.StatusOK},
+		{name: "no-show once in progress", user: house, status: models.StatusNoShow, want: http.StatusConflict},
+		{name: "cancel once in progress", user: house, status: models.StatusCancelled, want: http.StatusConflict},
+	}
+	for _, step := range steps {
+		rec := api.do(step.user.token, http.MethodPut, statusPath, gin.H{"status": step.status})
+		if rec.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, rec.Code, step.want, rec.Body.String())
+		}
+	}
+
+	rec := api.do(house.token, http.MethodPut, "/api/v1/appointments/"+itoa(appt.ID)+"/complete", gin.H{"diagnosis": "Hypertension"})
+	expect(t, rec, http.StatusOK, nil)
+	rec = api.do(alice.token, http.MethodPut, "/api/v1/appointments/"+itoa(appt.ID)+"/cancel", nil)
+	expect(t, rec, http.StatusConflict, nil)
+	rec = api.do(house.token, http.MethodPut, "/api/v1/appointments/"+itoa(appt.ID)+"/complete", gin.H{"diagnosis": "Hypertension"})
+	expect(t, rec, http.StatusConflict, nil)
+
+	var events []models.AppointmentStatusEvent
+	for _, e := range api.store.statusEvents {
+		if e.AppointmentID == appt.ID {
+			events = append(events, e)
+		}
+	}
+	want := []models.AppointmentStatus{models.StatusCheckedIn, models.StatusInProgress, models.StatusCompleted}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d status events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.ToStatus != want[i] || e.ActorID == nil || *e.ActorID != house.id {
+			t.Errorf("event %d moved to %s by %v, want %s by %d", i, e.ToStatus, e.ActorID, want[i], house.id)
+		}
+	}
+}
+
+func TestDispensePrescription(t *testing.T) {
+	api := newTestAPI(t)
+	dept := api.department("General Medicine")
+	house := api.login("house@example.org", models.RoleDoctor, dept)
+	alice := api.login("alice@example.org", models.RolePatient, 0)
+	pharmacist := api.login("pharmacy@example.org", models.RolePharmacist, 0)
+
+	var appt models.Appointment
+	expect(t, api.book(alice, house.id, bookableSlot(14)), http.StatusCreated, &appt)
+	rec := api.do(house.token, http.MethodPut, "/api/v1/appointments/"+itoa(appt.ID)+"/complete", gin.H{
+		"diagnosis": "Strep throat",
+		"medications": []gin.H{{
+			"medication":    "Amoxicillin",
+			"strength":      500,
+			"strength_unit": "mg",
+			"route":         models.RouteOral,
+			"frequency":     models.FrequencyTwiceDaily,
+			"duration_days": 5,
+		}},
+	})
+	expect(t, rec, http.StatusOK, nil)
+
+	var prescriptions []models.Prescription
+	expect(t, api.do(alice.token, http.MethodGet, "/api/v1/prescriptions", nil), http.StatusOK, &prescriptions)
+	if len(prescriptions) != 1 || prescriptions[0].Quantity != 10 || prescriptions[0].Status != models.StatusIssued {
+		t.Fatalf("unexpected prescriptions %+v", prescriptions)
+	}
+	presc := prescriptions[0]
+	// Codes are typed in by hand at the counter, dashes and lower case are accepted
+	code := strings.ToLower(presc.VerificationCode[:6] + "-" + presc.VerificationCode[6:])
+	dispensePath := "/api/v1/pharmacy/prescriptions/" + code + "/dispenses"
+
+	t.Run("only the dispense endpoint sets dispensing statuses", func(t *testing.T) {
+		rec := api.do(house.token, http.MethodPut, "/api/v1/prescriptions/"+itoa(presc.ID), gin.H{"status": models.StatusDispensed})
+		expect(t, rec, http.StatusBadRequest, nil)
+	})
+	t.Run("patients cannot dispense", func(t *testing.T) {
+		expect(t, api.do(alice.token, http.MethodPost, dispensePath, gin.H{"quantity": 10}), http.StatusForbidden, nil)
+	})
+
+	var view services.PharmacyPrescription
+	expect(t, api.do(pharmacist.token, http.MethodGet, "/api/v1/pharmacy/prescriptions/"+code, nil), http.StatusOK, &view)
+	if view.ID != presc.ID || view.Quantity != 10 || view.Dispensed != 0 {
+		t.Fatalf("unexpected lookup %+v", view)
+	}
+
+	steps := []struct {
+		name          string
+		quantity      int
+		final         bool
+		want          int
+		wantStatus    models.PrescriptionStatus
+		wantDispensed int
+	}{
+		{name: "nothing", quantity: 0, want: http.StatusBadRequest},
+		{name: "first part", quantity: 4, want: http.StatusOK, wantStatus: models.StatusPartiallyDispensed, wantDispensed: 4},
+		{name: "second part", quantity: 4, want: http.StatusOK, wantStatus: models.StatusPartiallyDispensed, wantDispensed: 8},
+		{name: "more than remains", quantity: 3, want: http.StatusBadRequest},
+		{name: "the rest", quantity: 2, want: http.StatusOK, wantStatus: models.StatusDispensed, wantDispensed: 10},
+		{name: "after the last part", quantity: 1, final: true, want: http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rec := api.do(pharmacist.token, http.MethodPost, dispensePath, gin.H{"quantity": step.quantity, "final": step.final})
+		if rec.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, rec.Code, step.want, rec.Body.String())
+		}
+		if step.want != http.StatusOK {
+			continue
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+			t.Fatal(err)
+		}
+		if view.Status != step.wantStatus || view.Dispensed != step.wantDispensed {
+			t.Fatalf("%s: status %s with %d dispensed, want %s with %d", step.name, view.Status, view.Dispensed, step.wantStatus, step.wantDispensed)
+		}
+	}
+	if len(view.Dispenses) != 3 {
+		t.Errorf("recorded %d dispenses, want 3", len(view.Dispenses))
+	}
+	for _, d := range view.Dispenses {
+		if d.PharmacistID != pharmacist.id {
+			t.Errorf("dispense %d recorded for pharmacist %d, want %d", d.ID, d.PharmacistID, pharmacist.id)
+		}
+	}
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
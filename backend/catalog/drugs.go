@@ -0,0 +1,102 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// DrugCatalog is the content of a drug catalog file
+type DrugCatalog struct {
+	Drugs        []models.Drug
+	Interactions []models.DrugInteraction
+}
+
+// drugCatalogFile is the JSON layout of a drug catalog file:
+//
+//	{
+//	  "drugs": [{"code": "J01CA04-500", "name": "Amoxicillin 500 mg", "ingredient": "amoxicillin", "drug_class": "penicillin"}],
+//	  "interactions": [{"ingredient_a": "warfarin", "ingredient_b": "aspirin", "severity": "major", "description": "..."}]
+//	}
+type drugCatalogFile struct {
+	Drugs []struct {
+		Code       string `json:"code"`
+		Name       string `json:"name"`
+		Ingredient string `json:"ingredient"`
+		DrugClass  string `json:"drug_class"`
+	} `json:"drugs"`
+	Interactions []struct {
+		IngredientA string `json:"ingredient_a"`
+		IngredientB string `json:"ingredient_b"`
+		Severity    string `json:"severity"`
+		Description string `json:"description"`
+	} `json:"interactions"`
+}
+
+// LoadDrugCatalogFile reads a JSON drug catalog
+func LoadDrugCatalogFile(path string) (*DrugCatalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseDrugCatalogJSON(f)
+}
+
+// ParseDrugCatalogJSON parses a drug catalog, normalizing codes, ingredients and interaction pairs
+func ParseDrugCatalogJSON(r io.Reader) (*DrugCatalog, error) {
+	var file drugCatalogFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid drug catalog: %w", err)
+	}
+
+	cat := &DrugCatalog{}
+	for i, d := range file.Drugs {
+		drug := models.Drug{
+			Code:       NormalizeDrugCode(d.Code),
+			Name:       strings.TrimSpace(d.Name),
+			Ingredient: NormalizeIngredient(d.Ingredient),
+			DrugClass:  NormalizeIngredient(d.DrugClass),
+		}
+		if drug.Code == "" || drug.Name == "" || drug.Ingredient == "" {
+			return nil, fmt.Errorf("drug %d: code, name and ingredient are required", i+1)
+		}
+		cat.Drugs = append(cat.Drugs, drug)
+	}
+
+	for i, in := range file.Interactions {
+		a, b := NormalizeIngredient(in.IngredientA), NormalizeIngredient(in.IngredientB)
+		if a == "" || b == "" || a == b {
+			return nil, fmt.Errorf("interaction %d: two different ingredients are required", i+1)
+		}
+		if b < a {
+			a, b = b, a
+		}
+		severity := models.InteractionSeverity(strings.ToLower(strings.TrimSpace(in.Severity)))
+		switch severity {
+		case models.InteractionMinor, models.InteractionModerate, models.InteractionMajor, models.InteractionContraindicated:
+		default:
+			return nil, fmt.Errorf("interaction %d: unknown severity %q", i+1, in.Severity)
+		}
+		cat.Interactions = append(cat.Interactions, models.DrugInteraction{
+			IngredientA: a,
+			IngredientB: b,
+			Severity:    severity,
+			Description: strings.TrimSpace(in.Description),
+		})
+	}
+	return cat, nil
+}
+
+func NormalizeDrugCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// NormalizeIngredient lower-cases an ingredient or class name and collapses inner whitespace
+func NormalizeIngredient(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
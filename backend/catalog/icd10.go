@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// LoadICD10File reads an ICD-10 code list, choosing the parser from the file extension (.csv or .xml)
+func LoadICD10File(path string) ([]models.DiagnosisCode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ParseICD10CSV(f)
+	case ".xml":
+		return ParseICD10XML(f)
+	default:
+		return nil, fmt.Errorf("unsupported ICD-10 file %q, expected .csv or .xml", path)
+	}
+}
+
+// ParseICD10CSV parses "code,description" rows. A header row is skipped when present.
+func ParseICD10CSV(r io.Reader) ([]models.DiagnosisCode, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var codes []models.DiagnosisCode
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected code and description", line)
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "code") {
+			continue
+		}
+
+		code := NormalizeICD10Code(record[0])
+		if code == "" {
+			continue
+		}
+		codes = append(codes, models.DiagnosisCode{Code: code, Description: strings.TrimSpace(record[1])})
+	}
+	return codes, nil
+}
+
+// icd10Diag mirrors the nested <diag> elements of the CMS ICD-10-CM tabular XML
+type icd10Diag struct {
+	Name     string      `xml:"name"`
+	Desc     string      `xml:"desc"`
+	Children []icd10Diag `xml:"diag"`
+}
+
+// ParseICD10XML parses the CMS ICD-10-CM tabular format, collecting every (nested) <diag> element
+func ParseICD10XML(r io.Reader) ([]models.DiagnosisCode, error) {
+	decoder := xml.NewDecoder(r)
+
+	var codes []models.DiagnosisCode
+	var collect func(d icd10Diag)
+	collect = func(d icd10Diag) {
+		if code := NormalizeICD10Code(d.Name); code != "" {
+			codes = append(codes, models.DiagnosisCode{Code: code, Description: strings.TrimSpace(d.Desc)})
+		}
+		for _, child := range d.Children {
+			collect(child)
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "diag" {
+			continue
+		}
+		var diag icd10Diag
+		if err := decoder.DecodeElement(&diag, &start); err != nil {
+			return nil, err
+		}
+		collect(diag)
+	}
+	return codes, nil
+}
+
+// NormalizeICD10Code upper-cases a code and inserts the dot after the category ("e119" -> "E11.9")
+func NormalizeICD10Code(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, ".", "")
+	if len(code) > 3 {
+		code = code[:3] + "." + code[3:]
+	}
+	return code
+}
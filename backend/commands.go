@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/db"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"github.com/cristim67/med-monitor/backend/rxsign"
+	"github.com/cristim67/med-monitor/backend/services"
+)
+
+const commandUsage = `Usage: med_monitor_backend [command]
+
+Without a command the API server is started.
+
+Commands:
+  import-icd10 <file.csv|file.xml>   load or refresh the ICD-10 diagnosis code catalog
+  import-drugs <file.json>           load or refresh the drug catalog and its interactions
+  generate-signing-key               print a new PRESCRIPTION_SIGNING_KEY value`
+
+// runCommand executes a maintenance command against the already initialized database
+func runCommand(args []string) {
+	medicalService := services.NewMedicalService(repository.NewMedicalRepository(db.DB), repository.NewUnitOfWork(db.DB))
+
+	switch args[0] {
+	case "import-icd10":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, commandUsage)
+			os.Exit(2)
+		}
+		codes, err := catalog.LoadICD10File(args[1])
+		if err != nil {
+			log.Fatalf("Failed to read ICD-10 file: %v", err)
+		}
+		imported, err := medicalService.ImportDiagnosisCodes(codes)
+		if err != nil {
+			log.Fatalf("Failed to import ICD-10 codes: %v", err)
+		}
+		log.Printf("Imported %d ICD-10 codes from %s", imported, args[1])
+	case "import-drugs":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, commandUsage)
+			os.Exit(2)
+		}
+		cat, err := catalog.LoadDrugCatalogFile(args[1])
+		if err != nil {
+			log.Fatalf("Failed to read drug catalog: %v", err)
+		}
+		drugs, interactions, err := medicalService.ImportDrugCatalog(cat)
+		if err != nil {
+			log.Fatalf("Failed to import drug catalog: %v", err)
+		}
+		log.Printf("Imported %d drugs and %d interactions from %s", drugs, interactions, args[1])
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		os.Exit(2)
+	}
+}
+
+// generateSigningKey prints a new PRESCRIPTION_SIGNING_KEY, main runs it before loading the configuration
+func generateSigningKey() {
+	seed, err := rxsign.GenerateSeed()
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	fmt.Println(seed)
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +15,58 @@ type Config struct {
 	GoogleClientID string
 	Environment    string
 	Port           string
+	ClinicTimezone string
+
+	// No-show handling
+	NoShowGracePeriod   time.Duration // how long after its start a Scheduled appointment becomes a NoShow
+	NoShowSweepInterval time.Duration
+	NoShowLimit         int // no-shows within NoShowWindow that block new bookings, 0 disables the limit
+	NoShowWindow        time.Duration
+
+	// Prescriptions
+	PrescriptionValidity            time.Duration // default lifetime of an issued prescription
+	PrescriptionExpirySweepInterval time.Duration
+	PrescriptionSigningKey          string // base64 Ed25519 seed used to sign prescription QR payloads, required outside development
+
+	// OpenID Connect identity providers accepted next to Google
+	OIDCProviders   []OIDCProvider
+	OIDCKeyCacheTTL time.Duration // how long the signing keys of a provider are used before they are refetched
+
+	// API sessions started by exchanging an identity provider token
+	SessionSecret   string        // HS256 secret of the access tokens, required outside development
+	AccessTokenTTL  time.Duration // lifetime of an access token, the client refreshes it when it runs out
+	RefreshTokenTTL time.Duration // how long a session survives without being refreshed
+
+	// Roles assigned from the claims of the identity provider
+	RoleRules       []RoleRule
+	RoleSyncOnLogin bool // re-apply the rules on every login instead of only when the account is created
+
+	// Local authentication, only allowed when ENVIRONMENT is explicitly development or test
+	LocalAuthSecret   string // HS256 secret of locally issued tokens, empty disables local login
+	LocalAuthTokenTTL time.Duration
+}
+
+// OIDCProvider is one entry of OIDC_PROVIDERS. The claim names default to the standard ones
+// and may be dotted paths into nested claims, e.g. "realm_access.roles".
+type OIDCProvider struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	EmailClaim   string `json:"email_claim"`
+	NameClaim    string `json:"name_claim"`
+	PictureClaim string `json:"picture_claim"`
+	GroupsClaim  string `json:"groups_claim"`
+	TrustEmail   bool   `json:"trust_email"` // accept tokens without email_verified, see utils.OIDCConfig
+}
+
+// RoleRule is one entry of ROLE_RULES. It matches when all of its non-empty conditions hold;
+// the first matching rule decides the role.
+type RoleRule struct {
+	Issuer       string `json:"issuer"` // the identity provider whose tokens the rule applies to
+	Group        string `json:"group"`
+	HostedDomain string `json:"hosted_domain"`
+	EmailPattern string `json:"email_pattern"` // glob such as "*@cardiology.hospital.org"
+	Role         string `json:"role"`
+	DepartmentID uint   `json:"department_id"` // required for doctors
 }
 
 // AppConfig holds the global configs parsed from .env
@@ -26,16 +81,99 @@ func LoadConfig() {
 		GoogleClientID: os.Getenv("GOOGLE_CLIENT_ID"),
 		Environment:    os.Getenv("ENVIRONMENT"),
 		Port:           os.Getenv("PORT"),
+		ClinicTimezone: os.Getenv("CLINIC_TIMEZONE"),
+
+		NoShowGracePeriod:   getEnvDuration("NO_SHOW_GRACE_PERIOD", 30*time.Minute),
+		NoShowSweepInterval: getEnvDuration("NO_SHOW_SWEEP_INTERVAL", 5*time.Minute),
+		NoShowLimit:         getEnvInt("NO_SHOW_LIMIT", 0),
+		NoShowWindow:        getEnvDuration("NO_SHOW_WINDOW", 180*24*time.Hour),
+
+		PrescriptionValidity:            getEnvDuration("PRESCRIPTION_VALIDITY", 30*24*time.Hour),
+		PrescriptionExpirySweepInterval: getEnvDuration("PRESCRIPTION_EXPIRY_SWEEP_INTERVAL", time.Hour),
+		PrescriptionSigningKey:          os.Getenv("PRESCRIPTION_SIGNING_KEY"),
+
+		OIDCKeyCacheTTL: getEnvDuration("OIDC_KEY_CACHE_TTL", time.Hour),
+
+		SessionSecret:   os.Getenv("SESSION_SECRET"),
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		RoleSyncOnLogin: getEnvBool("ROLE_SYNC_ON_LOGIN", false),
+
+		LocalAuthSecret:   os.Getenv("LOCAL_AUTH_SECRET"),
+		LocalAuthTokenTTL: getEnvDuration("LOCAL_AUTH_TOKEN_TTL", 12*time.Hour),
 	}
 
 	if AppConfig.Port == "" {
 		AppConfig.Port = "8080"
 	}
+	if AppConfig.ClinicTimezone == "" {
+		AppConfig.ClinicTimezone = "UTC"
+	}
 	if AppConfig.Environment == "" {
 		AppConfig.Environment = "development"
 	}
 
+	if providers := os.Getenv("OIDC_PROVIDERS"); providers != "" {
+		if err := json.Unmarshal([]byte(providers), &AppConfig.OIDCProviders); err != nil {
+			log.Fatalf("Invalid OIDC_PROVIDERS, expected a JSON list of providers: %v", err)
+		}
+	}
+
+	if rules := os.Getenv("ROLE_RULES"); rules != "" {
+		if err := json.Unmarshal([]byte(rules), &AppConfig.RoleRules); err != nil {
+			log.Fatalf("Invalid ROLE_RULES, expected a JSON list of rules: %v", err)
+		}
+	}
+
+	// An unset ENVIRONMENT counts as development everywhere else, local login has to be asked for explicitly
+	if AppConfig.LocalAuthSecret != "" {
+		if env := os.Getenv("ENVIRONMENT"); env != "development" && env != "test" {
+			log.Fatalf("LOCAL_AUTH_SECRET requires ENVIRONMENT to be development or test, got %q", env)
+		}
+	}
+
 	if AppConfig.DatabaseURL == "" {
 		log.Println("WARNING: DATABASE_URL is not set!")
 	}
 }
+
+// getEnvDuration parses a Go duration (e.g. "30m", "4h") from the environment, falling back to def
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("WARNING: invalid %s %q, using %s", key, value, def)
+		return def
+	}
+	return d
+}
+
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: invalid %s %q, using %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("WARNING: invalid %s %q, using %t", key, value, def)
+		return def
+	}
+	return b
+}
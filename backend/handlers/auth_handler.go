@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/services"
+	"github.com/cristim67/med-monitor/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	userService services.UserService
+	verifier    utils.TokenVerifier
+}
+
+func NewAuthHandler(userService services.UserService, verifier utils.TokenVerifier) *AuthHandler {
+	return &AuthHandler{userService: userService, verifier: verifier}
+}
+
+func sessionClient(c *gin.Context) services.SessionClient {
+	return services.SessionClient{UserAgent: c.Request.UserAgent(), IPAddress: c.ClientIP()}
+}
+
+// ExchangeToken trades an ID token of a configured identity provider for an API session
+func (h *AuthHandler) ExchangeToken(c *gin.Context) {
+	var body struct {
+		IDToken string `json:"id_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := h.verifier.Verify(c.Request.Context(), body.IDToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := h.userService.ExchangeToken(claims, sessionClient(c))
+	var forbidden *services.ForbiddenError
+	if errors.As(err, &forbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error signing in user %s: %v", claims.Email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshToken issues a new token pair for a refresh token; the old refresh token stops working
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.userService.RefreshSession(body.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout ends the session of the access token
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := h.userService.EndSession(c.GetUint("user_id"), c.GetUint("session_id")); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAll ends every session of the signed-in user, this one included
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	count, err := h.userService.EndAllSessions(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all devices", "sessions": count})
+}
+
+// DevLogin signs in as any account and role and starts a session without an identity provider. It is
+// only routed when LOCAL_AUTH_SECRET is configured, which the configuration allows in development and test only.
+func (h *AuthHandler) DevLogin(c *gin.Context) {
+	var body struct {
+		Email          string          `json:"email" binding:"required,email"`
+		Name           string          `json:"name"`
+		Role           models.UserRole `json:"role"`
+		DepartmentID   uint            `json:"department_id"`
+		Specialization string          `json:"specialization"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := utils.Claims{Issuer: utils.LocalIssuer, Subject: body.Email, Email: body.Email, Name: body.Name}
+	user, err := h.userService.GetOrCreateDevUser(&claims, body.Role, body.DepartmentID, body.Specialization)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.userService.StartSession(user, sessionClient(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start a session"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cristim67/med-monitor/backend/services"
+	"gorm.io/gorm"
+)
+
+// statusFromError maps service errors onto HTTP status codes, defaulting to 500
+func statusFromError(err error) int {
+	var conflict *services.ConflictError
+	var validation *services.ValidationError
+	var forbidden *services.ForbiddenError
+	var blocked *services.PrescribingBlockedError
+	switch {
+	case errors.As(err, &conflict), errors.As(err, &blocked):
+		return http.StatusConflict
+	case errors.As(err, &validation):
+		return http.StatusBadRequest
+	case errors.As(err, &forbidden):
+		return http.StatusForbidden
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
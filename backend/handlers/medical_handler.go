@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
 	"github.com/cristim67/med-monitor/backend/services"
 	"github.com/gin-gonic/gin"
 )
@@ -83,25 +86,108 @@ func (h *MedicalHandler) GetDoctorAvailability(c *gin.Context) {
 	docIDStr := c.Param("id")
 	docID, _ := strconv.ParseUint(docIDStr, 10, 32)
 
-	appts, err := h.service.GetDoctorAppointments(uint(docID))
+	slots, err := h.service.GetAvailableSlots(uint(docID), c.Query("from"), c.Query("to"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, slots)
+}
 
-	// Filter to only return necessary info for availability
-	type SimpleAppt struct {
-		Date   string `json:"date"`
-		Status string `json:"status"`
+func (h *MedicalHandler) GetDoctorSchedule(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := strconv.ParseUint(docIDStr, 10, 32)
+
+	schedules, err := h.service.GetDoctorSchedule(uint(docID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (h *MedicalHandler) UpdateDoctorSchedule(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := strconv.ParseUint(docIDStr, 10, 32)
+
+	// Doctors may only edit their own working hours, admins can edit anyone's
+	if c.GetString("user_role") == string(models.RoleDoctor) && c.GetUint("user_id") != uint(docID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Doctors can only update their own schedule"})
+		return
+	}
+
+	var body []models.DoctorSchedule
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.SetDoctorSchedule(uint(docID), body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule updated"})
+}
+
+func (h *MedicalHandler) GetAbsences(c *gin.Context) {
+	absences, err := h.service.GetAbsences(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, absences)
+}
+
+func (h *MedicalHandler) CreateAbsence(c *gin.Context) {
+	var body struct {
+		models.Absence
+		FlagAppointments bool `json:"flag_appointments"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Doctors may only block out their own time, department and clinic closures are admin-only
+	userID := c.GetUint("user_id")
+	if c.GetString("user_role") == string(models.RoleDoctor) &&
+		(body.Scope != models.AbsenceDoctor || body.DoctorID == nil || *body.DoctorID != userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Doctors can only file absences for themselves"})
+		return
+	}
+
+	absence := body.Absence
+	absence.ID = 0
+	absence.CreatedByID = userID
+	flagged, err := h.service.CreateAbsence(&absence, body.FlagAppointments)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"absence": absence, "flagged_appointments": flagged})
+}
+
+func (h *MedicalHandler) DeleteAbsence(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+
+	if c.GetString("user_role") == string(models.RoleDoctor) {
+		absence, err := h.service.GetAbsence(uint(id))
+		if err != nil {
+			c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+			return
+		}
+		if absence.Scope != models.AbsenceDoctor || absence.DoctorID == nil || *absence.DoctorID != c.GetUint("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Doctors can only delete their own absences"})
+			return
+		}
 	}
-	var availability []SimpleAppt
-	for _, a := range appts {
-		availability = append(availability, SimpleAppt{
-			Date:   a.AppointmentDate.Format(models.RFC3339NoNano), // Need to ensure consistent format
-			Status: string(a.Status),
-		})
+
+	if err := h.service.DeleteAbsence(uint(id)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(http.StatusOK, availability)
+	c.JSON(http.StatusOK, gin.H{"message": "Absence deleted"})
 }
 
 func (h *MedicalHandler) GetPatients(c *gin.Context) {
@@ -153,52 +239,188 @@ func (h *MedicalHandler) CreateAppointment(c *gin.Context) {
 	}
 
 	patientID := c.GetUint("user_id")
-	appt, err := h.service.BookAppointment(patientID, body.DoctorID, body.Date)
+	byPatient := c.GetString("user_role") == string(models.RolePatient)
+	appt, err := h.service.BookAppointment(patientID, body.DoctorID, body.Date, byPatient)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, appt)
 }
 
-func (h *MedicalHandler) CompleteAppointment(c *gin.Context) {
+// isAppointmentParticipant reports whether the caller is an admin, or the doctor or patient of appt
+func isAppointmentParticipant(c *gin.Context, appt *models.Appointment) bool {
+	if c.GetString("user_role") == string(models.RoleAdmin) {
+		return true
+	}
+	userID := c.GetUint("user_id")
+	return appt.PatientID == userID || appt.DoctorID == userID
+}
+
+func (h *MedicalHandler) RescheduleAppointment(c *gin.Context) {
 	apptIDStr := c.Param("id")
 	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
 
 	var body struct {
-		Diagnosis   string                `json:"diagnosis"`
-		Notes       string                `json:"notes"`
-		Medications []models.Prescription `json:"medications"`
+		Date   string `json:"date"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appt, err := h.service.GetAppointment(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, appt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only reschedule your own appointments"})
+		return
+	}
+
+	appt, err = h.service.RescheduleAppointment(uint(apptID), c.GetUint("user_id"), body.Date, body.Reason)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, appt)
+}
+
+func (h *MedicalHandler) GetAppointmentHistory(c *gin.Context) {
+	apptIDStr := c.Param("id")
+	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
+
+	appt, err := h.service.GetAppointment(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, appt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only view the history of your own appointments"})
+		return
+	}
+
+	history, err := h.service.GetAppointmentHistory(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, history)
+}
+
+func (h *MedicalHandler) CompleteAppointment(c *gin.Context) {
+	apptIDStr := c.Param("id")
+	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
 
+	var body services.ConsultationInput
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.service.CompleteAppointment(uint(apptID), body.Diagnosis, body.Notes, body.Medications)
+	warnings, err := h.service.CompleteAppointment(uint(apptID), c.GetUint("user_id"), body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		var blocked *services.PrescribingBlockedError
+		if errors.As(err, &blocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "warnings": blocked.Warnings})
+			return
+		}
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Appointment completed successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment completed successfully", "warnings": warnings})
 }
 
 func (h *MedicalHandler) CancelAppointment(c *gin.Context) {
 	apptIDStr := c.Param("id")
 	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
 
-	err := h.service.CancelAppointment(uint(apptID))
+	// The reason is optional, clients may send the request without a body
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appt, err := h.service.GetAppointment(uint(apptID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, appt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only cancel your own appointments"})
+		return
+	}
+
+	err = h.service.CancelAppointment(uint(apptID), c.GetUint("user_id"), body.Reason)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment cancelled"})
 }
 
+func (h *MedicalHandler) UpdateAppointmentStatus(c *gin.Context) {
+	apptIDStr := c.Param("id")
+	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
+
+	var body struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appt, err := h.service.GetAppointment(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	// Only the treating doctor (or an admin) drives the visit workflow
+	if c.GetString("user_role") != string(models.RoleAdmin) && appt.DoctorID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update the status of your own patients' appointments"})
+		return
+	}
+
+	appt, err = h.service.UpdateAppointmentStatus(uint(apptID), c.GetUint("user_id"), body.Status, body.Reason)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, appt)
+}
+
+func (h *MedicalHandler) GetAppointmentStatusEvents(c *gin.Context) {
+	apptIDStr := c.Param("id")
+	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
+
+	appt, err := h.service.GetAppointment(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, appt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only view the status trail of your own appointments"})
+		return
+	}
+
+	events, err := h.service.GetAppointmentStatusEvents(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
 func (h *MedicalHandler) DeleteAppointment(c *gin.Context) {
 	apptIDStr := c.Param("id")
 	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
@@ -210,6 +432,178 @@ func (h *MedicalHandler) DeleteAppointment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment deleted"})
 }
 
+func (h *MedicalHandler) GetConsultation(c *gin.Context) {
+	consIDStr := c.Param("id")
+	consID, _ := strconv.ParseUint(consIDStr, 10, 32)
+
+	record, err := h.service.GetConsultation(uint(consID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, &record.Consultation.Appointment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only view consultations of your own appointments"})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+func (h *MedicalHandler) GetAppointmentConsultation(c *gin.Context) {
+	apptIDStr := c.Param("id")
+	apptID, _ := strconv.ParseUint(apptIDStr, 10, 32)
+
+	record, err := h.service.GetAppointmentConsultation(uint(apptID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, &record.Consultation.Appointment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only view consultations of your own appointments"})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+func (h *MedicalHandler) AmendConsultation(c *gin.Context) {
+	consIDStr := c.Param("id")
+	consID, _ := strconv.ParseUint(consIDStr, 10, 32)
+
+	var body services.AmendmentInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only the treating doctor (or an admin) may amend the clinical record
+	record, err := h.service.GetConsultation(uint(consID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if c.GetString("user_role") != string(models.RoleAdmin) && record.Consultation.Appointment.DoctorID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the treating doctor can amend this consultation"})
+		return
+	}
+
+	record, err = h.service.AmendConsultation(uint(consID), c.GetUint("user_id"), body)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, record)
+}
+
+func (h *MedicalHandler) SearchDiagnosisCodes(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	codes, err := h.service.SearchDiagnosisCodes(c.Query("q"), limit)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *MedicalHandler) GetDiagnosisCode(c *gin.Context) {
+	code, err := h.service.GetDiagnosisCode(c.Param("code"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, code)
+}
+
+func (h *MedicalHandler) SearchDrugs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	drugs, err := h.service.SearchDrugs(c.Query("q"), limit)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, drugs)
+}
+
+func (h *MedicalHandler) GetDrug(c *gin.Context) {
+	drug, err := h.service.GetDrug(c.Param("code"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, drug)
+}
+
+func (h *MedicalHandler) GetNoteTemplates(c *gin.Context) {
+	var departmentID *uint
+	if deptStr := c.Query("department_id"); deptStr != "" {
+		dept, err := strconv.ParseUint(deptStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid department_id"})
+			return
+		}
+		id := uint(dept)
+		departmentID = &id
+	}
+
+	templates, err := h.service.GetNoteTemplates(departmentID)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+func (h *MedicalHandler) GetNoteTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+
+	tmpl, err := h.service.GetNoteTemplate(uint(id))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
+
+func (h *MedicalHandler) CreateNoteTemplate(c *gin.Context) {
+	var body models.NoteTemplate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.CreateNoteTemplate(&body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+func (h *MedicalHandler) UpdateNoteTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+
+	var body models.NoteTemplate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.UpdateNoteTemplate(uint(id), &body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (h *MedicalHandler) DeleteNoteTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+	if err := h.service.DeleteNoteTemplate(uint(id)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Note template deleted"})
+}
+
 func (h *MedicalHandler) GetMyPrescriptions(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	role := c.GetString("user_role")
@@ -245,13 +639,528 @@ func (h *MedicalHandler) UpdatePrescription(c *gin.Context) {
 
 	err := h.service.UpdatePrescriptionStatus(uint(prescID), body.Status)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Prescription updated"})
 }
 
+func (h *MedicalHandler) LookupPrescription(c *gin.Context) {
+	presc, err := h.service.LookupPrescription(c.Param("code"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, presc)
+}
+
+func (h *MedicalHandler) DispensePrescription(c *gin.Context) {
+	var input services.DispenseInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presc, err := h.service.DispensePrescription(c.Param("code"), c.GetUint("user_id"), input)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, presc)
+}
+
+func (h *MedicalHandler) GetPrescriptionQR(c *gin.Context) {
+	prescIDStr := c.Param("id")
+	prescID, _ := strconv.ParseUint(prescIDStr, 10, 32)
+
+	presc, err := h.service.GetPrescription(uint(prescID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !isAppointmentParticipant(c, &presc.Consultation.Appointment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view this prescription"})
+		return
+	}
+
+	signed, err := h.service.SignPrescription(presc)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, signed)
+}
+
+func (h *MedicalHandler) GetPrescriptionPublicKey(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.PrescriptionPublicKey())
+}
+
+func (h *MedicalHandler) VerifyPrescription(c *gin.Context) {
+	var body struct {
+		Payload string `json:"payload" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	verified, err := h.service.VerifyPrescriptionPayload(body.Payload)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, verified)
+}
+
+func (h *MedicalHandler) RequestPrescriptionRenewal(c *gin.Context) {
+	prescIDStr := c.Param("id")
+	prescID, _ := strconv.ParseUint(prescIDStr, 10, 32)
+
+	var body struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presc, err := h.service.GetPrescription(uint(prescID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	if c.GetString("user_role") != string(models.RoleAdmin) && presc.Consultation.Appointment.PatientID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the patient can request a renewal"})
+		return
+	}
+
+	renewal, err := h.service.RequestPrescriptionRenewal(uint(prescID), c.GetUint("user_id"), body.Note)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, renewal)
+}
+
+func (h *MedicalHandler) GetPrescriptionRenewals(c *gin.Context) {
+	filter := repository.RenewalFilter{Status: models.RenewalStatus(c.Query("status"))}
+	switch c.GetString("user_role") {
+	case string(models.RoleAdmin):
+	case string(models.RoleDoctor):
+		filter.DoctorID = c.GetUint("user_id")
+	default:
+		filter.PatientID = c.GetUint("user_id")
+	}
+
+	renewals, err := h.service.GetPrescriptionRenewals(filter)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, renewals)
+}
+
+// authorizeRenewalDecision lets only the prescribing doctor or an admin decide on a renewal
+func (h *MedicalHandler) authorizeRenewalDecision(c *gin.Context, renewalID uint) bool {
+	renewal, err := h.service.GetPrescriptionRenewal(renewalID)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return false
+	}
+	if c.GetString("user_role") != string(models.RoleAdmin) && renewal.Prescription.Consultation.Appointment.DoctorID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the prescribing doctor can decide on this renewal"})
+		return false
+	}
+	return true
+}
+
+func (h *MedicalHandler) ApprovePrescriptionRenewal(c *gin.Context) {
+	renewalIDStr := c.Param("id")
+	renewalID, _ := strconv.ParseUint(renewalIDStr, 10, 32)
+
+	var decision services.RenewalDecision
+	if err := c.ShouldBindJSON(&decision); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.authorizeRenewalDecision(c, uint(renewalID)) {
+		return
+	}
+
+	renewal, err := h.service.ApprovePrescriptionRenewal(uint(renewalID), c.GetUint("user_id"), decision)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, renewal)
+}
+
+func (h *MedicalHandler) DenyPrescriptionRenewal(c *gin.Context) {
+	renewalIDStr := c.Param("id")
+	renewalID, _ := strconv.ParseUint(renewalIDStr, 10, 32)
+
+	var body struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.authorizeRenewalDecision(c, uint(renewalID)) {
+		return
+	}
+
+	renewal, err := h.service.DenyPrescriptionRenewal(uint(renewalID), c.GetUint("user_id"), body.Reason)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, renewal)
+}
+
+func (h *MedicalHandler) GetPatientNoShows(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+
+	summary, err := h.service.GetPatientNoShows(uint(patientID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+func (h *MedicalHandler) GetPatientAllergies(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+
+	allergies, err := h.service.GetPatientAllergies(uint(patientID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, allergies)
+}
+
+func (h *MedicalHandler) CreatePatientAllergy(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+
+	var body models.PatientAllergy
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.CreatePatientAllergy(uint(patientID), c.GetUint("user_id"), &body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+func (h *MedicalHandler) UpdatePatientAllergy(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	allergyIDStr := c.Param("allergy_id")
+	allergyID, _ := strconv.ParseUint(allergyIDStr, 10, 32)
+
+	var body models.PatientAllergy
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.UpdatePatientAllergy(uint(patientID), uint(allergyID), c.GetUint("user_id"), &body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (h *MedicalHandler) DeletePatientAllergy(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	allergyIDStr := c.Param("allergy_id")
+	allergyID, _ := strconv.ParseUint(allergyIDStr, 10, 32)
+
+	if err := h.service.DeletePatientAllergy(uint(patientID), uint(allergyID)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Allergy deleted"})
+}
+
+// canAccessPatientRecord lets patients reach only their own record, staff any record
+func canAccessPatientRecord(c *gin.Context, patientID uint) bool {
+	return c.GetString("user_role") != string(models.RolePatient) || c.GetUint("user_id") == patientID
+}
+
+func (h *MedicalHandler) GetObservations(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view these observations"})
+		return
+	}
+
+	obs, err := h.service.GetObservations(uint(patientID), c.Query("type"), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, obs)
+}
+
+// RecordObservations stores readings taken by a doctor, self-reported by the patient or sent by an integration
+func (h *MedicalHandler) RecordObservations(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only report their own observations"})
+		return
+	}
+
+	var body struct {
+		Observations []models.Observation `json:"observations" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source := models.SourceClinician
+	switch c.GetString("user_role") {
+	case string(models.RolePatient):
+		source = models.SourcePatient
+	case string(models.RoleService):
+		source = models.SourceService
+	}
+	userID := c.GetUint("user_id")
+	obs, err := h.service.RecordObservations(uint(patientID), source, &userID, body.Observations)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, obs)
+}
+
+func (h *MedicalHandler) GetPatientDevices(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view these devices"})
+		return
+	}
+
+	devices, err := h.service.GetPatientDevices(uint(patientID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+func (h *MedicalHandler) RegisterDevice(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only register their own devices"})
+		return
+	}
+
+	var body models.Device
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	device, err := h.service.RegisterDevice(uint(patientID), c.GetUint("user_id"), &body)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, device)
+}
+
+func (h *MedicalHandler) RotateDeviceCredential(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	deviceIDStr := c.Param("device_id")
+	deviceID, _ := strconv.ParseUint(deviceIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only manage their own devices"})
+		return
+	}
+
+	device, err := h.service.RotateDeviceCredential(uint(patientID), uint(deviceID))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+func (h *MedicalHandler) RevokeDevice(c *gin.Context) {
+	patientIDStr := c.Param("id")
+	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
+	deviceIDStr := c.Param("device_id")
+	deviceID, _ := strconv.ParseUint(deviceIDStr, 10, 32)
+	if !canAccessPatientRecord(c, uint(patientID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only manage their own devices"})
+		return
+	}
+
+	if err := h.service.RevokeDevice(uint(patientID), uint(deviceID)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Device revoked"})
+}
+
+// IngestTelemetry accepts a batch of readings from the device authenticated by DeviceAuthMiddleware
+func (h *MedicalHandler) IngestTelemetry(c *gin.Context) {
+	device := c.MustGet("device").(*models.Device)
+
+	var body struct {
+		BatchID  string               `json:"batch_id" binding:"required"`
+		Readings []models.Observation `json:"readings" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.service.IngestTelemetry(device, body.BatchID, body.Readings)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetAlertRules lists the alert rules, optionally only those targeting ?patient_id
+func (h *MedicalHandler) GetAlertRules(c *gin.Context) {
+	var patientID *uint
+	if idStr := c.Query("patient_id"); idStr != "" {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "patient_id must be a number"})
+			return
+		}
+		pid := uint(id)
+		patientID = &pid
+	}
+
+	rules, err := h.service.GetAlertRules(patientID)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *MedicalHandler) CreateAlertRule(c *gin.Context) {
+	var body models.AlertRule
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	admin := c.GetString("user_role") == string(models.RoleAdmin)
+	if err := h.service.CreateAlertRule(c.GetUint("user_id"), admin, &body); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+func (h *MedicalHandler) UpdateAlertRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+
+	var body services.AlertRuleUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	admin := c.GetString("user_role") == string(models.RoleAdmin)
+	rule, err := h.service.UpdateAlertRule(uint(id), c.GetUint("user_id"), admin, body)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *MedicalHandler) DeleteAlertRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+
+	admin := c.GetString("user_role") == string(models.RoleAdmin)
+	if err := h.service.DeleteAlertRule(uint(id), c.GetUint("user_id"), admin); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted"})
+}
+
+// GetAlerts is the alerts inbox: doctors see the alerts of the patients they are responsible for, admins see all
+func (h *MedicalHandler) GetAlerts(c *gin.Context) {
+	filter := repository.AlertFilter{Status: models.AlertStatus(c.Query("status"))}
+	if c.GetString("user_role") != string(models.RoleAdmin) {
+		filter.DoctorID = c.GetUint("user_id")
+	}
+
+	alerts, err := h.service.GetAlerts(filter)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+// authorizeAlertAction lets only the doctor responsible for the patient or an admin act on an alert
+func (h *MedicalHandler) authorizeAlertAction(c *gin.Context, alertID uint) bool {
+	alert, err := h.service.GetAlert(alertID)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return false
+	}
+	if c.GetString("user_role") != string(models.RoleAdmin) && (alert.DoctorID == nil || *alert.DoctorID != c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the responsible doctor can act on this alert"})
+		return false
+	}
+	return true
+}
+
+func (h *MedicalHandler) AcknowledgeAlert(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+	if !h.authorizeAlertAction(c, uint(id)) {
+		return
+	}
+
+	alert, err := h.service.AcknowledgeAlert(uint(id), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func (h *MedicalHandler) ResolveAlert(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.ParseUint(idStr, 10, 32)
+	if !h.authorizeAlertAction(c, uint(id)) {
+		return
+	}
+
+	alert, err := h.service.ResolveAlert(uint(id), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
 func (h *MedicalHandler) GetPatientHistory(c *gin.Context) {
 	patientIDStr := c.Param("id")
 	patientID, _ := strconv.ParseUint(patientIDStr, 10, 32)
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/cristim67/med-monitor/backend/models"
 	"github.com/cristim67/med-monitor/backend/services"
@@ -56,8 +59,151 @@ func (h *UserHandler) UpdateUserRole(c *gin.Context) {
 
 	idVal, _ := strconv.ParseUint(userIDStr, 10, 32)
 	if err := h.service.UpdateUserRole(uint(idVal), body.Role, body.DepartmentID, body.Specialization); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "User role updated"})
 }
+
+// GetRoleEvents lists the roles the role rules assigned to a user at login
+func (h *UserHandler) GetRoleEvents(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	events, err := h.service.GetRoleEvents(uint(id))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// GetSessions lists the open sessions of a user
+func (h *UserHandler) GetSessions(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	sessions, err := h.service.GetActiveSessions(uint(id))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSessions signs a user out on every device
+func (h *UserHandler) RevokeSessions(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	count, err := h.service.RevokeUserSessions(uint(id))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "sessions": count})
+}
+
+// RevokeSession ends a single session, e.g. of a lost device
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err := h.service.RevokeSession(uint(id)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func (h *UserHandler) ListServiceAccounts(c *gin.Context) {
+	accounts, err := h.service.GetServiceAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (h *UserHandler) GetServiceAccount(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	account, err := h.service.GetServiceAccount(uint(id))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+func (h *UserHandler) CreateServiceAccount(c *gin.Context) {
+	var body struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	account, err := h.service.CreateServiceAccount(body.Name, body.Description, c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, account)
+}
+
+// DisableServiceAccount revokes a service account with all of its keys
+func (h *UserHandler) DisableServiceAccount(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err := h.service.DisableServiceAccount(uint(id)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Service account disabled"})
+}
+
+// CreateAPIKey issues a key to a service account; the key is only ever shown in this response
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var body services.APIKeyInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	key, err := h.service.CreateAPIKey(uint(id), c.GetUint("user_id"), body)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+// RotateAPIKey replaces a key; with a grace_period such as "24h" the old key keeps working that long
+func (h *UserHandler) RotateAPIKey(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	keyID, _ := strconv.ParseUint(c.Param("key_id"), 10, 32)
+	var body struct {
+		GracePeriod string `json:"grace_period"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var grace time.Duration
+	if body.GracePeriod != "" {
+		var err error
+		if grace, err = time.ParseDuration(body.GracePeriod); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid grace_period: " + err.Error()})
+			return
+		}
+	}
+
+	key, err := h.service.RotateAPIKey(uint(id), uint(keyID), c.GetUint("user_id"), grace)
+	if err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	keyID, _ := strconv.ParseUint(c.Param("key_id"), 10, 32)
+	if err := h.service.RevokeAPIKey(uint(id), uint(keyID)); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"os"
+	_ "time/tzdata" // embed zoneinfo, the alpine runtime image ships without it
 
 	"github.com/casbin/casbin/v3"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
@@ -11,21 +13,40 @@ import (
 	"github.com/cristim67/med-monitor/backend/repository"
 	"github.com/cristim67/med-monitor/backend/routes"
 	"github.com/cristim67/med-monitor/backend/services"
+	"github.com/cristim67/med-monitor/backend/utils"
+	"github.com/cristim67/med-monitor/backend/workers"
 )
 
 func main() {
+	// Generating a signing key needs neither the configuration nor the database, and is what
+	// provides the key the configuration is missing
+	if len(os.Args) == 2 && os.Args[1] == "generate-signing-key" {
+		generateSigningKey()
+		return
+	}
+
 	// 1. Load configuration
 	config.LoadConfig()
 
 	// 2. Initialize Database and run migrations
 	db.InitDB(config.AppConfig.DatabaseURL)
 
+	// Maintenance commands (e.g. `import-icd10 codes.csv`) run against the database and exit
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	// 3. Initialize Repositories and Services
 	userRepo := repository.NewUserRepository(db.DB)
 	medicalRepo := repository.NewMedicalRepository(db.DB)
+	uow := repository.NewUnitOfWork(db.DB)
 
-	userService := services.NewUserService(userRepo, medicalRepo)
-	medicalService := services.NewMedicalService(medicalRepo)
+	if err := services.ValidateRoleRules(config.AppConfig.RoleRules); err != nil {
+		log.Fatalf("Invalid ROLE_RULES: %v", err)
+	}
+	userService := services.NewUserService(userRepo, medicalRepo, uow)
+	medicalService := services.NewMedicalService(medicalRepo, uow)
 
 	// 4. Initialize Enforcer with GORM adapter
 	adapter, err := gormadapter.NewAdapterByDB(db.DB)
@@ -43,33 +64,8 @@ func main() {
 		log.Fatalf("Failed to load policies: %v", err)
 	}
 
-	// Seed initial policies if missing
-	hasDoctorPolicy, _ := enforcer.HasPolicy(string(models.RoleDoctor), "/api/v1/profile", "(GET)")
-	if !hasDoctorPolicy {
-		log.Println("Seeding missing Doctor/Patient Casbin policies into DB...")
-		enforcer.AddPolicy(string(models.RoleAdmin), "/api/v1/*", ".*")
-
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/profile", "(GET)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/patients", "(GET)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/patients/:id/history", "(GET)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/appointments", "(GET)|(POST)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/appointments/:id/complete", "(PUT)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/appointments/:id/cancel", "(PUT)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/prescriptions", "(GET)")
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/prescriptions/:id", "(PUT)")
-
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/profile", "(GET)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/appointments", "(GET)|(POST)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/appointments/:id/cancel", "(PUT)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/prescriptions", "(GET)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/doctors", "(GET)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/doctors/:id/availability", "(GET)")
-		enforcer.AddPolicy(string(models.RolePatient), "/api/v1/departments", "(GET)")
-
-		enforcer.AddPolicy(string(models.RoleDoctor), "/api/v1/doctors/:id/availability", "(GET)")
-
-		enforcer.SavePolicy()
-	}
+	// Seed the default policies this database has not received yet
+	seedPolicies(enforcer, db.DB)
 
 	// 5. Seed Departments if empty
 	depts, _ := medicalRepo.GetAllDepartments()
@@ -81,10 +77,45 @@ func main() {
 		medicalRepo.CreateDepartment(&models.Department{Name: "Pediatrics", Description: "Child healthcare department"})
 	}
 
-	// 6. Setup Router
-	r := routes.SetupRouter(enforcer, userService, medicalService)
+	// 6. Start background workers
+	if config.AppConfig.NoShowSweepInterval > 0 {
+		workers.StartNoShowSweeper(medicalService, config.AppConfig.NoShowSweepInterval, config.AppConfig.NoShowGracePeriod)
+	}
+	if config.AppConfig.PrescriptionExpirySweepInterval > 0 {
+		workers.StartPrescriptionExpirySweeper(medicalService, config.AppConfig.PrescriptionExpirySweepInterval)
+	}
+
+	// 7. Setup token verification and the Router
+	verifiers := utils.Verifiers{utils.NewGoogleVerifier(config.AppConfig.GoogleClientID)}
+	for _, provider := range config.AppConfig.OIDCProviders {
+		oidc, err := utils.NewOIDCVerifier(utils.OIDCConfig{
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			EmailClaim:   provider.EmailClaim,
+			NameClaim:    provider.NameClaim,
+			PictureClaim: provider.PictureClaim,
+			GroupsClaim:  provider.GroupsClaim,
+			KeyCacheTTL:  config.AppConfig.OIDCKeyCacheTTL,
+			TrustEmail:   provider.TrustEmail,
+		})
+		if err != nil {
+			log.Fatalf("Invalid OIDC provider configuration: %v", err)
+		}
+		verifiers = append(verifiers, oidc)
+		log.Printf("Accepting ID tokens issued by %s", provider.Issuer)
+	}
+	devLogin := config.AppConfig.LocalAuthSecret != ""
+	if devLogin {
+		localJWT, err := utils.NewLocalJWT(config.AppConfig.LocalAuthSecret, config.AppConfig.LocalAuthTokenTTL)
+		if err != nil {
+			log.Fatalf("Invalid local auth configuration: %v", err)
+		}
+		verifiers = append(verifiers, localJWT)
+		log.Println("WARNING: local authentication is enabled, anyone can sign in through /api/v1/auth/dev-login")
+	}
+	r := routes.SetupRouter(enforcer, userService, medicalService, verifiers, devLogin)
 
-	// 6. Start server
+	// 8. Start server
 	log.Printf("Server executing on :%s", config.AppConfig.Port)
 	if err := r.Run(":" + config.AppConfig.Port); err != nil {
 		log.Fatalf("Failed to run server: %v", err)
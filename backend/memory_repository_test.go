@@ -0,0 +1,553 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"gorm.io/gorm"
+)
+
+// memoryStore keeps the records of the API tests in memory. It mimics the queries of the Postgres
+// repositories closely enough for the flows under test; anything else panics through the embedded
+// interfaces, so a test reaching an unexpected query fails loudly.
+type memoryStore struct {
+	nextID uint
+
+	users      map[uint]*models.User
+	roleEvents []models.UserRoleEvent
+	sessions   map[uint]*models.Session
+
+	serviceAccounts map[uint]*models.ServiceAccount
+	apiKeys         map[uint]*models.APIKey
+
+	departments   map[uint]*models.Department
+	doctors       map[uint]*models.Doctor
+	patients      map[uint]*models.Patient
+	appointments  map[uint]*models.Appointment
+	statusEvents  []models.AppointmentStatusEvent
+	consultations map[uint]*models.Consultation
+	prescriptions map[uint]*models.Prescription
+	dispenses     []models.PrescriptionDispense
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:    make(map[uint]*models.User),
+		sessions: make(map[uint]*models.Session),
+
+		serviceAccounts: make(map[uint]*models.ServiceAccount),
+		apiKeys:         make(map[uint]*models.APIKey),
+
+		departments:   make(map[uint]*models.Department),
+		doctors:       make(map[uint]*models.Doctor),
+		patients:      make(map[uint]*models.Patient),
+		appointments:  make(map[uint]*models.Appointment),
+		consultations: make(map[uint]*models.Consultation),
+		prescriptions: make(map[uint]*models.Prescription),
+	}
+}
+
+func (s *memoryStore) id() uint {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *memoryStore) repositories() repository.Repositories {
+	return repository.Repositories{Users: &memoryUserRepository{s: s}, Medical: &memoryMedicalRepository{s: s}}
+}
+
+// memoryUnitOfWork runs transactions directly against the store. Writes are not rolled back, the
+// services validate before they write, which is all the tests rely on.
+type memoryUnitOfWork struct {
+	s *memoryStore
+}
+
+func (u *memoryUnitOfWork) Transaction(fn func(tx repository.Repositories) error) error {
+	return fn(u.s.repositories())
+}
+
+type memoryUserRepository struct {
+	repository.UserRepository
+	s *memoryStore
+}
+
+func (r *memoryUserRepository) FindByEmail(email string) (*models.User, error) {
+	for _, u := range r.s.users {
+		if u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryUserRepository) FindByID(id uint) (*models.User, error) {
+	u, ok := r.s.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := *u
+	return &user, nil
+}
+
+func (r *memoryUserRepository) CreateUser(user *models.User) error {
+	if _, err := r.FindByEmail(user.Email); err == nil {
+		return repository.ErrConflict
+	}
+	user.ID = r.s.id()
+	stored := *user
+	r.s.users[user.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateUser(user *models.User) error {
+	stored := *user
+	r.s.users[user.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) CreatePatient(patient *models.Patient) error {
+	stored := *patient
+	r.s.patients[patient.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) GetAllUsers() ([]models.User, error) {
+	var users []models.User
+	for _, u := range r.s.users {
+		users = append(users, *u)
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) CreateRoleEvent(event *models.UserRoleEvent) error {
+	event.ID = r.s.id()
+	r.s.roleEvents = append(r.s.roleEvents, *event)
+	return nil
+}
+
+func (r *memoryUserRepository) GetRoleEvents(userID uint) ([]models.UserRoleEvent, error) {
+	var events []models.UserRoleEvent
+	for _, e := range r.s.roleEvents {
+		if e.UserID == userID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryUserRepository) CreateSession(session *models.Session) error {
+	session.ID = r.s.id()
+	stored := *session
+	stored.User = models.User{}
+	r.s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) GetSession(id uint) (*models.Session, error) {
+	s, ok := r.s.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	session := *s
+	if u, ok := r.s.users[session.UserID]; ok {
+		session.User = *u
+	}
+	return &session, nil
+}
+
+func (r *memoryUserRepository) GetActiveSessions(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	for _, s := range r.s.sessions {
+		if s.UserID == userID && s.RevokedAt == nil && s.ExpiresAt.After(now) {
+			sessions = append(sessions, *s)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *memoryUserRepository) RotateSessionToken(id uint, oldHash, newHash string, usedAt, expiresAt time.Time) error {
+	s, ok := r.s.sessions[id]
+	if !ok || s.RefreshTokenHash != oldHash || s.RevokedAt != nil {
+		return repository.ErrConflict
+	}
+	s.RefreshTokenHash, s.PreviousHash, s.LastUsedAt, s.ExpiresAt = newHash, oldHash, usedAt, expiresAt
+	return nil
+}
+
+func (r *memoryUserRepository) RevokeSession(id uint, reason string, at time.Time) error {
+	if s, ok := r.s.sessions[id]; ok && s.RevokedAt == nil {
+		s.RevokedAt, s.RevokedReason = &at, reason
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) RevokeUserSessions(userID uint, reason string, at time.Time) (int64, error) {
+	var count int64
+	for _, s := range r.s.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt, s.RevokedReason = &at, reason
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryUserRepository) CreateServiceAccount(account *models.ServiceAccount) error {
+	for _, a := range r.s.serviceAccounts {
+		if a.Name == account.Name {
+			return repository.ErrConflict
+		}
+	}
+	account.ID = r.s.id()
+	stored := *account
+	r.s.serviceAccounts[account.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) GetServiceAccounts() ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	for id := range r.s.serviceAccounts {
+		account, _ := r.GetServiceAccount(id)
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
+
+func (r *memoryUserRepository) GetServiceAccount(id uint) (*models.ServiceAccount, error) {
+	a, ok := r.s.serviceAccounts[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	account := *a
+	account.Keys = nil
+	for _, k := range r.s.apiKeys {
+		if k.ServiceAccountID == id {
+			account.Keys = append(account.Keys, *k)
+		}
+	}
+	slices.SortFunc(account.Keys, func(a, b models.APIKey) int { return int(a.ID) - int(b.ID) })
+	return &account, nil
+}
+
+func (r *memoryUserRepository) UpdateServiceAccount(account *models.ServiceAccount) error {
+	stored := *account
+	stored.Keys = nil
+	r.s.serviceAccounts[account.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) CreateAPIKey(key *models.APIKey) error {
+	key.ID = r.s.id()
+	stored := *key
+	r.s.apiKeys[key.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) GetAPIKey(id uint) (*models.APIKey, error) {
+	k, ok := r.s.apiKeys[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	key := *k
+	if a, ok := r.s.serviceAccounts[key.ServiceAccountID]; ok {
+		account := *a
+		if u, ok := r.s.users[account.UserID]; ok {
+			account.User = *u
+		}
+		key.ServiceAccount = &account
+	}
+	return &key, nil
+}
+
+func (r *memoryUserRepository) UpdateAPIKey(key *models.APIKey) error {
+	stored := r.s.apiKeys[key.ID]
+	stored.ExpiresAt, stored.RevokedAt = key.ExpiresAt, key.RevokedAt
+	return nil
+}
+
+func (r *memoryUserRepository) RevokeAPIKeys(serviceAccountID uint, at time.Time) error {
+	for _, k := range r.s.apiKeys {
+		if k.ServiceAccountID == serviceAccountID && k.RevokedAt == nil {
+			k.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) TouchAPIKey(id uint, usedAt time.Time) error {
+	r.s.apiKeys[id].LastUsedAt = &usedAt
+	return nil
+}
+
+type memoryMedicalRepository struct {
+	repository.MedicalRepository
+	s *memoryStore
+}
+
+func (r *memoryMedicalRepository) GetAllDepartments() ([]models.Department, error) {
+	var depts []models.Department
+	for _, d := range r.s.departments {
+		depts = append(depts, *d)
+	}
+	return depts, nil
+}
+
+func (r *memoryMedicalRepository) GetDepartmentByID(id uint) (*models.Department, error) {
+	d, ok := r.s.departments[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	dept := *d
+	return &dept, nil
+}
+
+func (r *memoryMedicalRepository) CreateDepartment(dept *models.Department) error {
+	dept.ID = r.s.id()
+	stored := *dept
+	r.s.departments[dept.ID] = &stored
+	return nil
+}
+
+// doctor returns the doctor with its user and department, like the preloads of the Postgres queries
+func (r *memoryMedicalRepository) doctor(id uint) models.Doctor {
+	doc := *r.s.doctors[id]
+	doc.User = *r.s.users[id]
+	if dept, ok := r.s.departments[doc.DepartmentID]; ok {
+		doc.Department = *dept
+	}
+	return doc
+}
+
+func (r *memoryMedicalRepository) GetDoctorByID(id uint) (*models.Doctor, error) {
+	if _, ok := r.s.doctors[id]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	doc := r.doctor(id)
+	return &doc, nil
+}
+
+func (r *memoryMedicalRepository) CreateDoctor(doctor *models.Doctor) error {
+	stored := *doctor
+	r.s.doctors[doctor.ID] = &stored
+	return nil
+}
+
+func (r *memoryMedicalRepository) UpdateDoctor(doctor *models.Doctor) error {
+	stored := r.s.doctors[doctor.ID]
+	stored.DepartmentID = doctor.DepartmentID
+	stored.Specialization = doctor.Specialization
+	return nil
+}
+
+func (r *memoryMedicalRepository) patient(id uint) models.Patient {
+	p := *r.s.patients[id]
+	p.User = *r.s.users[id]
+	return p
+}
+
+func (r *memoryMedicalRepository) GetAllPatients() ([]models.Patient, error) {
+	var patients []models.Patient
+	for id := range r.s.patients {
+		patients = append(patients, r.patient(id))
+	}
+	return patients, nil
+}
+
+func (r *memoryMedicalRepository) GetPatientByID(id uint) (*models.Patient, error) {
+	if _, ok := r.s.patients[id]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	p := r.patient(id)
+	return &p, nil
+}
+
+// Every doctor works the default schedule and nobody is ever absent
+func (r *memoryMedicalRepository) GetDoctorSchedules(doctorID uint) ([]models.DoctorSchedule, error) {
+	return nil, nil
+}
+
+func (r *memoryMedicalRepository) GetDoctorAbsencesBetween(doctorID, departmentID uint, start, end time.Time) ([]models.Absence, error) {
+	return nil, nil
+}
+
+func (r *memoryMedicalRepository) appointment(id uint) models.Appointment {
+	appt := *r.s.appointments[id]
+	appt.Patient = r.patient(appt.PatientID)
+	appt.Doctor = r.doctor(appt.DoctorID)
+	return appt
+}
+
+func (r *memoryMedicalRepository) appointmentsWhere(match func(*models.Appointment) bool) []models.Appointment {
+	var appts []models.Appointment
+	for id, a := range r.s.appointments {
+		if match(a) {
+			appts = append(appts, r.appointment(id))
+		}
+	}
+	return appts
+}
+
+func (r *memoryMedicalRepository) FindOverlappingAppointments(doctorID, patientID uint, start, end time.Time, excludeID uint) ([]models.Appointment, error) {
+	return r.appointmentsWhere(func(a *models.Appointment) bool {
+		return slices.Contains(models.ActiveAppointmentStatuses, a.Status) && a.ID != excludeID &&
+			(a.DoctorID == doctorID || a.PatientID == patientID) &&
+			a.AppointmentDate.Before(end) && a.EndDate.After(start)
+	}), nil
+}
+
+func (r *memoryMedicalRepository) CreateAppointment(appt *models.Appointment) error {
+	appt.ID = r.s.id()
+	appt.CreatedAt = time.Now()
+	stored := *appt
+	r.s.appointments[appt.ID] = &stored
+	return nil
+}
+
+func (r *memoryMedicalRepository) GetAppointmentByID(id uint) (*models.Appointment, error) {
+	if _, ok := r.s.appointments[id]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	appt := r.appointment(id)
+	return &appt, nil
+}
+
+func (r *memoryMedicalRepository) GetAllAppointments() ([]models.Appointment, error) {
+	return r.appointmentsWhere(func(*models.Appointment) bool { return true }), nil
+}
+
+func (r *memoryMedicalRepository) GetAppointmentsByPatient(patientID uint) ([]models.Appointment, error) {
+	return r.appointmentsWhere(func(a *models.Appointment) bool { return a.PatientID == patientID }), nil
+}
+
+func (r *memoryMedicalRepository) GetAppointmentsByDoctor(doctorID uint) ([]models.Appointment, error) {
+	return r.appointmentsWhere(func(a *models.Appointment) bool { return a.DoctorID == doctorID }), nil
+}
+
+func (r *memoryMedicalRepository) UpdateAppointmentStatus(appt *models.Appointment, event *models.AppointmentStatusEvent) error {
+	stored := r.s.appointments[appt.ID]
+	if stored.Status != event.FromStatus {
+		return repository.ErrConflict
+	}
+	stored.Status = event.ToStatus
+	event.ID = r.s.id()
+	r.s.statusEvents = append(r.s.statusEvents, *event)
+	appt.Status = event.ToStatus
+	return nil
+}
+
+func (r *memoryMedicalRepository) GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error) {
+	return nil, nil
+}
+
+// The drug catalog is empty, every medication is free text
+func (r *memoryMedicalRepository) GetDrugsByNames(names []string) ([]models.Drug, error) {
+	return nil, nil
+}
+
+func (r *memoryMedicalRepository) CreateConsultation(cons *models.Consultation) error {
+	cons.ID = r.s.id()
+	stored := *cons
+	r.s.consultations[cons.ID] = &stored
+	return nil
+}
+
+func (r *memoryMedicalRepository) CreateConsultationDiagnoses(diagnoses []models.ConsultationDiagnosis) error {
+	if len(diagnoses) > 0 {
+		panic("coded diagnoses are not supported by the memory store")
+	}
+	return nil
+}
+
+func (r *memoryMedicalRepository) CreateObservations(obs []models.Observation) error {
+	if len(obs) > 0 {
+		panic("observations are not supported by the memory store")
+	}
+	return nil
+}
+
+func (r *memoryMedicalRepository) CreatePrescription(presc *models.Prescription) error {
+	for _, p := range r.s.prescriptions {
+		if p.VerificationCode == presc.VerificationCode {
+			return repository.ErrConflict
+		}
+	}
+	presc.ID = r.s.id()
+	presc.CreatedAt = time.Now()
+	stored := *presc
+	r.s.prescriptions[presc.ID] = &stored
+	return nil
+}
+
+// prescription returns the prescription with its consultation, appointment and dispenses
+func (r *memoryMedicalRepository) prescription(id uint) models.Prescription {
+	presc := *r.s.prescriptions[id]
+	presc.Consultation = *r.s.consultations[presc.ConsultationID]
+	presc.Consultation.Appointment = r.appointment(presc.Consultation.AppointmentID)
+	presc.Dispenses = nil
+	for _, d := range r.s.dispenses {
+		if d.PrescriptionID == id {
+			d.Pharmacist = *r.s.users[d.PharmacistID]
+			presc.Dispenses = append(presc.Dispenses, d)
+		}
+	}
+	return presc
+}
+
+func (r *memoryMedicalRepository) prescriptionsWhere(match func(*models.Appointment) bool) []models.Prescription {
+	var prescs []models.Prescription
+	for id, p := range r.s.prescriptions {
+		cons := r.s.consultations[p.ConsultationID]
+		if match(r.s.appointments[cons.AppointmentID]) {
+			prescs = append(prescs, r.prescription(id))
+		}
+	}
+	return prescs
+}
+
+func (r *memoryMedicalRepository) GetPrescriptionByID(id uint) (*models.Prescription, error) {
+	if _, ok := r.s.prescriptions[id]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	presc := r.prescription(id)
+	return &presc, nil
+}
+
+func (r *memoryMedicalRepository) GetPrescriptionByVerificationCode(code string) (*models.Prescription, error) {
+	for id, p := range r.s.prescriptions {
+		if p.VerificationCode == code {
+			presc := r.prescription(id)
+			return &presc, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryMedicalRepository) LockPrescriptionByVerificationCode(code string) (*models.Prescription, error) {
+	return r.GetPrescriptionByVerificationCode(code)
+}
+
+func (r *memoryMedicalRepository) GetPrescriptionsByPatient(patientID uint) ([]models.Prescription, error) {
+	return r.prescriptionsWhere(func(a *models.Appointment) bool { return a.PatientID == patientID }), nil
+}
+
+func (r *memoryMedicalRepository) GetPrescriptionsByDoctor(doctorID uint) ([]models.Prescription, error) {
+	return r.prescriptionsWhere(func(a *models.Appointment) bool { return a.DoctorID == doctorID }), nil
+}
+
+func (r *memoryMedicalRepository) UpdatePrescriptionStatus(id uint, from, to models.PrescriptionStatus) error {
+	stored := r.s.prescriptions[id]
+	if stored.Status != from {
+		return repository.ErrConflict
+	}
+	stored.Status = to
+	return nil
+}
+
+func (r *memoryMedicalRepository) CreatePrescriptionDispense(dispense *models.PrescriptionDispense) error {
+	dispense.ID = r.s.id()
+	r.s.dispenses = append(r.s.dispenses, *dispense)
+	return nil
+}
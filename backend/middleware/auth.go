@@ -1,30 +1,43 @@
 package middleware
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/casbin/casbin/v3"
+	"github.com/cristim67/med-monitor/backend/models"
 	"github.com/cristim67/med-monitor/backend/services"
 	"github.com/cristim67/med-monitor/backend/utils"
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates the Google Bearer token and enforcing RBAC
+// AuthMiddleware resolves the Bearer access token to its session and enforces RBAC. Service accounts
+// send "Authorization: ApiKey <key>" instead. Identity provider tokens are only accepted by the token
+// exchange endpoint.
 func AuthMiddleware(e *casbin.Enforcer, userService services.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
+		if scheme, key, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "apikey") {
+			authorizeAPIKey(c, e, userService, key)
+			return
+		}
 
-		claims, err := utils.ValidateAndExtractClaims(authHeader)
+		token, err := utils.BearerToken(c.GetHeader("Authorization"))
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		user, err := userService.GetOrCreateUserByClaims(claims)
+		user, sessionID, err := userService.AuthenticateAccessToken(token)
+		if errors.Is(err, services.ErrInvalidSession) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 		if err != nil {
-			log.Printf("Error authing user %s: %v", claims.Email, err)
+			log.Printf("Error loading the session of an access token: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user"})
 			c.Abort()
 			return
@@ -32,6 +45,7 @@ func AuthMiddleware(e *casbin.Enforcer, userService services.UserService) gin.Ha
 
 		// Inject info into request scope
 		c.Set("user_id", user.ID)
+		c.Set("session_id", sessionID)
 		c.Set("user_role", string(user.Role))
 		c.Set("user_email", user.Email)
 		c.Set("user_picture", user.Picture)
@@ -63,3 +77,47 @@ func AuthMiddleware(e *casbin.Enforcer, userService services.UserService) gin.Ha
 		}
 	}
 }
+
+// authorizeAPIKey authenticates a service account by its API key. The request is allowed when one of
+// the key's scopes has a policy for it; the service role itself has none.
+func authorizeAPIKey(c *gin.Context, e *casbin.Enforcer, userService services.UserService, token string) {
+	key, err := userService.AuthenticateAPIKey(token)
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		log.Printf("Error authenticating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate API key"})
+		c.Abort()
+		return
+	}
+
+	account := key.ServiceAccount
+	c.Set("user_id", account.UserID)
+	c.Set("api_key_id", key.ID)
+	c.Set("user_role", string(models.RoleService))
+	c.Set("user_email", account.User.Email)
+	c.Set("user_name", account.Name)
+
+	obj := c.Request.URL.Path
+	act := c.Request.Method
+	for _, scope := range key.Scopes {
+		ok, err := e.Enforce(models.APIScope(scope).Subject(), obj, act)
+		if err != nil {
+			log.Printf("RBAC Enforce error for API key %d (scope %s): %v", key.ID, scope, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred when authorizing API key"})
+			c.Abort()
+			return
+		}
+		if ok {
+			c.Next()
+			return
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Forbidden: the scopes of this API key do not cover " + obj + " [" + act + "]",
+	})
+	c.Abort()
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceAuthMiddleware authenticates monitoring devices by their own credential,
+// sent as "Authorization: Device <token>", in place of a user's Google ID token
+func DeviceAuthMiddleware(medicalService services.MedicalService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "device") {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header format must be Device {token}"})
+			c.Abort()
+			return
+		}
+
+		device, err := medicalService.AuthenticateDevice(token)
+		if errors.Is(err, services.ErrInvalidDeviceCredential) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating device: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate device"})
+			c.Abort()
+			return
+		}
+
+		c.Set("device", device)
+		c.Next()
+	}
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_appointments_patient_id;
+DROP INDEX IF EXISTS idx_appointments_doctor_id;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_patient_no_overlap;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_doctor_no_overlap;
+ALTER TABLE appointments DROP COLUMN IF EXISTS end_date;
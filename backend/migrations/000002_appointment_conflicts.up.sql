@@ -0,0 +1,28 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE appointments ADD COLUMN end_date TIMESTAMP WITH TIME ZONE;
+UPDATE appointments SET end_date = appointment_date + INTERVAL '30 minutes';
+ALTER TABLE appointments ALTER COLUMN end_date SET NOT NULL;
+
+-- Cancel pre-existing double bookings (keeping the oldest one) so the constraints below can be created
+UPDATE appointments a SET status = 'Cancelled'
+WHERE a.status = 'Scheduled' AND a.deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM appointments b
+    WHERE b.id < a.id
+      AND b.status = 'Scheduled'
+      AND b.deleted_at IS NULL
+      AND (b.doctor_id = a.doctor_id OR b.patient_id = a.patient_id)
+      AND b.appointment_date < a.end_date
+      AND a.appointment_date < b.end_date
+);
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_doctor_no_overlap
+    EXCLUDE USING gist (doctor_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status = 'Scheduled' AND deleted_at IS NULL);
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_patient_no_overlap
+    EXCLUDE USING gist (patient_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status = 'Scheduled' AND deleted_at IS NULL);
+
+CREATE INDEX idx_appointments_doctor_id ON appointments(doctor_id);
+CREATE INDEX idx_appointments_patient_id ON appointments(patient_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS schedule_breaks CASCADE;
+DROP TABLE IF EXISTS doctor_schedules CASCADE;
@@ -0,0 +1,5 @@
+-- Versions of the default Casbin policies already applied, so each is seeded once and removals by an admin stick
+CREATE TABLE policy_seeds (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
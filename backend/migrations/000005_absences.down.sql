@@ -0,0 +1,2 @@
+ALTER TABLE appointments DROP COLUMN IF EXISTS needs_reschedule;
+DROP TABLE IF EXISTS absences CASCADE;
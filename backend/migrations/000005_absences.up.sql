@@ -0,0 +1,18 @@
+CREATE TABLE absences (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('doctor', 'department', 'clinic')),
+    doctor_id INTEGER REFERENCES doctors(id) ON DELETE CASCADE,
+    department_id INTEGER REFERENCES departments(id) ON DELETE CASCADE,
+    start_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    reason TEXT,
+    created_by_id INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE NULL,
+    CHECK (end_date > start_date)
+);
+CREATE INDEX idx_absences_deleted_at ON absences(deleted_at);
+CREATE INDEX idx_absences_range ON absences(start_date, end_date);
+
+ALTER TABLE appointments ADD COLUMN needs_reschedule BOOLEAN NOT NULL DEFAULT FALSE;
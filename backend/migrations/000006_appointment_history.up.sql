@@ -0,0 +1,12 @@
+CREATE TABLE appointment_histories (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    previous_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    previous_end_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    new_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    new_end_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    changed_by_id INTEGER NOT NULL REFERENCES users(id),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_appointment_histories_appointment_id ON appointment_histories(appointment_id);
@@ -0,0 +1,15 @@
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_doctor_no_overlap;
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_patient_no_overlap;
+
+UPDATE appointments SET status = 'Scheduled' WHERE status IN ('CheckedIn', 'InProgress');
+UPDATE appointments SET status = 'Cancelled' WHERE status = 'NoShow';
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_doctor_no_overlap
+    EXCLUDE USING gist (doctor_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status = 'Scheduled' AND deleted_at IS NULL);
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_patient_no_overlap
+    EXCLUDE USING gist (patient_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status = 'Scheduled' AND deleted_at IS NULL);
+
+DROP TABLE IF EXISTS appointment_status_events CASCADE;
@@ -0,0 +1,22 @@
+CREATE TABLE appointment_status_events (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    from_status VARCHAR(50) NOT NULL,
+    to_status VARCHAR(50) NOT NULL,
+    actor_id INTEGER REFERENCES users(id), -- NULL for transitions made by the system
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_appointment_status_events_appointment_id ON appointment_status_events(appointment_id);
+
+-- Checked-in and in-progress visits still occupy their slot
+ALTER TABLE appointments DROP CONSTRAINT appointments_doctor_no_overlap;
+ALTER TABLE appointments DROP CONSTRAINT appointments_patient_no_overlap;
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_doctor_no_overlap
+    EXCLUDE USING gist (doctor_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status IN ('Scheduled', 'CheckedIn', 'InProgress') AND deleted_at IS NULL);
+
+ALTER TABLE appointments ADD CONSTRAINT appointments_patient_no_overlap
+    EXCLUDE USING gist (patient_id WITH =, tstzrange(appointment_date, end_date) WITH &&)
+    WHERE (status IN ('Scheduled', 'CheckedIn', 'InProgress') AND deleted_at IS NULL);
@@ -0,0 +1 @@
+CREATE INDEX idx_appointments_status_date ON appointments(status, appointment_date);
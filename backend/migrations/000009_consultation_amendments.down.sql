@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS consultations_immutable ON consultations;
+DROP TABLE IF EXISTS consultation_amendments CASCADE;
+DROP FUNCTION IF EXISTS prevent_clinical_record_update();
@@ -0,0 +1,30 @@
+CREATE TABLE consultation_amendments (
+    id SERIAL PRIMARY KEY,
+    consultation_id INTEGER NOT NULL REFERENCES consultations(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL CHECK (version > 1),
+    diagnosis TEXT,
+    notes TEXT,
+    author_id INTEGER NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (consultation_id, version)
+);
+
+-- The original consultation and every amendment are immutable, corrections are filed as new versions
+CREATE FUNCTION prevent_clinical_record_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'consultations' AND
+       NEW.diagnosis IS NOT DISTINCT FROM OLD.diagnosis AND NEW.notes IS NOT DISTINCT FROM OLD.notes THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION '% records are immutable, file an amendment instead', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER consultations_immutable
+    BEFORE UPDATE ON consultations
+    FOR EACH ROW EXECUTE FUNCTION prevent_clinical_record_update();
+
+CREATE TRIGGER consultation_amendments_immutable
+    BEFORE UPDATE ON consultation_amendments
+    FOR EACH ROW EXECUTE FUNCTION prevent_clinical_record_update();
@@ -0,0 +1,26 @@
+CREATE OR REPLACE FUNCTION prevent_clinical_record_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'consultations' AND
+       NEW.diagnosis IS NOT DISTINCT FROM OLD.diagnosis AND NEW.notes IS NOT DISTINCT FROM OLD.notes THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION '% records are immutable, file an amendment instead', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE consultation_amendments
+    DROP COLUMN IF EXISTS subjective,
+    DROP COLUMN IF EXISTS objective,
+    DROP COLUMN IF EXISTS assessment,
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS note_fields;
+
+ALTER TABLE consultations
+    DROP COLUMN IF EXISTS template_id,
+    DROP COLUMN IF EXISTS subjective,
+    DROP COLUMN IF EXISTS objective,
+    DROP COLUMN IF EXISTS assessment,
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS note_fields;
+
+DROP TABLE IF EXISTS note_templates CASCADE;
@@ -0,0 +1,43 @@
+CREATE TABLE note_templates (
+    id SERIAL PRIMARY KEY,
+    department_id INTEGER REFERENCES departments(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    required_sections JSONB NOT NULL DEFAULT '[]',
+    fields JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE NULL
+);
+CREATE INDEX idx_note_templates_deleted_at ON note_templates(deleted_at);
+CREATE INDEX idx_note_templates_department_id ON note_templates(department_id);
+
+ALTER TABLE consultations
+    ADD COLUMN template_id INTEGER REFERENCES note_templates(id),
+    ADD COLUMN subjective TEXT,
+    ADD COLUMN objective TEXT,
+    ADD COLUMN assessment TEXT,
+    ADD COLUMN plan TEXT,
+    ADD COLUMN note_fields JSONB;
+
+ALTER TABLE consultation_amendments
+    ADD COLUMN subjective TEXT,
+    ADD COLUMN objective TEXT,
+    ADD COLUMN assessment TEXT,
+    ADD COLUMN plan TEXT,
+    ADD COLUMN note_fields JSONB;
+
+-- Structured notes are part of the immutable clinical record as well
+CREATE OR REPLACE FUNCTION prevent_clinical_record_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'consultations' AND
+       NEW.diagnosis IS NOT DISTINCT FROM OLD.diagnosis AND NEW.notes IS NOT DISTINCT FROM OLD.notes AND
+       NEW.template_id IS NOT DISTINCT FROM OLD.template_id AND
+       NEW.subjective IS NOT DISTINCT FROM OLD.subjective AND NEW.objective IS NOT DISTINCT FROM OLD.objective AND
+       NEW.assessment IS NOT DISTINCT FROM OLD.assessment AND NEW.plan IS NOT DISTINCT FROM OLD.plan AND
+       NEW.note_fields IS NOT DISTINCT FROM OLD.note_fields THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION '% records are immutable, file an amendment instead', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
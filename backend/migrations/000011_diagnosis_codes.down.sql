@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS consultation_diagnoses CASCADE;
+DROP TABLE IF EXISTS diagnosis_codes CASCADE;
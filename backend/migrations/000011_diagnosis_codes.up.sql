@@ -0,0 +1,21 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE diagnosis_codes (
+    code VARCHAR(10) PRIMARY KEY,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_diagnosis_codes_code_prefix ON diagnosis_codes(code varchar_pattern_ops);
+CREATE INDEX idx_diagnosis_codes_description_trgm ON diagnosis_codes USING gin (description gin_trgm_ops);
+
+CREATE TABLE consultation_diagnoses (
+    id SERIAL PRIMARY KEY,
+    consultation_id INTEGER NOT NULL REFERENCES consultations(id) ON DELETE CASCADE,
+    code VARCHAR(10) NOT NULL REFERENCES diagnosis_codes(code),
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (consultation_id, code)
+);
+CREATE UNIQUE INDEX idx_consultation_diagnoses_one_primary ON consultation_diagnoses(consultation_id) WHERE is_primary;
+CREATE INDEX idx_consultation_diagnoses_code ON consultation_diagnoses(code);
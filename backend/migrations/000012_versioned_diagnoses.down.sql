@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS consultation_diagnoses_immutable ON consultation_diagnoses;
+DROP FUNCTION IF EXISTS prevent_consultation_diagnosis_change();
+
+DELETE FROM consultation_diagnoses WHERE amendment_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_consultation_diagnoses_amendment_id;
+DROP INDEX IF EXISTS idx_consultation_diagnoses_one_primary;
+DROP INDEX IF EXISTS idx_consultation_diagnoses_version_code;
+ALTER TABLE consultation_diagnoses DROP COLUMN IF EXISTS amendment_id;
+ALTER TABLE consultation_diagnoses ADD CONSTRAINT consultation_diagnoses_consultation_id_code_key UNIQUE (consultation_id, code);
+CREATE UNIQUE INDEX idx_consultation_diagnoses_one_primary ON consultation_diagnoses(consultation_id) WHERE is_primary;
@@ -0,0 +1,25 @@
+-- Coded diagnoses are versioned with the consultation: rows without an amendment belong to the original,
+-- every amendment carries the complete set of codes of its version
+ALTER TABLE consultation_diagnoses
+    ADD COLUMN amendment_id INTEGER REFERENCES consultation_amendments(id) ON DELETE CASCADE;
+
+ALTER TABLE consultation_diagnoses DROP CONSTRAINT consultation_diagnoses_consultation_id_code_key;
+DROP INDEX idx_consultation_diagnoses_one_primary;
+CREATE UNIQUE INDEX idx_consultation_diagnoses_version_code ON consultation_diagnoses(consultation_id, COALESCE(amendment_id, 0), code);
+CREATE UNIQUE INDEX idx_consultation_diagnoses_one_primary ON consultation_diagnoses(consultation_id, COALESCE(amendment_id, 0)) WHERE is_primary;
+CREATE INDEX idx_consultation_diagnoses_amendment_id ON consultation_diagnoses(amendment_id);
+
+-- Coded diagnoses are part of the immutable clinical record; only the cascade from a deleted consultation
+-- or amendment may remove them
+CREATE FUNCTION prevent_consultation_diagnosis_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND pg_trigger_depth() > 1 THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'coded diagnoses are immutable, file an amendment instead';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER consultation_diagnoses_immutable
+    BEFORE UPDATE OR DELETE ON consultation_diagnoses
+    FOR EACH ROW EXECUTE FUNCTION prevent_consultation_diagnosis_change();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_prescriptions_status_expires_at;
+ALTER TABLE prescriptions DROP CONSTRAINT IF EXISTS prescriptions_status_check;
+ALTER TABLE prescriptions DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,10 @@
+ALTER TABLE prescriptions ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
+UPDATE prescriptions SET expires_at = created_at + INTERVAL '30 days';
+ALTER TABLE prescriptions ALTER COLUMN expires_at SET NOT NULL;
+
+-- Rows wiped by the old status update (empty status) fall back to Issued
+UPDATE prescriptions SET status = 'Issued' WHERE status NOT IN ('Issued', 'PartiallyDispensed', 'Dispensed', 'Cancelled', 'Expired');
+ALTER TABLE prescriptions ADD CONSTRAINT prescriptions_status_check
+    CHECK (status IN ('Issued', 'PartiallyDispensed', 'Dispensed', 'Cancelled', 'Expired'));
+
+CREATE INDEX idx_prescriptions_status_expires_at ON prescriptions(status, expires_at);
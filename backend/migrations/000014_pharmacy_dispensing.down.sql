@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS prescription_dispenses;
+
+DROP INDEX IF EXISTS idx_prescriptions_verification_code;
+ALTER TABLE prescriptions DROP COLUMN IF EXISTS verification_code;
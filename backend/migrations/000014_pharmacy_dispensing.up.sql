@@ -0,0 +1,16 @@
+ALTER TABLE prescriptions ADD COLUMN verification_code VARCHAR(16);
+-- Existing prescriptions get a random code so pharmacies can look them up as well
+UPDATE prescriptions SET verification_code = upper(substr(md5(random()::text || id::text), 1, 12));
+ALTER TABLE prescriptions ALTER COLUMN verification_code SET NOT NULL;
+CREATE UNIQUE INDEX idx_prescriptions_verification_code ON prescriptions(verification_code);
+
+CREATE TABLE prescription_dispenses (
+    id SERIAL PRIMARY KEY,
+    prescription_id INTEGER NOT NULL REFERENCES prescriptions(id) ON DELETE CASCADE,
+    pharmacist_id INTEGER NOT NULL REFERENCES users(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    notes TEXT,
+    dispensed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_prescription_dispenses_prescription ON prescription_dispenses(prescription_id);
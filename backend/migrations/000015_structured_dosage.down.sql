@@ -0,0 +1,8 @@
+ALTER TABLE prescriptions
+    DROP COLUMN IF EXISTS strength,
+    DROP COLUMN IF EXISTS strength_unit,
+    DROP COLUMN IF EXISTS route,
+    DROP COLUMN IF EXISTS frequency,
+    DROP COLUMN IF EXISTS duration_days,
+    DROP COLUMN IF EXISTS quantity,
+    DROP COLUMN IF EXISTS refills;
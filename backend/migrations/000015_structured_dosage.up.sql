@@ -0,0 +1,9 @@
+-- Structured dosage; prescriptions issued before this keep only the free-text dosage
+ALTER TABLE prescriptions
+    ADD COLUMN strength NUMERIC(12, 3) NOT NULL DEFAULT 0,
+    ADD COLUMN strength_unit VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN route VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN frequency VARCHAR(10) NOT NULL DEFAULT '',
+    ADD COLUMN duration_days INTEGER NOT NULL DEFAULT 0 CHECK (duration_days >= 0),
+    ADD COLUMN quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    ADD COLUMN refills INTEGER NOT NULL DEFAULT 0 CHECK (refills >= 0);
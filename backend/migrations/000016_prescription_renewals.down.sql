@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS prescription_renewals;
+
+DROP INDEX IF EXISTS idx_prescriptions_renewed_from;
+ALTER TABLE prescriptions DROP COLUMN IF EXISTS renewed_from_id;
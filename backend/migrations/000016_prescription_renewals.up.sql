@@ -0,0 +1,19 @@
+-- A renewal is issued as a new prescription pointing at the one it renews; each can be renewed once
+ALTER TABLE prescriptions ADD COLUMN renewed_from_id INTEGER REFERENCES prescriptions(id);
+CREATE UNIQUE INDEX idx_prescriptions_renewed_from ON prescriptions(renewed_from_id) WHERE renewed_from_id IS NOT NULL;
+
+CREATE TABLE prescription_renewals (
+    id SERIAL PRIMARY KEY,
+    prescription_id INTEGER NOT NULL REFERENCES prescriptions(id) ON DELETE CASCADE,
+    requested_by_id INTEGER NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'Pending' CHECK (status IN ('Pending', 'Approved', 'Denied')),
+    note TEXT,
+    decided_by_id INTEGER REFERENCES users(id),
+    decision_reason TEXT,
+    renewal_id INTEGER REFERENCES prescriptions(id),
+    decided_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- At most one open request per prescription
+CREATE UNIQUE INDEX idx_prescription_renewals_pending ON prescription_renewals(prescription_id) WHERE status = 'Pending';
@@ -0,0 +1,4 @@
+ALTER TABLE prescriptions DROP COLUMN IF EXISTS drug_code;
+
+DROP TABLE IF EXISTS drug_interactions;
+DROP TABLE IF EXISTS drugs;
@@ -0,0 +1,26 @@
+CREATE TABLE drugs (
+    code VARCHAR(32) PRIMARY KEY,
+    name TEXT NOT NULL,
+    ingredient TEXT NOT NULL,
+    drug_class TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_drugs_name_lower ON drugs(lower(name));
+CREATE INDEX idx_drugs_name_trgm ON drugs USING gin (name gin_trgm_ops);
+CREATE INDEX idx_drugs_ingredient ON drugs(ingredient);
+
+-- Interactions are recorded between active ingredients, each pair once with ingredient_a < ingredient_b
+CREATE TABLE drug_interactions (
+    id SERIAL PRIMARY KEY,
+    ingredient_a TEXT NOT NULL,
+    ingredient_b TEXT NOT NULL,
+    severity VARCHAR(20) NOT NULL CHECK (severity IN ('minor', 'moderate', 'major', 'contraindicated')),
+    description TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ingredient_a < ingredient_b),
+    UNIQUE (ingredient_a, ingredient_b)
+);
+
+ALTER TABLE prescriptions ADD COLUMN drug_code VARCHAR(32) REFERENCES drugs(code);
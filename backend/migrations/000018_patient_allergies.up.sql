@@ -0,0 +1,16 @@
+CREATE TABLE patient_allergies (
+    id SERIAL PRIMARY KEY,
+    patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    substance TEXT NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'allergy' CHECK (kind IN ('allergy', 'intolerance')),
+    reaction TEXT,
+    severity VARCHAR(20) NOT NULL CHECK (severity IN ('mild', 'moderate', 'severe')),
+    verified_by_id INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_patient_allergies_patient ON patient_allergies(patient_id) WHERE deleted_at IS NULL;
+-- A substance is recorded once per patient
+CREATE UNIQUE INDEX idx_patient_allergies_substance ON patient_allergies(patient_id, substance) WHERE deleted_at IS NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE observations (
+    id SERIAL PRIMARY KEY,
+    patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('blood_pressure', 'heart_rate', 'temperature', 'spo2', 'weight', 'glucose')),
+    value NUMERIC(8, 2) NOT NULL,
+    diastolic_value NUMERIC(8, 2),
+    unit VARCHAR(10) NOT NULL,
+    observed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    source VARCHAR(20) NOT NULL CHECK (source IN ('clinician', 'patient', 'device')),
+    recorded_by_id INTEGER REFERENCES users(id),
+    consultation_id INTEGER REFERENCES consultations(id) ON DELETE SET NULL,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((type = 'blood_pressure') = (diastolic_value IS NOT NULL))
+);
+
+CREATE INDEX idx_observations_patient_observed ON observations(patient_id, type, observed_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_observations_device_reading;
+ALTER TABLE observations DROP COLUMN IF EXISTS device_id;
+
+DROP TABLE IF EXISTS device_batches;
+DROP TABLE IF EXISTS devices;
@@ -0,0 +1,28 @@
+CREATE TABLE devices (
+    id SERIAL PRIMARY KEY,
+    patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('bp_cuff', 'pulse_oximeter', 'thermometer', 'scale', 'glucometer')),
+    label TEXT,
+    serial_number TEXT,
+    secret_hash VARCHAR(64) NOT NULL,
+    registered_by_id INTEGER NOT NULL REFERENCES users(id),
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_devices_patient ON devices(patient_id);
+
+-- Batches already accepted per device, so retried uploads are acknowledged without storing readings twice
+CREATE TABLE device_batches (
+    id SERIAL PRIMARY KEY,
+    device_id INTEGER NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    batch_id VARCHAR(64) NOT NULL,
+    readings INTEGER NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (device_id, batch_id)
+);
+
+ALTER TABLE observations ADD COLUMN device_id INTEGER REFERENCES devices(id) ON DELETE SET NULL;
+-- A device reports each measurement once, even when the same reading shows up in different batches
+CREATE UNIQUE INDEX idx_observations_device_reading ON observations(device_id, type, observed_at) WHERE device_id IS NOT NULL;
@@ -0,0 +1,37 @@
+-- A rule applies to one patient, to every patient diagnosed with a condition (ICD-10 code prefix), or to everyone
+CREATE TABLE alert_rules (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    patient_id INTEGER REFERENCES patients(id) ON DELETE CASCADE,
+    condition_code VARCHAR(10),
+    metric VARCHAR(20) NOT NULL CHECK (metric IN ('systolic', 'diastolic', 'heart_rate', 'temperature', 'spo2', 'weight', 'glucose')),
+    operator VARCHAR(3) NOT NULL CHECK (operator IN ('gt', 'gte', 'lt', 'lte')),
+    threshold NUMERIC(8, 2) NOT NULL,
+    consecutive_readings INTEGER NOT NULL DEFAULT 1 CHECK (consecutive_readings BETWEEN 1 AND 50),
+    severity VARCHAR(10) NOT NULL CHECK (severity IN ('warning', 'critical')),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by_id INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (patient_id IS NULL OR condition_code IS NULL)
+);
+CREATE INDEX idx_alert_rules_patient ON alert_rules(patient_id) WHERE active;
+
+CREATE TABLE alerts (
+    id SERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    observation_id INTEGER REFERENCES observations(id) ON DELETE SET NULL,
+    doctor_id INTEGER REFERENCES doctors(id) ON DELETE SET NULL,
+    value NUMERIC(8, 2) NOT NULL,
+    message TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'acknowledged', 'resolved')),
+    acknowledged_by_id INTEGER REFERENCES users(id),
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    resolved_by_id INTEGER REFERENCES users(id),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_alerts_doctor_status ON alerts(doctor_id, status);
+-- While an alert is unresolved the same rule does not raise another one for the patient
+CREATE UNIQUE INDEX idx_alerts_unresolved ON alerts(rule_id, patient_id) WHERE status <> 'resolved';
@@ -0,0 +1,2 @@
+-- Groups asserted by the user's identity provider at the last login, empty for Google accounts
+ALTER TABLE users ADD COLUMN idp_groups JSONB NOT NULL DEFAULT '[]';
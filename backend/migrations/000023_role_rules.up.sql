@@ -0,0 +1,15 @@
+-- Who decided a user's current role; roles granted by a role rule are revoked when the rule stops matching
+ALTER TABLE users ADD COLUMN role_source VARCHAR(10) NOT NULL DEFAULT 'default'
+    CHECK (role_source IN ('default', 'admin', 'rule'));
+
+-- Audit trail of the roles assigned automatically from identity provider claims
+CREATE TABLE user_role_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_role VARCHAR(20) NOT NULL, -- empty when the account was created
+    to_role VARCHAR(20) NOT NULL,
+    department_id INTEGER REFERENCES departments(id),
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_user_role_events_user_id ON user_role_events(user_id);
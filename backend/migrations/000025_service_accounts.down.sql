@@ -0,0 +1,6 @@
+UPDATE observations SET source = 'clinician' WHERE source = 'service';
+ALTER TABLE observations DROP CONSTRAINT observations_source_check;
+ALTER TABLE observations ADD CONSTRAINT observations_source_check CHECK (source IN ('clinician', 'patient', 'device'));
+
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS service_accounts;
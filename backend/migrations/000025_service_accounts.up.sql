@@ -0,0 +1,31 @@
+-- Principals of machine integrations. Each acts as a user with the 'service' role, so the records
+-- it writes (dispenses, observations, ...) reference users like those written by staff.
+CREATE TABLE service_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    description TEXT,
+    created_by_id INTEGER NOT NULL REFERENCES users(id),
+    disabled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- API keys are "<id>.<secret>" and only the hash of the secret is kept. Scopes name Casbin subjects.
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    service_account_id INTEGER NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    name TEXT,
+    secret_hash VARCHAR(64) NOT NULL,
+    scopes JSONB NOT NULL DEFAULT '[]',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    rotated_from_id INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_by_id INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_api_keys_service_account ON api_keys(service_account_id);
+
+ALTER TABLE observations DROP CONSTRAINT observations_source_check;
+ALTER TABLE observations ADD CONSTRAINT observations_source_check CHECK (source IN ('clinician', 'patient', 'device', 'service'));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_idp_identity;
+ALTER TABLE users DROP COLUMN IF EXISTS idp_subject;
+ALTER TABLE users DROP COLUMN IF EXISTS idp_issuer;
@@ -0,0 +1,10 @@
+-- The identity provider account a user signed up with. Accounts are looked up by email, the issuer
+-- and subject keep another provider asserting the same address from signing in to the account.
+ALTER TABLE users ADD COLUMN idp_issuer VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN idp_subject VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Every account created before this migration with a Google ID signed up through Google
+UPDATE users SET idp_issuer = 'https://accounts.google.com', idp_subject = google_id
+    WHERE google_id IS NOT NULL AND google_id <> '';
+
+CREATE UNIQUE INDEX idx_users_idp_identity ON users(idp_issuer, idp_subject) WHERE idp_issuer <> '';
@@ -0,0 +1,3 @@
+-- The hash of the refresh token the last refresh replaced. Presenting that token again means it
+-- leaked and ends the session, any other unknown token is just refused.
+ALTER TABLE sessions ADD COLUMN previous_refresh_token_hash VARCHAR(64) NOT NULL DEFAULT '';
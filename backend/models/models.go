@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,38 +12,158 @@ import (
 type UserRole string
 
 const (
-	RoleAdmin   UserRole = "admin"
-	RoleDoctor  UserRole = "doctor"
-	RolePatient UserRole = "patient"
+	RoleAdmin      UserRole = "admin"
+	RoleDoctor     UserRole = "doctor"
+	RolePatient    UserRole = "patient"
+	RolePharmacist UserRole = "pharmacist"
+	RoleService    UserRole = "service" // principal of a service account, only the scopes of its API keys grant access
 
 	RFC3339NoNano = "2006-01-02T15:04:05Z07:00"
 )
 
+// RoleSource records who decided a user's current role
+type RoleSource string
+
+const (
+	RoleSourceDefault RoleSource = "default" // the patient role every new account starts with
+	RoleSourceAdmin   RoleSource = "admin"
+	RoleSourceRule    RoleSource = "rule" // a role rule matching the identity provider claims
+)
+
 type AppointmentStatus string
 
 const (
-	StatusScheduled AppointmentStatus = "Scheduled"
-	StatusCancelled AppointmentStatus = "Cancelled"
-	StatusCompleted AppointmentStatus = "Completed"
+	StatusScheduled  AppointmentStatus = "Scheduled"
+	StatusCheckedIn  AppointmentStatus = "CheckedIn"
+	StatusInProgress AppointmentStatus = "InProgress"
+	StatusCompleted  AppointmentStatus = "Completed"
+	StatusCancelled  AppointmentStatus = "Cancelled"
+	StatusNoShow     AppointmentStatus = "NoShow"
+)
+
+// ActiveAppointmentStatuses are the statuses in which an appointment still occupies its slot
+var ActiveAppointmentStatuses = []AppointmentStatus{StatusScheduled, StatusCheckedIn, StatusInProgress}
+
+type AbsenceScope string
+
+const (
+	AbsenceDoctor     AbsenceScope = "doctor"
+	AbsenceDepartment AbsenceScope = "department"
+	AbsenceClinic     AbsenceScope = "clinic"
 )
 
 type PrescriptionStatus string
 
 const (
-	StatusIssued    PrescriptionStatus = "Issued"
-	StatusDispensed PrescriptionStatus = "Dispensed"
+	StatusIssued                PrescriptionStatus = "Issued"
+	StatusPartiallyDispensed    PrescriptionStatus = "PartiallyDispensed"
+	StatusDispensed             PrescriptionStatus = "Dispensed"
+	StatusPrescriptionCancelled PrescriptionStatus = "Cancelled"
+	StatusExpired               PrescriptionStatus = "Expired"
+)
+
+type RenewalStatus string
+
+const (
+	RenewalPending  RenewalStatus = "Pending"
+	RenewalApproved RenewalStatus = "Approved"
+	RenewalDenied   RenewalStatus = "Denied"
 )
 
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
-	GoogleID  string         `gorm:"uniqueIndex" json:"google_id"`
-	Name      string         `json:"name"`
-	Picture   string         `json:"picture"`
-	Role      UserRole       `json:"role"` // admin, doctor, patient
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	Email      string         `gorm:"uniqueIndex;not null" json:"email"`
+	GoogleID   string         `gorm:"uniqueIndex" json:"google_id"`
+	Name       string         `json:"name"`
+	Picture    string         `json:"picture"`
+	Role       UserRole       `json:"role"` // admin, doctor, patient, pharmacist
+	Groups     StringList     `gorm:"column:idp_groups;type:jsonb" json:"groups"`
+	Issuer     string         `gorm:"column:idp_issuer" json:"idp_issuer"`   // identity provider the account signed up with
+	Subject    string         `gorm:"column:idp_subject" json:"idp_subject"` // the account's id at that provider
+	RoleSource RoleSource     `json:"role_source"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Session is a sign-in of a user on one client. The client holds a short-lived access token and a
+// refresh token of the form "<session id>.<secret>", of which only the SHA-256 hash of the secret is stored.
+type Session struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	UserID           uint       `json:"user_id"`
+	User             User       `gorm:"foreignKey:UserID" json:"-"`
+	RefreshTokenHash string     `json:"-"`
+	PreviousHash     string     `gorm:"column:previous_refresh_token_hash" json:"-"` // the refresh token replaced by the last refresh
+	UserAgent        string     `json:"user_agent"`
+	IPAddress        string     `json:"ip_address"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	ExpiresAt        time.Time  `json:"expires_at"` // when the refresh token runs out; every refresh moves it forward
+	RevokedAt        *time.Time `json:"revoked_at"`
+	RevokedReason    string     `json:"revoked_reason"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// APIScope is a permission an API key carries. Each scope is a Casbin subject, see Subject, whose
+// policies list the routes it opens.
+type APIScope string
+
+const (
+	ScopePatientsRead      APIScope = "patients:read"
+	ScopeObservationsRead  APIScope = "observations:read"
+	ScopeObservationsWrite APIScope = "observations:write"
+	ScopePharmacy          APIScope = "pharmacy" // look up, verify and dispense prescriptions
+	ScopeCatalogRead       APIScope = "catalog:read"
+)
+
+// APIScopes are the scopes an API key may be given
+var APIScopes = []APIScope{ScopePatientsRead, ScopeObservationsRead, ScopeObservationsWrite, ScopePharmacy, ScopeCatalogRead}
+
+// Subject is the Casbin subject holding the policies of the scope
+func (s APIScope) Subject() string {
+	return "scope:" + string(s)
+}
+
+// ServiceAccount is the principal of a machine integration such as a lab system or a pharmacy kiosk.
+// It acts as a user with the service role, so the records it writes reference a user like those of staff.
+type ServiceAccount struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `json:"user_id"`
+	User        User       `gorm:"foreignKey:UserID" json:"-"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	CreatedByID uint       `json:"created_by_id"`
+	DisabledAt  *time.Time `json:"disabled_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Keys        []APIKey   `gorm:"foreignKey:ServiceAccountID" json:"keys,omitempty"`
+}
+
+// APIKey is a credential of a service account of the form "<key id>.<secret>", of which only the
+// SHA-256 hash of the secret is stored
+type APIKey struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	ServiceAccountID uint            `json:"service_account_id"`
+	ServiceAccount   *ServiceAccount `json:"-"`
+	Name             string          `json:"name"`
+	SecretHash       string          `json:"-"`
+	Scopes           StringList      `gorm:"type:jsonb" json:"scopes"`
+	ExpiresAt        *time.Time      `json:"expires_at"` // nil for keys that do not expire
+	LastUsedAt       *time.Time      `json:"last_used_at"`
+	RotatedFromID    *uint           `json:"rotated_from_id"`
+	RevokedAt        *time.Time      `json:"revoked_at"`
+	CreatedByID      uint            `json:"created_by_id"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// UserRoleEvent is the audit trail entry written when a role rule changes a user's role at login
+type UserRoleEvent struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `json:"user_id"`
+	FromRole     UserRole  `json:"from_role"` // empty when the account was created
+	ToRole       UserRole  `json:"to_role"`
+	DepartmentID *uint     `json:"department_id"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type Department struct {
@@ -73,6 +196,182 @@ type Patient struct {
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+type AllergyKind string
+
+const (
+	AllergyKindAllergy     AllergyKind = "allergy"
+	AllergyKindIntolerance AllergyKind = "intolerance"
+)
+
+type AllergySeverity string
+
+const (
+	AllergyMild     AllergySeverity = "mild"
+	AllergyModerate AllergySeverity = "moderate"
+	AllergySevere   AllergySeverity = "severe"
+)
+
+// PatientAllergy is an allergy or intolerance recorded for a patient by a doctor
+type PatientAllergy struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	PatientID    uint            `json:"patient_id"`
+	Substance    string          `json:"substance"` // lower case ingredient, drug class or other substance
+	Kind         AllergyKind     `json:"kind"`
+	Reaction     string          `json:"reaction"`
+	Severity     AllergySeverity `json:"severity"`
+	VerifiedByID uint            `json:"verified_by_id"`
+	VerifiedBy   User            `gorm:"foreignKey:VerifiedByID" json:"verified_by"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+type ObservationType string
+
+const (
+	ObservationBloodPressure ObservationType = "blood_pressure"
+	ObservationHeartRate     ObservationType = "heart_rate"
+	ObservationTemperature   ObservationType = "temperature"
+	ObservationSpO2          ObservationType = "spo2"
+	ObservationWeight        ObservationType = "weight"
+	ObservationGlucose       ObservationType = "glucose"
+)
+
+type ObservationSource string
+
+const (
+	SourceClinician ObservationSource = "clinician"
+	SourcePatient   ObservationSource = "patient"
+	SourceDevice    ObservationSource = "device"
+	SourceService   ObservationSource = "service" // sent by an integration through a service account, e.g. lab results
+)
+
+// Observation is a single vital sign measurement. Values are stored in the canonical unit of their type;
+// for blood pressure Value is the systolic and DiastolicValue the diastolic pressure.
+type Observation struct {
+	ID             uint              `gorm:"primaryKey" json:"id"`
+	PatientID      uint              `json:"patient_id"`
+	Type           ObservationType   `json:"type"`
+	Value          float64           `json:"value"`
+	DiastolicValue *float64          `json:"diastolic_value,omitempty"`
+	Unit           string            `json:"unit"`
+	ObservedAt     time.Time         `json:"observed_at"`
+	Source         ObservationSource `json:"source"`
+	RecordedByID   *uint             `json:"recorded_by_id"`
+	ConsultationID *uint             `json:"consultation_id"`
+	DeviceID       *uint             `json:"device_id"`
+	Note           string            `json:"note"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+type AlertMetric string
+
+const (
+	MetricSystolic    AlertMetric = "systolic"
+	MetricDiastolic   AlertMetric = "diastolic"
+	MetricHeartRate   AlertMetric = "heart_rate"
+	MetricTemperature AlertMetric = "temperature"
+	MetricSpO2        AlertMetric = "spo2"
+	MetricWeight      AlertMetric = "weight"
+	MetricGlucose     AlertMetric = "glucose"
+)
+
+type AlertOperator string
+
+const (
+	OperatorGreaterThan    AlertOperator = "gt"
+	OperatorGreaterOrEqual AlertOperator = "gte"
+	OperatorLessThan       AlertOperator = "lt"
+	OperatorLessOrEqual    AlertOperator = "lte"
+)
+
+type AlertSeverity string
+
+const (
+	AlertWarning  AlertSeverity = "warning"
+	AlertCritical AlertSeverity = "critical"
+)
+
+// AlertRule raises an alert when the last ConsecutiveReadings values of a metric all breach the threshold.
+// It applies to one patient, to patients diagnosed with ConditionCode (an ICD-10 code prefix), or to all patients.
+type AlertRule struct {
+	ID                  uint          `gorm:"primaryKey" json:"id"`
+	Name                string        `json:"name"`
+	PatientID           *uint         `json:"patient_id"`
+	ConditionCode       *string       `json:"condition_code"`
+	Metric              AlertMetric   `json:"metric"`
+	Operator            AlertOperator `json:"operator"`
+	Threshold           float64       `json:"threshold"`
+	ConsecutiveReadings int           `json:"consecutive_readings"`
+	Severity            AlertSeverity `json:"severity"`
+	Active              bool          `json:"active"`
+	CreatedByID         uint          `json:"created_by_id"`
+	CreatedAt           time.Time     `json:"created_at"`
+	UpdatedAt           time.Time     `json:"updated_at"`
+}
+
+type AlertStatus string
+
+const (
+	AlertOpen         AlertStatus = "open"
+	AlertAcknowledged AlertStatus = "acknowledged"
+	AlertResolved     AlertStatus = "resolved"
+)
+
+// Alert is raised by an AlertRule for a patient and lands in the inbox of the responsible doctor
+type Alert struct {
+	ID               uint        `gorm:"primaryKey" json:"id"`
+	RuleID           uint        `json:"rule_id"`
+	Rule             AlertRule   `gorm:"foreignKey:RuleID" json:"rule"`
+	PatientID        uint        `json:"patient_id"`
+	Patient          Patient     `gorm:"foreignKey:PatientID" json:"patient"`
+	ObservationID    *uint       `json:"observation_id"`
+	DoctorID         *uint       `json:"doctor_id"`
+	Value            float64     `json:"value"`
+	Message          string      `json:"message"`
+	Status           AlertStatus `json:"status"`
+	AcknowledgedByID *uint       `json:"acknowledged_by_id"`
+	AcknowledgedAt   *time.Time  `json:"acknowledged_at"`
+	ResolvedByID     *uint       `json:"resolved_by_id"`
+	ResolvedAt       *time.Time  `json:"resolved_at"`
+	CreatedAt        time.Time   `json:"created_at"`
+}
+
+type DeviceType string
+
+const (
+	DeviceBPCuff        DeviceType = "bp_cuff"
+	DevicePulseOximeter DeviceType = "pulse_oximeter"
+	DeviceThermometer   DeviceType = "thermometer"
+	DeviceScale         DeviceType = "scale"
+	DeviceGlucometer    DeviceType = "glucometer"
+)
+
+// Device is a home monitoring device registered to a patient. It authenticates with its own
+// credential, of which only the SHA-256 hash of the secret part is stored.
+type Device struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	PatientID      uint       `json:"patient_id"`
+	Type           DeviceType `json:"type"`
+	Label          string     `json:"label"`
+	SerialNumber   string     `json:"serial_number"`
+	SecretHash     string     `json:"-"`
+	RegisteredByID uint       `json:"registered_by_id"`
+	LastSeenAt     *time.Time `json:"last_seen_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// DeviceBatch records a telemetry batch already accepted from a device
+type DeviceBatch struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DeviceID   uint      `json:"device_id"`
+	BatchID    string    `json:"batch_id"`
+	Readings   int       `json:"readings"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
 type Appointment struct {
 	ID              uint              `gorm:"primaryKey" json:"id"`
 	PatientID       uint              `json:"patient_id"`
@@ -80,32 +379,342 @@ type Appointment struct {
 	DoctorID        uint              `json:"doctor_id"`
 	Doctor          Doctor            `gorm:"foreignKey:DoctorID" json:"doctor"`
 	AppointmentDate time.Time         `json:"appointment_date"`
-	Status          AppointmentStatus `json:"status"` // Scheduled, Cancelled, Completed
+	EndDate         time.Time         `json:"end_date"`
+	Status          AppointmentStatus `json:"status"`           // Scheduled, CheckedIn, InProgress, Completed, Cancelled, NoShow
+	NeedsReschedule bool              `json:"needs_reschedule"` // set when an absence is filed over a booked slot
 	CreatedAt       time.Time         `json:"created_at"`
 	UpdatedAt       time.Time         `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt    `gorm:"index" json:"-"`
 }
 
+// AppointmentHistory records the previous times of an appointment each time it is rescheduled
+type AppointmentHistory struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	AppointmentID   uint      `json:"appointment_id"`
+	PreviousDate    time.Time `json:"previous_date"`
+	PreviousEndDate time.Time `json:"previous_end_date"`
+	NewDate         time.Time `json:"new_date"`
+	NewEndDate      time.Time `json:"new_end_date"`
+	ChangedByID     uint      `json:"changed_by_id"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// AppointmentStatusEvent is the audit trail entry written for every appointment status transition
+type AppointmentStatusEvent struct {
+	ID            uint              `gorm:"primaryKey" json:"id"`
+	AppointmentID uint              `json:"appointment_id"`
+	FromStatus    AppointmentStatus `json:"from_status"`
+	ToStatus      AppointmentStatus `json:"to_status"`
+	ActorID       *uint             `json:"actor_id"` // nil when the transition was made by the system
+	Reason        string            `json:"reason"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
 type Consultation struct {
-	ID            uint           `gorm:"primaryKey" json:"id"`
-	AppointmentID uint           `json:"appointment_id"`
-	Appointment   Appointment    `gorm:"foreignKey:AppointmentID" json:"appointment"`
-	Diagnosis     string         `json:"diagnosis"`
-	Notes         string         `json:"notes"`
-	Date          time.Time      `json:"date"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+	ID            uint                    `gorm:"primaryKey" json:"id"`
+	AppointmentID uint                    `json:"appointment_id"`
+	Appointment   Appointment             `gorm:"foreignKey:AppointmentID" json:"appointment"`
+	Diagnosis     string                  `json:"diagnosis"`
+	Notes         string                  `json:"notes"`
+	TemplateID    *uint                   `json:"template_id"`
+	SOAP          SOAPNote                `gorm:"embedded" json:"soap"`
+	Diagnoses     []ConsultationDiagnosis `gorm:"foreignKey:ConsultationID" json:"diagnoses"`
+	Date          time.Time               `json:"date"`
+	CreatedAt     time.Time               `json:"created_at"`
+	UpdatedAt     time.Time               `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt          `gorm:"index" json:"-"`
+}
+
+// DiagnosisCode is an entry of the locally imported ICD-10 catalog
+type DiagnosisCode struct {
+	Code        string    `gorm:"primaryKey" json:"code"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Drug is an entry of the local drug catalog
+type Drug struct {
+	Code       string    `gorm:"primaryKey" json:"code"`
+	Name       string    `json:"name"`
+	Ingredient string    `json:"ingredient"` // active ingredient, lower case; interactions are recorded per ingredient
+	DrugClass  string    `json:"drug_class"` // e.g. "penicillin", lower case
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type InteractionSeverity string
+
+const (
+	InteractionMinor           InteractionSeverity = "minor"
+	InteractionModerate        InteractionSeverity = "moderate"
+	InteractionMajor           InteractionSeverity = "major"
+	InteractionContraindicated InteractionSeverity = "contraindicated"
+)
+
+// DrugInteraction is a known interaction between two active ingredients, stored with IngredientA < IngredientB
+type DrugInteraction struct {
+	ID          uint                `gorm:"primaryKey" json:"id"`
+	IngredientA string              `json:"ingredient_a"`
+	IngredientB string              `json:"ingredient_b"`
+	Severity    InteractionSeverity `json:"severity"`
+	Description string              `json:"description"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// ConsultationDiagnosis links a version of a consultation to a coded diagnosis; each version has at most one primary
+type ConsultationDiagnosis struct {
+	ID             uint          `gorm:"primaryKey" json:"id"`
+	ConsultationID uint          `json:"consultation_id"`
+	AmendmentID    *uint         `json:"amendment_id"` // nil for the codes of the original consultation
+	Code           string        `json:"code"`
+	DiagnosisCode  DiagnosisCode `gorm:"foreignKey:Code;references:Code" json:"diagnosis_code"`
+	IsPrimary      bool          `json:"is_primary"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// ConsultationAmendment is a later version of a consultation. The original consultation is never
+// overwritten; version 1 is the consultation itself and each amendment increments the version.
+type ConsultationAmendment struct {
+	ID             uint                    `gorm:"primaryKey" json:"id"`
+	ConsultationID uint                    `json:"consultation_id"`
+	Version        int                     `json:"version"`
+	Diagnosis      string                  `json:"diagnosis"`
+	Notes          string                  `json:"notes"`
+	SOAP           SOAPNote                `gorm:"embedded" json:"soap"`
+	Diagnoses      []ConsultationDiagnosis `gorm:"foreignKey:AmendmentID" json:"diagnoses"`
+	AuthorID       uint                    `json:"author_id"`
+	Author         User                    `gorm:"foreignKey:AuthorID" json:"author"`
+	Reason         string                  `json:"reason"`
+	CreatedAt      time.Time               `json:"created_at"`
 }
 
 type Prescription struct {
-	ID             uint               `gorm:"primaryKey" json:"id"`
-	ConsultationID uint               `json:"consultation_id"`
-	Consultation   Consultation       `gorm:"foreignKey:ConsultationID" json:"consultation"`
-	Medication     string             `json:"medication"`
-	Dosage         string             `json:"dosage"`
-	Status         PrescriptionStatus `json:"status"` // Issued, Dispensed
-	CreatedAt      time.Time          `json:"created_at"`
-	UpdatedAt      time.Time          `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt     `gorm:"index" json:"-"`
+	ID               uint                   `gorm:"primaryKey" json:"id"`
+	ConsultationID   uint                   `json:"consultation_id"`
+	Consultation     Consultation           `gorm:"foreignKey:ConsultationID" json:"consultation"`
+	DrugCode         *string                `json:"drug_code"` // catalog entry, nil for free-text medications
+	Drug             *Drug                  `gorm:"foreignKey:DrugCode" json:"drug,omitempty"`
+	Medication       string                 `json:"medication"`
+	Dosage           string                 `json:"dosage"`   // human-readable sig, rendered from the structured fields when present
+	Strength         float64                `json:"strength"` // amount per dose, in StrengthUnit
+	StrengthUnit     string                 `json:"strength_unit"`
+	Route            DosageRoute            `json:"route"`
+	Frequency        DosageFrequency        `json:"frequency"`
+	DurationDays     int                    `json:"duration_days"`
+	Quantity         int                    `json:"quantity"`        // doses to dispense in total
+	Refills          int                    `json:"refills"`         // repeats authorized beyond this one, each renewal uses one up
+	RenewedFromID    *uint                  `json:"renewed_from_id"` // the prescription this one renews
+	Status           PrescriptionStatus     `json:"status"`          // Issued, PartiallyDispensed, Dispensed, Cancelled, Expired
+	ExpiresAt        time.Time              `json:"expires_at"`
+	VerificationCode string                 `gorm:"uniqueIndex" json:"verification_code"` // presented at the pharmacy, generated on issue
+	Dispenses        []PrescriptionDispense `gorm:"foreignKey:PrescriptionID" json:"dispenses,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt         `gorm:"index" json:"-"`
+}
+
+// PrescriptionRenewal is a patient's request to repeat a prescription without a new appointment.
+// Approving it issues a new prescription linked to the original through RenewedFromID.
+type PrescriptionRenewal struct {
+	ID             uint          `gorm:"primaryKey" json:"id"`
+	PrescriptionID uint          `json:"prescription_id"`
+	Prescription   Prescription  `gorm:"foreignKey:PrescriptionID" json:"prescription"`
+	RequestedByID  uint          `json:"requested_by_id"`
+	Status         RenewalStatus `json:"status"`
+	Note           string        `json:"note"`
+	DecidedByID    *uint         `json:"decided_by_id"`
+	DecisionReason string        `json:"decision_reason"`
+	RenewalID      *uint         `json:"renewal_id"` // the prescription issued on approval
+	DecidedAt      *time.Time    `json:"decided_at"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// DosageRoute is how a medication is administered
+type DosageRoute string
+
+const (
+	RouteOral        DosageRoute = "oral"
+	RouteSublingual  DosageRoute = "sublingual"
+	RouteTopical     DosageRoute = "topical"
+	RouteTransdermal DosageRoute = "transdermal"
+	RouteInhaled     DosageRoute = "inhaled"
+	RouteNasal       DosageRoute = "nasal"
+	RouteOphthalmic  DosageRoute = "ophthalmic"
+	RouteOtic        DosageRoute = "otic"
+	RouteRectal      DosageRoute = "rectal"
+	RouteIV          DosageRoute = "iv"
+	RouteIM          DosageRoute = "im"
+	RouteSC          DosageRoute = "sc"
+)
+
+// DosageFrequency is a standard dosing schedule abbreviation
+type DosageFrequency string
+
+const (
+	FrequencyOnceDaily       DosageFrequency = "QD"
+	FrequencyTwiceDaily      DosageFrequency = "BID"
+	FrequencyThreeTimesDaily DosageFrequency = "TID"
+	FrequencyFourTimesDaily  DosageFrequency = "QID"
+	FrequencyEvery4Hours     DosageFrequency = "Q4H"
+	FrequencyEvery6Hours     DosageFrequency = "Q6H"
+	FrequencyEvery8Hours     DosageFrequency = "Q8H"
+	FrequencyEvery12Hours    DosageFrequency = "Q12H"
+	FrequencyAtBedtime       DosageFrequency = "QHS"
+	FrequencyWeekly          DosageFrequency = "QW"
+	FrequencyAsNeeded        DosageFrequency = "PRN"
+)
+
+// PrescriptionDispense records one hand-over of medication by a pharmacist
+type PrescriptionDispense struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	PrescriptionID uint      `json:"prescription_id"`
+	PharmacistID   uint      `json:"pharmacist_id"`
+	Pharmacist     User      `gorm:"foreignKey:PharmacistID" json:"pharmacist"`
+	Quantity       int       `json:"quantity"`
+	Notes          string    `json:"notes"`
+	DispensedAt    time.Time `json:"dispensed_at"`
+}
+
+// DoctorSchedule describes the working hours of a doctor for one day of the week.
+// Times are "HH:MM" strings in the clinic timezone.
+type DoctorSchedule struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	DoctorID    uint            `json:"doctor_id"`
+	Weekday     time.Weekday    `json:"weekday"` // 0 = Sunday
+	StartTime   string          `json:"start_time"`
+	EndTime     string          `json:"end_time"`
+	SlotMinutes int             `json:"slot_minutes"`
+	Breaks      []ScheduleBreak `gorm:"foreignKey:ScheduleID" json:"breaks"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type ScheduleBreak struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	ScheduleID uint   `json:"schedule_id"`
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+}
+
+// TimeSlot is a bookable interval returned by the availability endpoint
+type TimeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Absence blocks out a time range for a single doctor, a whole department or the entire clinic
+type Absence struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	Scope        AbsenceScope   `json:"scope"` // doctor, department, clinic
+	DoctorID     *uint          `json:"doctor_id,omitempty"`
+	DepartmentID *uint          `json:"department_id,omitempty"`
+	StartDate    time.Time      `json:"start_date"`
+	EndDate      time.Time      `json:"end_date"`
+	Reason       string         `json:"reason"`
+	CreatedByID  uint           `json:"created_by_id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// SOAPNote is the structured clinical note of a consultation.
+// Fields holds the values of the template-defined fields, keyed by TemplateField.Key.
+type SOAPNote struct {
+	Subjective string     `json:"subjective"`
+	Objective  string     `json:"objective"`
+	Assessment string     `json:"assessment"`
+	Plan       string     `json:"plan"`
+	Fields     NoteFields `gorm:"column:note_fields;type:jsonb" json:"fields"`
+}
+
+type NoteSection string
+
+const (
+	SectionSubjective NoteSection = "subjective"
+	SectionObjective  NoteSection = "objective"
+	SectionAssessment NoteSection = "assessment"
+	SectionPlan       NoteSection = "plan"
+)
+
+type TemplateFieldType string
+
+const (
+	FieldText   TemplateFieldType = "text"
+	FieldNumber TemplateFieldType = "number"
+	FieldChoice TemplateFieldType = "choice"
+)
+
+// TemplateField is a structured entry a note template asks for within one SOAP section
+type TemplateField struct {
+	Section  NoteSection       `json:"section"`
+	Key      string            `json:"key"`
+	Label    string            `json:"label"`
+	Type     TemplateFieldType `json:"type"` // text, number, choice
+	Options  []string          `json:"options,omitempty"`
+	Required bool              `json:"required"`
+}
+
+// NoteTemplate is a reusable SOAP note layout, scoped to a department or global when DepartmentID is nil
+type NoteTemplate struct {
+	ID               uint           `gorm:"primaryKey" json:"id"`
+	DepartmentID     *uint          `json:"department_id"`
+	Name             string         `json:"name"`
+	Description      string         `json:"description"`
+	RequiredSections NoteSections   `gorm:"type:jsonb" json:"required_sections"`
+	Fields           TemplateFields `gorm:"type:jsonb" json:"fields"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// NoteFields, NoteSections, TemplateFields and StringList are stored as JSONB columns
+
+type NoteFields map[string]string
+
+func (f NoteFields) Value() (driver.Value, error) { return jsonValue(f) }
+func (f *NoteFields) Scan(src interface{}) error  { return jsonScan(src, f) }
+
+type NoteSections []NoteSection
+
+func (s NoteSections) Value() (driver.Value, error) { return jsonValue(s) }
+func (s *NoteSections) Scan(src interface{}) error  { return jsonScan(src, s) }
+
+type TemplateFields []TemplateField
+
+func (f TemplateFields) Value() (driver.Value, error) { return jsonValue(f) }
+func (f *TemplateFields) Scan(src interface{}) error  { return jsonScan(src, f) }
+
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	return jsonValue(l)
+}
+
+func (l *StringList) Scan(src interface{}) error { return jsonScan(src, l) }
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func jsonScan(src interface{}, dest interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	default:
+		return errors.New("unsupported type for JSON column")
+	}
 }
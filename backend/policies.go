@@ -0,0 +1,197 @@
+package main
+
+import (
+	"log"
+	"slices"
+
+	"github.com/casbin/casbin/v3"
+	"github.com/cristim67/med-monitor/backend/models"
+	"gorm.io/gorm"
+)
+
+// policySeed is a batch of default Casbin policies, applied once per database
+type policySeed struct {
+	version  int
+	policies [][]string
+}
+
+// policySeeds are the default permissions in the order they were introduced. Each version is applied
+// once and recorded in policy_seeds, so a policy an admin removed stays removed across restarts.
+// New defaults go into a new version; never edit a version that has shipped.
+var policySeeds = []policySeed{
+	{version: 1, policies: [][]string{
+		{string(models.RoleAdmin), "/api/v1/*", ".*"},
+
+		{string(models.RoleDoctor), "/api/v1/profile", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/patients", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/patients/:id/history", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/appointments", "(GET)|(POST)"},
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/complete", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/cancel", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/prescriptions", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/prescriptions/:id", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/doctors/:id/availability", "(GET)"},
+
+		{string(models.RolePatient), "/api/v1/profile", "(GET)"},
+		{string(models.RolePatient), "/api/v1/appointments", "(GET)|(POST)"},
+		{string(models.RolePatient), "/api/v1/appointments/:id/cancel", "(PUT)"},
+		{string(models.RolePatient), "/api/v1/prescriptions", "(GET)"},
+		{string(models.RolePatient), "/api/v1/doctors", "(GET)"},
+		{string(models.RolePatient), "/api/v1/doctors/:id/availability", "(GET)"},
+		{string(models.RolePatient), "/api/v1/departments", "(GET)"},
+	}},
+	{version: 2, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/doctors/:id/schedule", "(GET)|(PUT)"},
+
+		{string(models.RolePatient), "/api/v1/doctors/:id/schedule", "(GET)"},
+	}},
+	{version: 3, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/absences", "(GET)|(POST)"},
+		{string(models.RoleDoctor), "/api/v1/absences/:id", "(DELETE)"},
+	}},
+	{version: 4, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/reschedule", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/history", "(GET)"},
+
+		{string(models.RolePatient), "/api/v1/appointments/:id/reschedule", "(PUT)"},
+		{string(models.RolePatient), "/api/v1/appointments/:id/history", "(GET)"},
+	}},
+	{version: 5, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/status", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/events", "(GET)"},
+
+		{string(models.RolePatient), "/api/v1/appointments/:id/events", "(GET)"},
+	}},
+	{version: 6, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/patients/:id/no-shows", "(GET)"},
+	}},
+	{version: 7, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/appointments/:id/consultation", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/consultations/:id", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/consultations/:id/amendments", "(POST)"},
+
+		{string(models.RolePatient), "/api/v1/appointments/:id/consultation", "(GET)"},
+		{string(models.RolePatient), "/api/v1/consultations/:id", "(GET)"},
+	}},
+	{version: 8, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/note-templates", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/note-templates/:id", "(GET)"},
+	}},
+	{version: 9, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/diagnosis-codes", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/diagnosis-codes/:code", "(GET)"},
+	}},
+	{version: 10, policies: [][]string{
+		{string(models.RolePharmacist), "/api/v1/profile", "(GET)"},
+		{string(models.RolePharmacist), "/api/v1/pharmacy/prescriptions/:code", "(GET)"},
+		{string(models.RolePharmacist), "/api/v1/pharmacy/prescriptions/:code/dispenses", "(POST)"},
+	}},
+	{version: 11, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/prescriptions/:id/qr", "(GET)"},
+
+		{string(models.RolePatient), "/api/v1/prescriptions/:id/qr", "(GET)"},
+
+		{string(models.RolePharmacist), "/api/v1/pharmacy/verify", "(POST)"},
+	}},
+	{version: 12, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/prescription-renewals", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/prescription-renewals/:id/approve", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/prescription-renewals/:id/deny", "(PUT)"},
+
+		{string(models.RolePatient), "/api/v1/prescriptions/:id/renewals", "(POST)"},
+		{string(models.RolePatient), "/api/v1/prescription-renewals", "(GET)"},
+	}},
+	{version: 13, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/drugs", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/drugs/:code", "(GET)"},
+
+		{string(models.RolePharmacist), "/api/v1/drugs", "(GET)"},
+		{string(models.RolePharmacist), "/api/v1/drugs/:code", "(GET)"},
+	}},
+	{version: 14, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/patients/:id/allergies", "(GET)|(POST)"},
+		{string(models.RoleDoctor), "/api/v1/patients/:id/allergies/:allergy_id", "(PUT)|(DELETE)"},
+	}},
+	{version: 15, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/patients/:id/observations", "(GET)|(POST)"},
+
+		{string(models.RolePatient), "/api/v1/patients/:id/observations", "(GET)|(POST)"},
+	}},
+	{version: 16, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/patients/:id/devices", "(GET)|(POST)"},
+		{string(models.RoleDoctor), "/api/v1/patients/:id/devices/:device_id", "(DELETE)"},
+		{string(models.RoleDoctor), "/api/v1/patients/:id/devices/:device_id/credential", "(PUT)"},
+
+		{string(models.RolePatient), "/api/v1/patients/:id/devices", "(GET)|(POST)"},
+		{string(models.RolePatient), "/api/v1/patients/:id/devices/:device_id", "(DELETE)"},
+		{string(models.RolePatient), "/api/v1/patients/:id/devices/:device_id/credential", "(PUT)"},
+	}},
+	{version: 17, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/alert-rules", "(GET)|(POST)"},
+		{string(models.RoleDoctor), "/api/v1/alert-rules/:id", "(PUT)|(DELETE)"},
+		{string(models.RoleDoctor), "/api/v1/alerts", "(GET)"},
+		{string(models.RoleDoctor), "/api/v1/alerts/:id/acknowledge", "(PUT)"},
+		{string(models.RoleDoctor), "/api/v1/alerts/:id/resolve", "(PUT)"},
+	}},
+	{version: 18, policies: [][]string{
+		{string(models.RoleDoctor), "/api/v1/auth/logout", "(POST)"},
+		{string(models.RoleDoctor), "/api/v1/auth/logout-all", "(POST)"},
+
+		{string(models.RolePatient), "/api/v1/auth/logout", "(POST)"},
+		{string(models.RolePatient), "/api/v1/auth/logout-all", "(POST)"},
+
+		{string(models.RolePharmacist), "/api/v1/auth/logout", "(POST)"},
+		{string(models.RolePharmacist), "/api/v1/auth/logout-all", "(POST)"},
+	}},
+	// The routes each API key scope opens to service accounts
+	{version: 19, policies: [][]string{
+		{models.ScopePatientsRead.Subject(), "/api/v1/patients", "(GET)"},
+		{models.ScopePatientsRead.Subject(), "/api/v1/patients/:id/allergies", "(GET)"},
+
+		{models.ScopeObservationsRead.Subject(), "/api/v1/patients/:id/observations", "(GET)"},
+		{models.ScopeObservationsWrite.Subject(), "/api/v1/patients/:id/observations", "(POST)"},
+
+		{models.ScopePharmacy.Subject(), "/api/v1/pharmacy/prescriptions/:code", "(GET)"},
+		{models.ScopePharmacy.Subject(), "/api/v1/pharmacy/prescriptions/:code/dispenses", "(POST)"},
+		{models.ScopePharmacy.Subject(), "/api/v1/pharmacy/verify", "(POST)"},
+
+		{models.ScopeCatalogRead.Subject(), "/api/v1/diagnosis-codes", "(GET)"},
+		{models.ScopeCatalogRead.Subject(), "/api/v1/diagnosis-codes/:code", "(GET)"},
+		{models.ScopeCatalogRead.Subject(), "/api/v1/drugs", "(GET)"},
+		{models.ScopeCatalogRead.Subject(), "/api/v1/drugs/:code", "(GET)"},
+	}},
+}
+
+// seedPolicies applies the policy seeds the database has not received yet
+func seedPolicies(enforcer *casbin.Enforcer, gdb *gorm.DB) {
+	var applied []int
+	if err := gdb.Table("policy_seeds").Pluck("version", &applied).Error; err != nil {
+		log.Fatalf("Failed to read applied Casbin policy seeds: %v", err)
+	}
+	// Databases seeded before policy seeds were versioned already hold version 1
+	if len(applied) == 0 {
+		if seeded, _ := enforcer.HasPolicy(string(models.RoleDoctor), "/api/v1/profile", "(GET)"); seeded {
+			recordPolicySeed(gdb, 1)
+			applied = append(applied, 1)
+		}
+	}
+
+	for _, seed := range policySeeds {
+		if slices.Contains(applied, seed.version) {
+			continue
+		}
+		for _, p := range seed.policies {
+			if _, err := enforcer.AddPolicy(p[0], p[1], p[2]); err != nil {
+				log.Fatalf("Failed to seed Casbin policy %v: %v", p, err)
+			}
+		}
+		recordPolicySeed(gdb, seed.version)
+		log.Printf("Seeded Casbin policies of version %d", seed.version)
+	}
+}
+
+func recordPolicySeed(gdb *gorm.DB, version int) {
+	if err := gdb.Exec("INSERT INTO policy_seeds (version) VALUES (?) ON CONFLICT DO NOTHING", version).Error; err != nil {
+		log.Fatalf("Failed to record Casbin policy seed %d: %v", version, err)
+	}
+}
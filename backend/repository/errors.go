@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrConflict is returned when a write is rejected by a uniqueness or exclusion constraint.
+var ErrConflict = errors.New("record conflicts with existing data")
+
+// Postgres SQLSTATE codes for constraint violations we translate into ErrConflict
+const (
+	pgUniqueViolation    = "23505"
+	pgExclusionViolation = "23P01"
+)
+
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == pgUniqueViolation || pgErr.Code == pgExclusionViolation) {
+		return ErrConflict
+	}
+	return err
+}
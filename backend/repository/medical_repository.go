@@ -1,8 +1,12 @@
 package repository
 
 import (
+	"strings"
+	"time"
+
 	"github.com/cristim67/med-monitor/backend/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MedicalRepository interface {
@@ -19,6 +23,18 @@ type MedicalRepository interface {
 	CreateDoctor(doctor *models.Doctor) error
 	UpdateDoctor(doctor *models.Doctor) error
 
+	// Doctor schedules
+	GetDoctorSchedules(doctorID uint) ([]models.DoctorSchedule, error)
+	ReplaceDoctorSchedules(doctorID uint, schedules []models.DoctorSchedule) error
+
+	// Absences & closures
+	CreateAbsence(absence *models.Absence) error
+	GetAbsenceByID(id uint) (*models.Absence, error)
+	GetAbsencesBetween(start, end time.Time) ([]models.Absence, error)
+	GetDoctorAbsencesBetween(doctorID, departmentID uint, start, end time.Time) ([]models.Absence, error)
+	DeleteAbsence(id uint) error
+	FlagAppointmentsInAbsence(absence *models.Absence) (int64, error)
+
 	// Patients
 	GetAllPatients() ([]models.Patient, error)
 	GetPatientByID(id uint) (*models.Patient, error)
@@ -32,15 +48,98 @@ type MedicalRepository interface {
 	UpdateAppointment(appt *models.Appointment) error
 	GetAllAppointments() ([]models.Appointment, error)
 	DeleteAppointment(id uint) error
+	FindOverlappingAppointments(doctorID, patientID uint, start, end time.Time, excludeID uint) ([]models.Appointment, error)
+	RescheduleAppointment(appt *models.Appointment, history *models.AppointmentHistory) error
+	GetAppointmentHistory(apptID uint) ([]models.AppointmentHistory, error)
+	UpdateAppointmentStatus(appt *models.Appointment, event *models.AppointmentStatusEvent) error
+	GetAppointmentStatusEvents(apptID uint) ([]models.AppointmentStatusEvent, error)
+	GetOverdueScheduledAppointments(cutoff time.Time) ([]models.Appointment, error)
+	CountNoShowsByPatient(patientID uint, since time.Time) (int64, error)
+	GetActiveAppointmentsByDoctorBetween(doctorID uint, start, end time.Time) ([]models.Appointment, error)
+
+	// Clinical note templates
+	GetNoteTemplates(departmentID *uint) ([]models.NoteTemplate, error)
+	GetNoteTemplateByID(id uint) (*models.NoteTemplate, error)
+	CreateNoteTemplate(tmpl *models.NoteTemplate) error
+	UpdateNoteTemplate(tmpl *models.NoteTemplate) error
+	DeleteNoteTemplate(id uint) error
+
+	// Diagnosis code catalog
+	UpsertDiagnosisCodes(codes []models.DiagnosisCode) error
+	SearchDiagnosisCodes(codePrefix, text string, limit int) ([]models.DiagnosisCode, error)
+	GetDiagnosisCode(code string) (*models.DiagnosisCode, error)
+	GetDiagnosisCodesByCodes(codes []string) ([]models.DiagnosisCode, error)
+	CreateConsultationDiagnoses(diagnoses []models.ConsultationDiagnosis) error
+
+	// Patient allergies
+	GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error)
+	GetPatientAllergy(id uint) (*models.PatientAllergy, error)
+	CreatePatientAllergy(allergy *models.PatientAllergy) error
+	UpdatePatientAllergy(allergy *models.PatientAllergy) error
+	DeletePatientAllergy(id uint) error
+
+	// Observations
+	CreateObservations(obs []models.Observation) error
+	GetObservations(patientID uint, obsType models.ObservationType, from, to time.Time, limit int) ([]models.Observation, error)
+
+	// Monitoring devices
+	CreateDevice(device *models.Device) error
+	GetDeviceByID(id uint) (*models.Device, error)
+	GetDevicesByPatient(patientID uint) ([]models.Device, error)
+	UpdateDevice(device *models.Device) error
+	TouchDevice(id uint, seenAt time.Time) error
+	CreateDeviceBatch(batch *models.DeviceBatch) error
+	GetDeviceBatch(deviceID uint, batchID string) (*models.DeviceBatch, error)
+	GetDeviceReadings(deviceID uint, from, to time.Time) ([]models.Observation, error)
+
+	// Alert rules & alerts
+	GetAlertRules(patientID *uint) ([]models.AlertRule, error)
+	GetAlertRule(id uint) (*models.AlertRule, error)
+	CreateAlertRule(rule *models.AlertRule) error
+	UpdateAlertRule(rule *models.AlertRule) error
+	DeleteAlertRule(id uint) error
+	GetActiveAlertRulesForPatient(patientID uint) ([]models.AlertRule, error)
+	GetLatestAppointment(patientID uint, statuses []models.AppointmentStatus) (*models.Appointment, error)
+	CreateAlert(alert *models.Alert) (bool, error)
+	GetAlert(id uint) (*models.Alert, error)
+	GetAlerts(filter AlertFilter) ([]models.Alert, error)
+	UpdateAlertStatus(alert *models.Alert, from models.AlertStatus) error
+
+	// Drug catalog
+	UpsertDrugs(drugs []models.Drug) error
+	UpsertDrugInteractions(interactions []models.DrugInteraction) error
+	SearchDrugs(text string, limit int) ([]models.Drug, error)
+	GetDrug(code string) (*models.Drug, error)
+	GetDrugsByCodes(codes []string) ([]models.Drug, error)
+	GetDrugsByNames(names []string) ([]models.Drug, error)
+	GetInteractionsAmong(ingredients []string) ([]models.DrugInteraction, error)
+	GetActivePrescriptionsByPatient(patientID uint, now time.Time) ([]models.Prescription, error)
 
 	// Consultations & Prescriptions
 	CreateConsultation(cons *models.Consultation) error
 	GetConsultationByAppointment(apptID uint) (*models.Consultation, error)
+	GetConsultationByID(id uint) (*models.Consultation, error)
+	GetConsultationAmendments(consID uint) ([]models.ConsultationAmendment, error)
+	CreateConsultationAmendment(amendment *models.ConsultationAmendment) error
 	CreatePrescription(presc *models.Prescription) error
 	GetPrescriptionsByConsultation(consID uint) ([]models.Prescription, error)
 	GetPrescriptionsByPatient(patientID uint) ([]models.Prescription, error)
 	GetPrescriptionsByDoctor(doctorID uint) ([]models.Prescription, error)
 	UpdatePrescription(presc *models.Prescription) error
+	GetPrescriptionByID(id uint) (*models.Prescription, error)
+	UpdatePrescriptionStatus(id uint, from, to models.PrescriptionStatus) error
+	ExpirePrescriptions(now time.Time) (int64, error)
+	GetPrescriptionByVerificationCode(code string) (*models.Prescription, error)
+	LockPrescriptionByVerificationCode(code string) (*models.Prescription, error)
+	CreatePrescriptionDispense(dispense *models.PrescriptionDispense) error
+
+	// Prescription renewals
+	CreatePrescriptionRenewal(renewal *models.PrescriptionRenewal) error
+	GetPrescriptionRenewal(id uint) (*models.PrescriptionRenewal, error)
+	GetPrescriptionRenewals(filter RenewalFilter) ([]models.PrescriptionRenewal, error)
+	DecidePrescriptionRenewal(renewal *models.PrescriptionRenewal) error
+	IsPrescriptionRenewed(prescID uint) (bool, error)
+	HasPendingRenewal(prescID uint) (bool, error)
 }
 
 type medicalRepository struct {
@@ -98,6 +197,76 @@ func (r *medicalRepository) UpdateDoctor(doctor *models.Doctor) error {
 	}).Error
 }
 
+func (r *medicalRepository) GetDoctorSchedules(doctorID uint) ([]models.DoctorSchedule, error) {
+	var schedules []models.DoctorSchedule
+	err := r.db.Preload("Breaks").Where("doctor_id = ?", doctorID).Order("weekday asc").Find(&schedules).Error
+	return schedules, err
+}
+
+// ReplaceDoctorSchedules swaps the whole weekly schedule of a doctor in a single transaction
+func (r *medicalRepository) ReplaceDoctorSchedules(doctorID uint, schedules []models.DoctorSchedule) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("doctor_id = ?", doctorID).Delete(&models.DoctorSchedule{}).Error; err != nil {
+			return err
+		}
+		for i := range schedules {
+			schedules[i].DoctorID = doctorID
+			if err := tx.Create(&schedules[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *medicalRepository) CreateAbsence(absence *models.Absence) error {
+	return r.db.Create(absence).Error
+}
+
+func (r *medicalRepository) GetAbsenceByID(id uint) (*models.Absence, error) {
+	var absence models.Absence
+	err := r.db.First(&absence, id).Error
+	return &absence, err
+}
+
+func (r *medicalRepository) GetAbsencesBetween(start, end time.Time) ([]models.Absence, error) {
+	var absences []models.Absence
+	err := r.db.Where("start_date < ? AND end_date > ?", end, start).Order("start_date asc").Find(&absences).Error
+	return absences, err
+}
+
+// GetDoctorAbsencesBetween returns the absences affecting a doctor: their own, their department's and clinic closures
+func (r *medicalRepository) GetDoctorAbsencesBetween(doctorID, departmentID uint, start, end time.Time) ([]models.Absence, error) {
+	var absences []models.Absence
+	err := r.db.Where("start_date < ? AND end_date > ?", end, start).
+		Where("scope = ? OR (scope = ? AND doctor_id = ?) OR (scope = ? AND department_id = ?)",
+			models.AbsenceClinic, models.AbsenceDoctor, doctorID, models.AbsenceDepartment, departmentID).
+		Order("start_date asc").
+		Find(&absences).Error
+	return absences, err
+}
+
+func (r *medicalRepository) DeleteAbsence(id uint) error {
+	return r.db.Delete(&models.Absence{}, id).Error
+}
+
+// FlagAppointmentsInAbsence marks the scheduled appointments falling inside the absence as needing a reschedule
+func (r *medicalRepository) FlagAppointmentsInAbsence(absence *models.Absence) (int64, error) {
+	query := r.db.Model(&models.Appointment{}).
+		Where("status = ?", models.StatusScheduled).
+		Where("appointment_date < ? AND end_date > ?", absence.EndDate, absence.StartDate)
+
+	switch absence.Scope {
+	case models.AbsenceDoctor:
+		query = query.Where("doctor_id = ?", absence.DoctorID)
+	case models.AbsenceDepartment:
+		query = query.Where("doctor_id IN (?)", r.db.Model(&models.Doctor{}).Select("id").Where("department_id = ?", absence.DepartmentID))
+	}
+
+	result := query.Update("needs_reschedule", true)
+	return result.RowsAffected, result.Error
+}
+
 func (r *medicalRepository) GetAllPatients() ([]models.Patient, error) {
 	var patients []models.Patient
 	err := r.db.Preload("User").Joins("JOIN users ON users.id = patients.id").Where("users.role = ?", "patient").Find(&patients).Error
@@ -115,7 +284,7 @@ func (r *medicalRepository) UpdatePatient(patient *models.Patient) error {
 }
 
 func (r *medicalRepository) CreateAppointment(appt *models.Appointment) error {
-	return r.db.Create(appt).Error
+	return translateError(r.db.Create(appt).Error)
 }
 
 func (r *medicalRepository) GetAppointmentsByPatient(patientID uint) ([]models.Appointment, error) {
@@ -137,7 +306,7 @@ func (r *medicalRepository) GetAppointmentByID(id uint) (*models.Appointment, er
 }
 
 func (r *medicalRepository) UpdateAppointment(appt *models.Appointment) error {
-	return r.db.Save(appt).Error
+	return translateError(r.db.Save(appt).Error)
 }
 
 func (r *medicalRepository) GetAllAppointments() ([]models.Appointment, error) {
@@ -150,18 +319,499 @@ func (r *medicalRepository) DeleteAppointment(id uint) error {
 	return r.db.Delete(&models.Appointment{}, id).Error
 }
 
+// FindOverlappingAppointments returns active appointments of the doctor or the patient intersecting [start, end),
+// ignoring the appointment with excludeID (0 to keep all)
+func (r *medicalRepository) FindOverlappingAppointments(doctorID, patientID uint, start, end time.Time, excludeID uint) ([]models.Appointment, error) {
+	var appts []models.Appointment
+	err := r.db.Where("status IN ? AND id <> ?", models.ActiveAppointmentStatuses, excludeID).
+		Where("doctor_id = ? OR patient_id = ?", doctorID, patientID).
+		Where("appointment_date < ? AND end_date > ?", end, start).
+		Order("appointment_date asc").
+		Find(&appts).Error
+	return appts, err
+}
+
+// RescheduleAppointment saves the moved appointment together with its history entry
+func (r *medicalRepository) RescheduleAppointment(appt *models.Appointment, history *models.AppointmentHistory) error {
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(appt).Error; err != nil {
+			return err
+		}
+		return tx.Create(history).Error
+	}))
+}
+
+func (r *medicalRepository) GetAppointmentHistory(apptID uint) ([]models.AppointmentHistory, error) {
+	var history []models.AppointmentHistory
+	err := r.db.Where("appointment_id = ?", apptID).Order("created_at asc").Find(&history).Error
+	return history, err
+}
+
+// UpdateAppointmentStatus moves appt from event.FromStatus to event.ToStatus and stores the event.
+// It returns ErrConflict when the stored status no longer matches event.FromStatus.
+func (r *medicalRepository) UpdateAppointmentStatus(appt *models.Appointment, event *models.AppointmentStatusEvent) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Appointment{}).
+			Where("id = ? AND status = ?", appt.ID, event.FromStatus).
+			Update("status", event.ToStatus)
+		if result.Error != nil {
+			return translateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrConflict
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		appt.Status = event.ToStatus
+		return nil
+	})
+}
+
+func (r *medicalRepository) GetAppointmentStatusEvents(apptID uint) ([]models.AppointmentStatusEvent, error) {
+	var events []models.AppointmentStatusEvent
+	err := r.db.Where("appointment_id = ?", apptID).Order("created_at asc, id asc").Find(&events).Error
+	return events, err
+}
+
+// GetOverdueScheduledAppointments returns appointments still Scheduled although they started before cutoff
+func (r *medicalRepository) GetOverdueScheduledAppointments(cutoff time.Time) ([]models.Appointment, error) {
+	var appts []models.Appointment
+	err := r.db.Where("status = ? AND appointment_date < ?", models.StatusScheduled, cutoff).
+		Order("appointment_date asc").
+		Find(&appts).Error
+	return appts, err
+}
+
+func (r *medicalRepository) CountNoShowsByPatient(patientID uint, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Appointment{}).
+		Where("patient_id = ? AND status = ? AND appointment_date >= ?", patientID, models.StatusNoShow, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *medicalRepository) GetActiveAppointmentsByDoctorBetween(doctorID uint, start, end time.Time) ([]models.Appointment, error) {
+	var appts []models.Appointment
+	err := r.db.Where("doctor_id = ? AND status IN ?", doctorID, models.ActiveAppointmentStatuses).
+		Where("appointment_date < ? AND end_date > ?", end, start).
+		Order("appointment_date asc").
+		Find(&appts).Error
+	return appts, err
+}
+
+// GetNoteTemplates returns the global templates plus, when departmentID is set, the ones of that department
+func (r *medicalRepository) GetNoteTemplates(departmentID *uint) ([]models.NoteTemplate, error) {
+	var templates []models.NoteTemplate
+	query := r.db.Order("name asc")
+	if departmentID != nil {
+		query = query.Where("department_id IS NULL OR department_id = ?", *departmentID)
+	}
+	err := query.Find(&templates).Error
+	return templates, err
+}
+
+func (r *medicalRepository) GetNoteTemplateByID(id uint) (*models.NoteTemplate, error) {
+	var tmpl models.NoteTemplate
+	err := r.db.First(&tmpl, id).Error
+	return &tmpl, err
+}
+
+func (r *medicalRepository) CreateNoteTemplate(tmpl *models.NoteTemplate) error {
+	return r.db.Create(tmpl).Error
+}
+
+func (r *medicalRepository) UpdateNoteTemplate(tmpl *models.NoteTemplate) error {
+	return r.db.Save(tmpl).Error
+}
+
+func (r *medicalRepository) DeleteNoteTemplate(id uint) error {
+	return r.db.Delete(&models.NoteTemplate{}, id).Error
+}
+
+// UpsertDiagnosisCodes inserts the codes in batches, refreshing the description of codes already present
+func (r *medicalRepository) UpsertDiagnosisCodes(codes []models.DiagnosisCode) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+	}).CreateInBatches(codes, 1000).Error
+}
+
+// likeEscaper escapes the LIKE wildcards of user input, backslash being the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchDiagnosisCodes matches codes by prefix and descriptions by substring or trigram similarity,
+// ranking code prefix matches first and then by similarity
+func (r *medicalRepository) SearchDiagnosisCodes(codePrefix, text string, limit int) ([]models.DiagnosisCode, error) {
+	var codes []models.DiagnosisCode
+	prefix := likeEscaper.Replace(codePrefix) + "%"
+	err := r.db.
+		Where("code LIKE ? OR description ILIKE ? OR description % ?", prefix, "%"+likeEscaper.Replace(text)+"%", text).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "(code LIKE ?) DESC, similarity(description, ?) DESC, code ASC", Vars: []interface{}{prefix, text}}}).
+		Limit(limit).
+		Find(&codes).Error
+	return codes, err
+}
+
+func (r *medicalRepository) GetDiagnosisCode(code string) (*models.DiagnosisCode, error) {
+	var dc models.DiagnosisCode
+	err := r.db.Where("code = ?", code).First(&dc).Error
+	return &dc, err
+}
+
+func (r *medicalRepository) GetDiagnosisCodesByCodes(codes []string) ([]models.DiagnosisCode, error) {
+	var found []models.DiagnosisCode
+	err := r.db.Where("code IN ?", codes).Find(&found).Error
+	return found, err
+}
+
+func (r *medicalRepository) CreateConsultationDiagnoses(diagnoses []models.ConsultationDiagnosis) error {
+	if len(diagnoses) == 0 {
+		return nil
+	}
+	return translateError(r.db.Omit(clause.Associations).Create(&diagnoses).Error)
+}
+
+func (r *medicalRepository) GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error) {
+	var allergies []models.PatientAllergy
+	err := r.db.Preload("VerifiedBy").Where("patient_id = ?", patientID).Order("substance asc").Find(&allergies).Error
+	return allergies, err
+}
+
+func (r *medicalRepository) GetPatientAllergy(id uint) (*models.PatientAllergy, error) {
+	var allergy models.PatientAllergy
+	err := r.db.Preload("VerifiedBy").First(&allergy, id).Error
+	return &allergy, err
+}
+
+func (r *medicalRepository) CreatePatientAllergy(allergy *models.PatientAllergy) error {
+	return translateError(r.db.Omit(clause.Associations).Create(allergy).Error)
+}
+
+func (r *medicalRepository) UpdatePatientAllergy(allergy *models.PatientAllergy) error {
+	return translateError(r.db.Omit(clause.Associations).Save(allergy).Error)
+}
+
+func (r *medicalRepository) DeletePatientAllergy(id uint) error {
+	return r.db.Delete(&models.PatientAllergy{}, id).Error
+}
+
+// CreateObservations returns ErrConflict when a device reading was already stored
+func (r *medicalRepository) CreateObservations(obs []models.Observation) error {
+	if len(obs) == 0 {
+		return nil
+	}
+	return translateError(r.db.Create(&obs).Error)
+}
+
+// GetObservations returns a patient's readings observed in [from, to), newest first.
+// Zero times leave that side of the range open, an empty type and a zero limit are ignored.
+func (r *medicalRepository) GetObservations(patientID uint, obsType models.ObservationType, from, to time.Time, limit int) ([]models.Observation, error) {
+	query := r.db.Where("patient_id = ?", patientID)
+	if obsType != "" {
+		query = query.Where("type = ?", obsType)
+	}
+	if !from.IsZero() {
+		query = query.Where("observed_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("observed_at < ?", to)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var obs []models.Observation
+	err := query.Order("observed_at desc").Find(&obs).Error
+	return obs, err
+}
+
+func (r *medicalRepository) CreateDevice(device *models.Device) error {
+	return r.db.Create(device).Error
+}
+
+func (r *medicalRepository) GetDeviceByID(id uint) (*models.Device, error) {
+	var device models.Device
+	err := r.db.First(&device, id).Error
+	return &device, err
+}
+
+func (r *medicalRepository) GetDevicesByPatient(patientID uint) ([]models.Device, error) {
+	var devices []models.Device
+	err := r.db.Where("patient_id = ?", patientID).Order("created_at asc").Find(&devices).Error
+	return devices, err
+}
+
+// UpdateDevice writes the credential and revocation of a device, last_seen_at belongs to TouchDevice
+func (r *medicalRepository) UpdateDevice(device *models.Device) error {
+	return r.db.Model(device).Updates(map[string]interface{}{
+		"secret_hash": device.SecretHash,
+		"revoked_at":  device.RevokedAt,
+	}).Error
+}
+
+// TouchDevice records when a device last delivered telemetry
+func (r *medicalRepository) TouchDevice(id uint, seenAt time.Time) error {
+	return r.db.Model(&models.Device{}).Where("id = ?", id).Update("last_seen_at", seenAt).Error
+}
+
+// CreateDeviceBatch returns ErrConflict when the device already delivered a batch with this id
+func (r *medicalRepository) CreateDeviceBatch(batch *models.DeviceBatch) error {
+	return translateError(r.db.Create(batch).Error)
+}
+
+func (r *medicalRepository) GetDeviceBatch(deviceID uint, batchID string) (*models.DeviceBatch, error) {
+	var batch models.DeviceBatch
+	err := r.db.Where("device_id = ? AND batch_id = ?", deviceID, batchID).First(&batch).Error
+	return &batch, err
+}
+
+// GetDeviceReadings returns the type and time of the readings a device reported between from and to, inclusive
+func (r *medicalRepository) GetDeviceReadings(deviceID uint, from, to time.Time) ([]models.Observation, error) {
+	var obs []models.Observation
+	err := r.db.Select("type", "observed_at").
+		Where("device_id = ? AND observed_at BETWEEN ? AND ?", deviceID, from, to).
+		Find(&obs).Error
+	return obs, err
+}
+
+// GetAlertRules returns every rule, or when patientID is set the rules that target that patient
+func (r *medicalRepository) GetAlertRules(patientID *uint) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	query := r.db.Order("name asc")
+	if patientID != nil {
+		query = query.Where("patient_id = ?", *patientID)
+	}
+	err := query.Find(&rules).Error
+	return rules, err
+}
+
+func (r *medicalRepository) GetAlertRule(id uint) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := r.db.First(&rule, id).Error
+	return &rule, err
+}
+
+func (r *medicalRepository) CreateAlertRule(rule *models.AlertRule) error {
+	return r.db.Create(rule).Error
+}
+
+func (r *medicalRepository) UpdateAlertRule(rule *models.AlertRule) error {
+	return r.db.Save(rule).Error
+}
+
+func (r *medicalRepository) DeleteAlertRule(id uint) error {
+	return r.db.Delete(&models.AlertRule{}, id).Error
+}
+
+// GetActiveAlertRulesForPatient returns the active rules that apply to a patient: the ones targeting the patient,
+// the ones whose condition code prefixes a diagnosis in the current version of one of the patient's consultations
+// and the ones that apply to everyone
+func (r *medicalRepository) GetActiveAlertRulesForPatient(patientID uint) ([]models.AlertRule, error) {
+	latestAmendment := r.db.Table("consultation_amendments").
+		Select("id").
+		Where("consultation_amendments.consultation_id = consultations.id").
+		Order("version desc").
+		Limit(1)
+	diagnosed := r.db.Table("consultation_diagnoses").
+		Select("1").
+		Joins("JOIN consultations ON consultations.id = consultation_diagnoses.consultation_id AND consultations.deleted_at IS NULL").
+		Joins("JOIN appointments ON appointments.id = consultations.appointment_id").
+		Where("appointments.patient_id = ?", patientID).
+		Where("consultation_diagnoses.amendment_id IS NOT DISTINCT FROM (?)", latestAmendment).
+		Where("consultation_diagnoses.code LIKE alert_rules.condition_code || '%'")
+
+	var rules []models.AlertRule
+	err := r.db.Where("active = ?", true).
+		Where(r.db.Where("patient_id = ?", patientID).
+			Or("condition_code IS NOT NULL AND EXISTS (?)", diagnosed).
+			Or("patient_id IS NULL AND condition_code IS NULL")).
+		Order("id asc").
+		Find(&rules).Error
+	return rules, err
+}
+
+// GetLatestAppointment returns the patient's latest appointment in one of the statuses, nil when there is none
+func (r *medicalRepository) GetLatestAppointment(patientID uint, statuses []models.AppointmentStatus) (*models.Appointment, error) {
+	var appts []models.Appointment
+	err := r.db.Where("patient_id = ? AND status IN ?", patientID, statuses).
+		Order("appointment_date DESC").
+		Limit(1).
+		Find(&appts).Error
+	if err != nil || len(appts) == 0 {
+		return nil, err
+	}
+	return &appts[0], nil
+}
+
+// CreateAlert stores a new alert and reports whether it was created. Nothing is stored while the rule
+// still has an unresolved alert for the patient; the insert does not fail so a surrounding transaction survives.
+func (r *medicalRepository) CreateAlert(alert *models.Alert) (bool, error) {
+	result := r.db.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "rule_id"}, {Name: "patient_id"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "status <> 'resolved'"}}},
+		DoNothing:   true,
+	}).Create(alert)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *medicalRepository) GetAlert(id uint) (*models.Alert, error) {
+	var alert models.Alert
+	err := r.db.Preload("Rule").Preload("Patient.User").First(&alert, id).Error
+	return &alert, err
+}
+
+// AlertFilter narrows an alert listing; zero values are ignored
+type AlertFilter struct {
+	DoctorID  uint
+	PatientID uint
+	Status    models.AlertStatus
+}
+
+func (r *medicalRepository) GetAlerts(filter AlertFilter) ([]models.Alert, error) {
+	query := r.db.Preload("Rule").Preload("Patient.User")
+	if filter.DoctorID != 0 {
+		query = query.Where("doctor_id = ?", filter.DoctorID)
+	}
+	if filter.PatientID != 0 {
+		query = query.Where("patient_id = ?", filter.PatientID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var alerts []models.Alert
+	err := query.Order("created_at desc").Find(&alerts).Error
+	return alerts, err
+}
+
+// UpdateAlertStatus stores the new status of an alert that is still in status from.
+// It returns ErrConflict when the alert was updated concurrently.
+func (r *medicalRepository) UpdateAlertStatus(alert *models.Alert, from models.AlertStatus) error {
+	result := r.db.Model(&models.Alert{}).
+		Where("id = ? AND status = ?", alert.ID, from).
+		Updates(map[string]interface{}{
+			"status":             alert.Status,
+			"acknowledged_by_id": alert.AcknowledgedByID,
+			"acknowledged_at":    alert.AcknowledgedAt,
+			"resolved_by_id":     alert.ResolvedByID,
+			"resolved_at":        alert.ResolvedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// UpsertDrugs inserts the drugs in batches, refreshing entries already present
+func (r *medicalRepository) UpsertDrugs(drugs []models.Drug) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "ingredient", "drug_class", "updated_at"}),
+	}).CreateInBatches(drugs, 1000).Error
+}
+
+func (r *medicalRepository) UpsertDrugInteractions(interactions []models.DrugInteraction) error {
+	if len(interactions) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ingredient_a"}, {Name: "ingredient_b"}},
+		DoUpdates: clause.AssignmentColumns([]string{"severity", "description", "updated_at"}),
+	}).CreateInBatches(interactions, 1000).Error
+}
+
+// SearchDrugs matches names by substring or trigram similarity and ingredients by prefix
+func (r *medicalRepository) SearchDrugs(text string, limit int) ([]models.Drug, error) {
+	var drugs []models.Drug
+	err := r.db.
+		Where("name ILIKE ? OR name % ? OR ingredient LIKE lower(?)", "%"+likeEscaper.Replace(text)+"%", text, likeEscaper.Replace(text)+"%").
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "similarity(name, ?) DESC, name ASC", Vars: []interface{}{text}}}).
+		Limit(limit).
+		Find(&drugs).Error
+	return drugs, err
+}
+
+func (r *medicalRepository) GetDrug(code string) (*models.Drug, error) {
+	var drug models.Drug
+	err := r.db.Where("code = ?", code).First(&drug).Error
+	return &drug, err
+}
+
+func (r *medicalRepository) GetDrugsByCodes(codes []string) ([]models.Drug, error) {
+	var drugs []models.Drug
+	err := r.db.Where("code IN ?", codes).Find(&drugs).Error
+	return drugs, err
+}
+
+// GetDrugsByNames looks drugs up by case-insensitive name; names must be passed in lower case
+func (r *medicalRepository) GetDrugsByNames(names []string) ([]models.Drug, error) {
+	var drugs []models.Drug
+	err := r.db.Where("lower(name) IN ?", names).Order("code asc").Find(&drugs).Error
+	return drugs, err
+}
+
+// GetInteractionsAmong returns the interactions recorded between any two of the ingredients
+func (r *medicalRepository) GetInteractionsAmong(ingredients []string) ([]models.DrugInteraction, error) {
+	var interactions []models.DrugInteraction
+	err := r.db.Where("ingredient_a IN ? AND ingredient_b IN ?", ingredients, ingredients).Find(&interactions).Error
+	return interactions, err
+}
+
+// GetActivePrescriptionsByPatient returns the patient's open, unexpired prescriptions with their catalog entry
+func (r *medicalRepository) GetActivePrescriptionsByPatient(patientID uint, now time.Time) ([]models.Prescription, error) {
+	var prescs []models.Prescription
+	err := r.db.Joins("JOIN consultations ON consultations.id = prescriptions.consultation_id").
+		Joins("JOIN appointments ON appointments.id = consultations.appointment_id").
+		Preload("Drug").
+		Where("appointments.patient_id = ? AND prescriptions.status IN ? AND prescriptions.expires_at > ?",
+			patientID, []models.PrescriptionStatus{models.StatusIssued, models.StatusPartiallyDispensed}, now).
+		Find(&prescs).Error
+	return prescs, err
+}
+
 func (r *medicalRepository) CreateConsultation(cons *models.Consultation) error {
-	return r.db.Create(cons).Error
+	return r.db.Omit("Diagnoses").Create(cons).Error
 }
 
 func (r *medicalRepository) GetConsultationByAppointment(apptID uint) (*models.Consultation, error) {
 	var cons models.Consultation
-	err := r.db.Where("appointment_id = ?", apptID).First(&cons).Error
+	err := r.db.Preload("Appointment.Doctor.User").Preload("Appointment.Patient.User").Preload("Diagnoses", "amendment_id IS NULL").Preload("Diagnoses.DiagnosisCode").Where("appointment_id = ?", apptID).First(&cons).Error
 	return &cons, err
 }
 
+func (r *medicalRepository) GetConsultationByID(id uint) (*models.Consultation, error) {
+	var cons models.Consultation
+	err := r.db.Preload("Appointment.Doctor.User").Preload("Appointment.Patient.User").Preload("Diagnoses", "amendment_id IS NULL").Preload("Diagnoses.DiagnosisCode").First(&cons, id).Error
+	return &cons, err
+}
+
+func (r *medicalRepository) GetConsultationAmendments(consID uint) ([]models.ConsultationAmendment, error) {
+	var amendments []models.ConsultationAmendment
+	err := r.db.Preload("Author").Preload("Diagnoses.DiagnosisCode").Where("consultation_id = ?", consID).Order("version asc").Find(&amendments).Error
+	return amendments, err
+}
+
+// CreateConsultationAmendment stores the amendment as the next version of the consultation.
+// Two amendments racing for the same version make the later one fail with ErrConflict.
+func (r *medicalRepository) CreateConsultationAmendment(amendment *models.ConsultationAmendment) error {
+	var latest int
+	err := r.db.Model(&models.ConsultationAmendment{}).
+		Where("consultation_id = ?", amendment.ConsultationID).
+		Select("COALESCE(MAX(version), 1)").
+		Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+	amendment.Version = latest + 1
+	return translateError(r.db.Omit(clause.Associations).Create(amendment).Error)
+}
+
 func (r *medicalRepository) CreatePrescription(presc *models.Prescription) error {
-	return r.db.Create(presc).Error
+	return translateError(r.db.Omit(clause.Associations).Create(presc).Error)
 }
 
 func (r *medicalRepository) GetPrescriptionsByConsultation(consID uint) ([]models.Prescription, error) {
@@ -196,3 +846,137 @@ func (r *medicalRepository) GetPrescriptionsByDoctor(doctorID uint) ([]models.Pr
 func (r *medicalRepository) UpdatePrescription(presc *models.Prescription) error {
 	return r.db.Save(presc).Error
 }
+
+func (r *medicalRepository) GetPrescriptionByID(id uint) (*models.Prescription, error) {
+	var presc models.Prescription
+	err := r.db.Preload("Consultation.Appointment.Patient.User").
+		Preload("Consultation.Appointment.Doctor.User").
+		Preload("Dispenses", func(db *gorm.DB) *gorm.DB { return db.Order("dispensed_at asc") }).
+		Preload("Dispenses.Pharmacist").
+		First(&presc, id).Error
+	return &presc, err
+}
+
+// UpdatePrescriptionStatus changes only the status column, and only if it still equals from.
+// It returns ErrConflict when the prescription was changed concurrently.
+func (r *medicalRepository) UpdatePrescriptionStatus(id uint, from, to models.PrescriptionStatus) error {
+	result := r.db.Model(&models.Prescription{}).
+		Where("id = ? AND status = ?", id, from).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// ExpirePrescriptions moves every open prescription past its expiry date to Expired
+func (r *medicalRepository) ExpirePrescriptions(now time.Time) (int64, error) {
+	result := r.db.Model(&models.Prescription{}).
+		Where("status IN ? AND expires_at < ?", []models.PrescriptionStatus{models.StatusIssued, models.StatusPartiallyDispensed}, now).
+		Update("status", models.StatusExpired)
+	return result.RowsAffected, result.Error
+}
+
+func (r *medicalRepository) GetPrescriptionByVerificationCode(code string) (*models.Prescription, error) {
+	return r.prescriptionByVerificationCode(r.db, code)
+}
+
+// LockPrescriptionByVerificationCode reads the prescription like GetPrescriptionByVerificationCode and
+// locks its row until the transaction ends, so concurrent dispenses are checked one after the other
+func (r *medicalRepository) LockPrescriptionByVerificationCode(code string) (*models.Prescription, error) {
+	return r.prescriptionByVerificationCode(r.db.Clauses(clause.Locking{Strength: "UPDATE"}), code)
+}
+
+func (r *medicalRepository) prescriptionByVerificationCode(db *gorm.DB, code string) (*models.Prescription, error) {
+	var presc models.Prescription
+	err := db.Preload("Consultation.Appointment.Patient.User").
+		Preload("Consultation.Appointment.Doctor.User").
+		Preload("Dispenses", func(db *gorm.DB) *gorm.DB { return db.Order("dispensed_at asc") }).
+		Preload("Dispenses.Pharmacist").
+		Where("verification_code = ?", code).
+		First(&presc).Error
+	return &presc, err
+}
+
+func (r *medicalRepository) CreatePrescriptionDispense(dispense *models.PrescriptionDispense) error {
+	return translateError(r.db.Omit(clause.Associations).Create(dispense).Error)
+}
+
+// RenewalFilter narrows a renewal listing; zero values are ignored
+type RenewalFilter struct {
+	PatientID uint
+	DoctorID  uint
+	Status    models.RenewalStatus
+}
+
+func (r *medicalRepository) CreatePrescriptionRenewal(renewal *models.PrescriptionRenewal) error {
+	return translateError(r.db.Omit(clause.Associations).Create(renewal).Error)
+}
+
+func (r *medicalRepository) GetPrescriptionRenewal(id uint) (*models.PrescriptionRenewal, error) {
+	var renewal models.PrescriptionRenewal
+	err := r.db.Preload("Prescription.Consultation.Appointment.Patient.User").
+		Preload("Prescription.Consultation.Appointment.Doctor.User").
+		First(&renewal, id).Error
+	return &renewal, err
+}
+
+func (r *medicalRepository) GetPrescriptionRenewals(filter RenewalFilter) ([]models.PrescriptionRenewal, error) {
+	query := r.db.Joins("JOIN prescriptions ON prescriptions.id = prescription_renewals.prescription_id").
+		Joins("JOIN consultations ON consultations.id = prescriptions.consultation_id").
+		Joins("JOIN appointments ON appointments.id = consultations.appointment_id").
+		Preload("Prescription.Consultation.Appointment.Patient.User").
+		Preload("Prescription.Consultation.Appointment.Doctor.User")
+	if filter.PatientID != 0 {
+		query = query.Where("appointments.patient_id = ?", filter.PatientID)
+	}
+	if filter.DoctorID != 0 {
+		query = query.Where("appointments.doctor_id = ?", filter.DoctorID)
+	}
+	if filter.Status != "" {
+		query = query.Where("prescription_renewals.status = ?", filter.Status)
+	}
+
+	var renewals []models.PrescriptionRenewal
+	err := query.Order("prescription_renewals.created_at desc").Find(&renewals).Error
+	return renewals, err
+}
+
+// IsPrescriptionRenewed reports whether a prescription renewing the given one was issued
+func (r *medicalRepository) IsPrescriptionRenewed(prescID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Prescription{}).Where("renewed_from_id = ?", prescID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *medicalRepository) HasPendingRenewal(prescID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.PrescriptionRenewal{}).
+		Where("prescription_id = ? AND status = ?", prescID, models.RenewalPending).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// DecidePrescriptionRenewal stores the decision on a renewal that is still pending.
+// It returns ErrConflict when the renewal was decided concurrently.
+func (r *medicalRepository) DecidePrescriptionRenewal(renewal *models.PrescriptionRenewal) error {
+	result := r.db.Model(&models.PrescriptionRenewal{}).
+		Where("id = ? AND status = ?", renewal.ID, models.RenewalPending).
+		Updates(map[string]interface{}{
+			"status":          renewal.Status,
+			"decided_by_id":   renewal.DecidedByID,
+			"decision_reason": renewal.DecisionReason,
+			"renewal_id":      renewal.RenewalID,
+			"decided_at":      renewal.DecidedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package repository
+
+import "gorm.io/gorm"
+
+// Repositories groups the repositories bound to the same database handle or transaction
+type Repositories struct {
+	Users   UserRepository
+	Medical MedicalRepository
+}
+
+// UnitOfWork runs multi-step service operations atomically
+type UnitOfWork interface {
+	// Transaction runs fn inside a single database transaction, handing it repositories bound to it.
+	// Returning an error (or panicking) from fn rolls back every write made through them.
+	Transaction(fn func(tx Repositories) error) error
+}
+
+type unitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) UnitOfWork {
+	return &unitOfWork{db: db}
+}
+
+func (u *unitOfWork) Transaction(fn func(tx Repositories) error) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		return fn(Repositories{
+			Users:   NewUserRepository(tx),
+			Medical: NewMedicalRepository(tx),
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/cristim67/med-monitor/backend/models"
 	"gorm.io/gorm"
 )
@@ -12,6 +14,27 @@ type UserRepository interface {
 	UpdateUser(user *models.User) error
 	CreatePatient(patient *models.Patient) error
 	GetAllUsers() ([]models.User, error)
+	CreateRoleEvent(event *models.UserRoleEvent) error
+	GetRoleEvents(userID uint) ([]models.UserRoleEvent, error)
+
+	// Sessions
+	CreateSession(session *models.Session) error
+	GetSession(id uint) (*models.Session, error)
+	GetActiveSessions(userID uint, now time.Time) ([]models.Session, error)
+	RotateSessionToken(id uint, oldHash, newHash string, usedAt, expiresAt time.Time) error
+	RevokeSession(id uint, reason string, at time.Time) error
+	RevokeUserSessions(userID uint, reason string, at time.Time) (int64, error)
+
+	// Service accounts and their API keys
+	CreateServiceAccount(account *models.ServiceAccount) error
+	GetServiceAccounts() ([]models.ServiceAccount, error)
+	GetServiceAccount(id uint) (*models.ServiceAccount, error)
+	UpdateServiceAccount(account *models.ServiceAccount) error
+	CreateAPIKey(key *models.APIKey) error
+	GetAPIKey(id uint) (*models.APIKey, error)
+	UpdateAPIKey(key *models.APIKey) error
+	RevokeAPIKeys(serviceAccountID uint, at time.Time) error
+	TouchAPIKey(id uint, usedAt time.Time) error
 }
 
 type userRepository struct {
@@ -31,7 +54,7 @@ func (r *userRepository) FindByEmail(email string) (*models.User, error) {
 }
 
 func (r *userRepository) CreateUser(user *models.User) error {
-	return r.db.Create(user).Error
+	return translateError(r.db.Create(user).Error)
 }
 
 func (r *userRepository) UpdateUser(user *models.User) error {
@@ -54,3 +77,120 @@ func (r *userRepository) GetAllUsers() ([]models.User, error) {
 	err := r.db.Find(&users).Error
 	return users, err
 }
+
+func (r *userRepository) CreateRoleEvent(event *models.UserRoleEvent) error {
+	return translateError(r.db.Create(event).Error)
+}
+
+func (r *userRepository) GetRoleEvents(userID uint) ([]models.UserRoleEvent, error) {
+	var events []models.UserRoleEvent
+	err := r.db.Where("user_id = ?", userID).Order("created_at asc, id asc").Find(&events).Error
+	return events, err
+}
+
+func (r *userRepository) CreateSession(session *models.Session) error {
+	return translateError(r.db.Omit("User").Create(session).Error)
+}
+
+// GetSession returns the session with its user; a deleted user leaves User empty
+func (r *userRepository) GetSession(id uint) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Preload("User").First(&session, id).Error
+	return &session, err
+}
+
+func (r *userRepository) GetActiveSessions(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RotateSessionToken replaces the refresh token hash, only if it still equals oldHash and the session
+// is not revoked, and keeps oldHash as the previous one. It returns ErrConflict when another refresh
+// or a revocation got there first.
+func (r *userRepository) RotateSessionToken(id uint, oldHash, newHash string, usedAt, expiresAt time.Time) error {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", id, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":          newHash,
+			"previous_refresh_token_hash": oldHash,
+			"last_used_at":                usedAt,
+			"expires_at":                  expiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *userRepository) RevokeSession(id uint, reason string, at time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": at, "revoked_reason": reason}).Error
+}
+
+// RevokeUserSessions revokes every open session of the user and returns how many there were
+func (r *userRepository) RevokeUserSessions(userID uint, reason string, at time.Time) (int64, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": at, "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+func (r *userRepository) CreateServiceAccount(account *models.ServiceAccount) error {
+	return translateError(r.db.Omit("User", "Keys").Create(account).Error)
+}
+
+func (r *userRepository) GetServiceAccounts() ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	err := r.db.Preload("Keys", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Order("name").
+		Find(&accounts).Error
+	return accounts, err
+}
+
+func (r *userRepository) GetServiceAccount(id uint) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := r.db.Preload("Keys", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&account, id).Error
+	return &account, err
+}
+
+func (r *userRepository) UpdateServiceAccount(account *models.ServiceAccount) error {
+	return r.db.Omit("User", "Keys").Save(account).Error
+}
+
+func (r *userRepository) CreateAPIKey(key *models.APIKey) error {
+	return translateError(r.db.Omit("ServiceAccount").Create(key).Error)
+}
+
+// GetAPIKey returns the key with its service account and the account's user
+func (r *userRepository) GetAPIKey(id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.Preload("ServiceAccount.User").First(&key, id).Error
+	return &key, err
+}
+
+// UpdateAPIKey writes the expiry and revocation of a key, last_used_at belongs to TouchAPIKey
+func (r *userRepository) UpdateAPIKey(key *models.APIKey) error {
+	return r.db.Model(key).Updates(map[string]interface{}{
+		"expires_at": key.ExpiresAt,
+		"revoked_at": key.RevokedAt,
+	}).Error
+}
+
+// RevokeAPIKeys revokes every key of the service account that is still active
+func (r *userRepository) RevokeAPIKeys(serviceAccountID uint, at time.Time) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("service_account_id = ? AND revoked_at IS NULL", serviceAccountID).
+		Update("revoked_at", at).Error
+}
+
+// TouchAPIKey records when a key was last used
+func (r *userRepository) TouchAPIKey(id uint, usedAt time.Time) error {
+	return r.db.Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
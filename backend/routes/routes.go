@@ -7,10 +7,13 @@ import (
 	"github.com/cristim67/med-monitor/backend/handlers"
 	"github.com/cristim67/med-monitor/backend/middleware"
 	"github.com/cristim67/med-monitor/backend/services"
+	"github.com/cristim67/med-monitor/backend/utils"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(enforcer *casbin.Enforcer, userService services.UserService, medicalService services.MedicalService) *gin.Engine {
+// SetupRouter wires the API. Identity provider tokens exchanged for sessions are checked by verifier;
+// devLogin enables the dev login.
+func SetupRouter(enforcer *casbin.Enforcer, userService services.UserService, medicalService services.MedicalService, verifier utils.TokenVerifier, devLogin bool) *gin.Engine {
 	r := gin.New()
 	r.Use(middleware.LoggerMiddleware())
 	r.Use(gin.Recovery())
@@ -24,16 +27,51 @@ func SetupRouter(enforcer *casbin.Enforcer, userService services.UserService, me
 	// Handlers
 	medHandler := handlers.NewMedicalHandler(medicalService)
 	userHandler := handlers.NewUserHandler(userService)
+	authHandler := handlers.NewAuthHandler(userService, verifier)
+
+	// Sign in with an identity provider token, then keep the session alive with its refresh token
+	r.POST("/api/v1/auth/token", authHandler.ExchangeToken)
+	r.POST("/api/v1/auth/refresh", authHandler.RefreshToken)
+
+	// Development and tests only: sign in as any role without an identity provider
+	if devLogin {
+		r.POST("/api/v1/auth/dev-login", authHandler.DevLogin)
+	}
+
+	// Monitoring devices authenticate with their own credential instead of a session
+	deviceAPI := r.Group("/api/v1/telemetry")
+	deviceAPI.Use(middleware.DeviceAuthMiddleware(medicalService))
+	{
+		deviceAPI.POST("", medHandler.IngestTelemetry)
+	}
+
+	// Public: pharmacies fetch the prescription signing key to verify QR codes offline
+	r.GET("/api/v1/pharmacy/public-key", medHandler.GetPrescriptionPublicKey)
 
 	// Protected routes group
 	v1 := r.Group("/api/v1")
 	v1.Use(middleware.AuthMiddleware(enforcer, userService))
 	{
 		v1.GET("/profile", userHandler.GetProfile)
+		v1.POST("/auth/logout", authHandler.Logout)
+		v1.POST("/auth/logout-all", authHandler.LogoutAll)
 
 		// Admin only: User management
 		v1.GET("/users", userHandler.ListUsers)
 		v1.PUT("/users/:id/role", userHandler.UpdateUserRole)
+		v1.GET("/users/:id/role-events", userHandler.GetRoleEvents)
+		v1.GET("/users/:id/sessions", userHandler.GetSessions)
+		v1.DELETE("/users/:id/sessions", userHandler.RevokeSessions)
+		v1.DELETE("/sessions/:id", userHandler.RevokeSession)
+
+		// Admin only: service accounts of machine integrations and their API keys
+		v1.GET("/service-accounts", userHandler.ListServiceAccounts)
+		v1.POST("/service-accounts", userHandler.CreateServiceAccount)
+		v1.GET("/service-accounts/:id", userHandler.GetServiceAccount)
+		v1.DELETE("/service-accounts/:id", userHandler.DisableServiceAccount)
+		v1.POST("/service-accounts/:id/keys", userHandler.CreateAPIKey)
+		v1.PUT("/service-accounts/:id/keys/:key_id/rotate", userHandler.RotateAPIKey)
+		v1.DELETE("/service-accounts/:id/keys/:key_id", userHandler.RevokeAPIKey)
 		// Departments & Catalog
 		v1.GET("/departments", medHandler.GetDepartments)
 		v1.POST("/departments", medHandler.CreateDepartment)
@@ -42,22 +80,85 @@ func SetupRouter(enforcer *casbin.Enforcer, userService services.UserService, me
 
 		v1.GET("/doctors", medHandler.GetDoctors)
 		v1.GET("/doctors/:id/availability", medHandler.GetDoctorAvailability)
+		v1.GET("/doctors/:id/schedule", medHandler.GetDoctorSchedule)
+		v1.PUT("/doctors/:id/schedule", medHandler.UpdateDoctorSchedule)
 		// ... potentially more later
 
+		// Absences & clinic closures
+		v1.GET("/absences", medHandler.GetAbsences)
+		v1.POST("/absences", medHandler.CreateAbsence)
+		v1.DELETE("/absences/:id", medHandler.DeleteAbsence)
+
 		// Patients
 		v1.GET("/patients", medHandler.GetPatients)
 		v1.GET("/patients/:id/history", medHandler.GetPatientHistory)
+		v1.GET("/patients/:id/no-shows", medHandler.GetPatientNoShows)
+		v1.GET("/patients/:id/allergies", medHandler.GetPatientAllergies)
+		v1.POST("/patients/:id/allergies", medHandler.CreatePatientAllergy)
+		v1.PUT("/patients/:id/allergies/:allergy_id", medHandler.UpdatePatientAllergy)
+		v1.DELETE("/patients/:id/allergies/:allergy_id", medHandler.DeletePatientAllergy)
+		v1.GET("/patients/:id/observations", medHandler.GetObservations)
+		v1.POST("/patients/:id/observations", medHandler.RecordObservations)
+		v1.GET("/patients/:id/devices", medHandler.GetPatientDevices)
+		v1.POST("/patients/:id/devices", medHandler.RegisterDevice)
+		v1.PUT("/patients/:id/devices/:device_id/credential", medHandler.RotateDeviceCredential)
+		v1.DELETE("/patients/:id/devices/:device_id", medHandler.RevokeDevice)
+
+		// Vital sign alerts: rules are evaluated on every new observation
+		v1.GET("/alert-rules", medHandler.GetAlertRules)
+		v1.POST("/alert-rules", medHandler.CreateAlertRule)
+		v1.PUT("/alert-rules/:id", medHandler.UpdateAlertRule)
+		v1.DELETE("/alert-rules/:id", medHandler.DeleteAlertRule)
+		v1.GET("/alerts", medHandler.GetAlerts)
+		v1.PUT("/alerts/:id/acknowledge", medHandler.AcknowledgeAlert)
+		v1.PUT("/alerts/:id/resolve", medHandler.ResolveAlert)
 
 		// Appointments
 		v1.GET("/appointments", medHandler.GetMyAppointments)
 		v1.POST("/appointments", medHandler.CreateAppointment)
 		v1.PUT("/appointments/:id/complete", medHandler.CompleteAppointment)
 		v1.PUT("/appointments/:id/cancel", medHandler.CancelAppointment)
+		v1.PUT("/appointments/:id/reschedule", medHandler.RescheduleAppointment)
+		v1.GET("/appointments/:id/history", medHandler.GetAppointmentHistory)
+		v1.PUT("/appointments/:id/status", medHandler.UpdateAppointmentStatus)
+		v1.GET("/appointments/:id/events", medHandler.GetAppointmentStatusEvents)
 		v1.DELETE("/appointments/:id", medHandler.DeleteAppointment)
 
+		// Consultations
+		v1.GET("/appointments/:id/consultation", medHandler.GetAppointmentConsultation)
+		v1.GET("/consultations/:id", medHandler.GetConsultation)
+		v1.POST("/consultations/:id/amendments", medHandler.AmendConsultation)
+
+		// ICD-10 diagnosis catalog
+		v1.GET("/diagnosis-codes", medHandler.SearchDiagnosisCodes)
+		v1.GET("/diagnosis-codes/:code", medHandler.GetDiagnosisCode)
+
+		// Drug catalog
+		v1.GET("/drugs", medHandler.SearchDrugs)
+		v1.GET("/drugs/:code", medHandler.GetDrug)
+
+		// Clinical note templates (managed by admins)
+		v1.GET("/note-templates", medHandler.GetNoteTemplates)
+		v1.GET("/note-templates/:id", medHandler.GetNoteTemplate)
+		v1.POST("/note-templates", medHandler.CreateNoteTemplate)
+		v1.PUT("/note-templates/:id", medHandler.UpdateNoteTemplate)
+		v1.DELETE("/note-templates/:id", medHandler.DeleteNoteTemplate)
+
 		// Prescriptions
 		v1.GET("/prescriptions", medHandler.GetMyPrescriptions)
 		v1.PUT("/prescriptions/:id", medHandler.UpdatePrescription)
+		v1.GET("/prescriptions/:id/qr", medHandler.GetPrescriptionQR)
+		v1.POST("/prescriptions/:id/renewals", medHandler.RequestPrescriptionRenewal)
+
+		// Prescription renewals: requested by patients, decided by the prescribing doctor
+		v1.GET("/prescription-renewals", medHandler.GetPrescriptionRenewals)
+		v1.PUT("/prescription-renewals/:id/approve", medHandler.ApprovePrescriptionRenewal)
+		v1.PUT("/prescription-renewals/:id/deny", medHandler.DenyPrescriptionRenewal)
+
+		// Pharmacy portal: prescriptions are reached by the code the patient presents
+		v1.GET("/pharmacy/prescriptions/:code", medHandler.LookupPrescription)
+		v1.POST("/pharmacy/prescriptions/:code/dispenses", medHandler.DispensePrescription)
+		v1.POST("/pharmacy/verify", medHandler.VerifyPrescription)
 	}
 
 	return r
@@ -0,0 +1,122 @@
+// Package rxsign signs and verifies the prescription payloads patients carry as QR codes,
+// so a pharmacy can check a prescription with nothing but the clinic's public key.
+package rxsign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Prefix identifies the payload format and version, it is the start of every signed payload
+const Prefix = "MEDRX1"
+
+var (
+	ErrMalformed    = errors.New("prescription payload is malformed")
+	ErrBadSignature = errors.New("prescription payload signature is invalid")
+	ErrExpired      = errors.New("prescription payload has expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Payload is the signed content of a prescription QR code
+type Payload struct {
+	KeyID            string    `json:"kid"`
+	PrescriptionID   uint      `json:"rx"`
+	VerificationCode string    `json:"code"`
+	Medication       string    `json:"med"`
+	Dosage           string    `json:"dose"`
+	PatientID        uint      `json:"pid"`
+	PatientName      string    `json:"patient"`
+	DoctorID         uint      `json:"did"`
+	DoctorName       string    `json:"doctor"`
+	IssuedAt         time.Time `json:"iat"`
+	ExpiresAt        time.Time `json:"exp"`
+}
+
+// Signer holds the clinic's Ed25519 signing key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner builds a signer from a base64 encoded 32 byte Ed25519 seed
+func NewSigner(seed string) (*Signer, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be a %d byte Ed25519 seed, got %d bytes", ed25519.SeedSize, len(raw))
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(raw)}, nil
+}
+
+// GenerateSeed returns a fresh base64 encoded Ed25519 seed for NewSigner
+func GenerateSeed() (string, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(seed), nil
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID returns a short fingerprint of the public key, so verifiers can tell rotated keys apart
+func (s *Signer) KeyID() string {
+	return KeyID(s.PublicKey())
+}
+
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign encodes the payload as "MEDRX1.<payload>.<signature>", both parts base64url without padding
+func (s *Signer) Sign(p Payload) (string, error) {
+	p.KeyID = s.KeyID()
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	signed := Prefix + "." + encoding.EncodeToString(body)
+	sig := ed25519.Sign(s.key, []byte(signed))
+	return signed + "." + encoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature of a payload produced by Sign and that it has not expired at now
+func Verify(pub ed25519.PublicKey, token string, now time.Time) (*Payload, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || parts[0] != Prefix {
+		return nil, ErrMalformed
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrBadSignature
+	}
+
+	body, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, ErrMalformed
+	}
+	if !now.Before(p.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return &p, nil
+}
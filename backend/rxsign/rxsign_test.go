@@ -0,0 +1,143 @@
+package rxsign
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	t.Helper()
+	seed, err := GenerateSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func testPayload(now time.Time) Payload {
+	return Payload{
+		PrescriptionID:   7,
+		VerificationCode: "ABCD-1234",
+		Medication:       "Amoxicillin",
+		Dosage:           "500mg three times a day",
+		PatientID:        3,
+		PatientName:      "Jane Doe",
+		DoctorID:         2,
+		DoctorName:       "Dr. Smith",
+		IssuedAt:         now.Add(-time.Hour).UTC().Truncate(time.Second),
+		ExpiresAt:        now.Add(time.Hour).UTC().Truncate(time.Second),
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    string
+		wantErr bool
+	}{
+		{name: "32 byte seed", seed: base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		{name: "surrounding whitespace", seed: " " + base64.StdEncoding.EncodeToString(make([]byte, 32)) + "\n"},
+		{name: "not base64", seed: "not a key!", wantErr: true},
+		{name: "too short", seed: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+		{name: "full private key instead of a seed", seed: base64.StdEncoding.EncodeToString(make([]byte, 64)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSigner(tt.seed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	signer := newTestSigner(t)
+	want := testPayload(now)
+
+	token, err := signer.Sign(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, Prefix+".") {
+		t.Fatalf("token %q does not start with %s", token, Prefix)
+	}
+
+	got, err := Verify(signer.PublicKey(), token, now)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	want.KeyID = signer.KeyID()
+	if *got != want {
+		t.Errorf("Verify() = %+v, want %+v", *got, want)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	signer := newTestSigner(t)
+	token, err := signer.Sign(testPayload(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// tampered re-encodes the payload with a changed medication, keeping the original signature
+	var body map[string]interface{}
+	raw, _ := encoding.DecodeString(parts[1])
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatal(err)
+	}
+	body["med"] = "Oxycodone"
+	changed, _ := json.Marshal(body)
+	tampered := parts[0] + "." + encoding.EncodeToString(changed) + "." + parts[2]
+
+	// resigned is a valid token, but from a key the verifier does not trust
+	other := newTestSigner(t)
+	resigned, err := other.Sign(testPayload(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{name: "tampered payload", token: tampered, now: now, want: ErrBadSignature},
+		{name: "signed with another key", token: resigned, now: now, want: ErrBadSignature},
+		{name: "truncated signature", token: token[:len(token)-4], now: now, want: ErrBadSignature},
+		{name: "signature swapped from another token", token: parts[0] + "." + parts[1] + "." + strings.Split(resigned, ".")[2], now: now, want: ErrBadSignature},
+		{name: "unknown prefix", token: "MEDRX2." + parts[1] + "." + parts[2], now: now, want: ErrMalformed},
+		{name: "missing signature", token: parts[0] + "." + parts[1], now: now, want: ErrMalformed},
+		{name: "signature not base64url", token: parts[0] + "." + parts[1] + ".***", now: now, want: ErrMalformed},
+		{name: "expired", token: token, now: now.Add(2 * time.Hour), want: ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(signer.PublicKey(), tt.token, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyIDDiffersBetweenKeys(t *testing.T) {
+	a, b := newTestSigner(t), newTestSigner(t)
+	if a.KeyID() == b.KeyID() {
+		t.Errorf("two keys share the key id %s", a.KeyID())
+	}
+	if len(a.KeyID()) != 16 {
+		t.Errorf("KeyID() = %q, want 16 hex characters", a.KeyID())
+	}
+}
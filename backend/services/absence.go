@@ -0,0 +1,91 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// maxAbsenceListDays bounds how many days a single absence listing may span
+const maxAbsenceListDays = 366
+
+// absenceAt returns the first absence overlapping slot, or nil
+func absenceAt(slot models.TimeSlot, absences []models.Absence) *models.Absence {
+	for i := range absences {
+		if slot.Start.Before(absences[i].EndDate) && absences[i].StartDate.Before(slot.End) {
+			return &absences[i]
+		}
+	}
+	return nil
+}
+
+// checkAbsences rejects slots falling into a leave, department closure or clinic holiday
+func (s *medicalService) checkAbsences(doc *models.Doctor, slot *models.TimeSlot) error {
+	absences, err := s.repo.GetDoctorAbsencesBetween(doc.ID, doc.DepartmentID, slot.Start, slot.End)
+	if err != nil {
+		return err
+	}
+	// The reason of an absence is for staff, patients booking only learn the doctor is away
+	if absenceAt(*slot, absences) != nil {
+		return &ValidationError{Reason: "doctor is unavailable at the requested time"}
+	}
+	return nil
+}
+
+func (s *medicalService) GetAbsences(from, to string) ([]models.Absence, error) {
+	fromDay, toDay, err := s.parseDateRange(from, to, maxAbsenceListDays)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetAbsencesBetween(fromDay, toDay.AddDate(0, 0, 1))
+}
+
+func (s *medicalService) GetAbsence(id uint) (*models.Absence, error) {
+	return s.repo.GetAbsenceByID(id)
+}
+
+// CreateAbsence stores the absence and, when flagAppointments is set, marks the scheduled appointments
+// inside it for rescheduling. It returns the number of flagged appointments.
+func (s *medicalService) CreateAbsence(absence *models.Absence, flagAppointments bool) (int64, error) {
+	if !absence.StartDate.Before(absence.EndDate) {
+		return 0, &ValidationError{Reason: "absence end date must be after its start date"}
+	}
+
+	switch absence.Scope {
+	case models.AbsenceDoctor:
+		if absence.DoctorID == nil || absence.DepartmentID != nil {
+			return 0, &ValidationError{Reason: "doctor absences require doctor_id only"}
+		}
+		if _, err := s.repo.GetDoctorByID(*absence.DoctorID); err != nil {
+			return 0, err
+		}
+	case models.AbsenceDepartment:
+		if absence.DepartmentID == nil || absence.DoctorID != nil {
+			return 0, &ValidationError{Reason: "department closures require department_id only"}
+		}
+		if _, err := s.repo.GetDepartmentByID(*absence.DepartmentID); err != nil {
+			return 0, err
+		}
+	case models.AbsenceClinic:
+		if absence.DoctorID != nil || absence.DepartmentID != nil {
+			return 0, &ValidationError{Reason: "clinic closures cannot reference a doctor or department"}
+		}
+	default:
+		return 0, &ValidationError{Reason: fmt.Sprintf("unknown absence scope %q", absence.Scope)}
+	}
+
+	if err := s.repo.CreateAbsence(absence); err != nil {
+		return 0, err
+	}
+	if !flagAppointments {
+		return 0, nil
+	}
+	return s.repo.FlagAppointmentsInAbsence(absence)
+}
+
+func (s *medicalService) DeleteAbsence(id uint) error {
+	if _, err := s.repo.GetAbsenceByID(id); err != nil {
+		return err
+	}
+	return s.repo.DeleteAbsence(id)
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+const maxConsecutiveReadings = 50
+
+// alertMetricTypes maps each alert metric to the observation type it is read from
+var alertMetricTypes = map[models.AlertMetric]models.ObservationType{
+	models.MetricSystolic:    models.ObservationBloodPressure,
+	models.MetricDiastolic:   models.ObservationBloodPressure,
+	models.MetricHeartRate:   models.ObservationHeartRate,
+	models.MetricTemperature: models.ObservationTemperature,
+	models.MetricSpO2:        models.ObservationSpO2,
+	models.MetricWeight:      models.ObservationWeight,
+	models.MetricGlucose:     models.ObservationGlucose,
+}
+
+var alertOperatorSymbols = map[models.AlertOperator]string{
+	models.OperatorGreaterThan:    ">",
+	models.OperatorGreaterOrEqual: ">=",
+	models.OperatorLessThan:       "<",
+	models.OperatorLessOrEqual:    "<=",
+}
+
+// metricValue returns the value of a reading the metric looks at
+func metricValue(metric models.AlertMetric, o models.Observation) float64 {
+	if metric == models.MetricDiastolic && o.DiastolicValue != nil {
+		return *o.DiastolicValue
+	}
+	return o.Value
+}
+
+// breachesThreshold reports whether a value crosses the rule's threshold
+func breachesThreshold(rule *models.AlertRule, v float64) bool {
+	switch rule.Operator {
+	case models.OperatorGreaterThan:
+		return v > rule.Threshold
+	case models.OperatorGreaterOrEqual:
+		return v >= rule.Threshold
+	case models.OperatorLessThan:
+		return v < rule.Threshold
+	case models.OperatorLessOrEqual:
+		return v <= rule.Threshold
+	}
+	return false
+}
+
+// validateAlertRule checks a rule and fills in the defaults for the readings count and severity
+func validateAlertRule(rule *models.AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return &ValidationError{Reason: "name is required"}
+	}
+	if _, ok := alertMetricTypes[rule.Metric]; !ok {
+		return &ValidationError{Reason: fmt.Sprintf("unknown alert metric %q", rule.Metric)}
+	}
+	if _, ok := alertOperatorSymbols[rule.Operator]; !ok {
+		return &ValidationError{Reason: fmt.Sprintf("unknown alert operator %q", rule.Operator)}
+	}
+	if math.IsNaN(rule.Threshold) || math.IsInf(rule.Threshold, 0) {
+		return &ValidationError{Reason: "threshold must be a number"}
+	}
+	if rule.ConsecutiveReadings == 0 {
+		rule.ConsecutiveReadings = 1
+	}
+	if rule.ConsecutiveReadings < 1 || rule.ConsecutiveReadings > maxConsecutiveReadings {
+		return &ValidationError{Reason: fmt.Sprintf("consecutive_readings must be between 1 and %d", maxConsecutiveReadings)}
+	}
+	if rule.Severity == "" {
+		rule.Severity = models.AlertWarning
+	}
+	if rule.Severity != models.AlertWarning && rule.Severity != models.AlertCritical {
+		return &ValidationError{Reason: fmt.Sprintf("unknown alert severity %q", rule.Severity)}
+	}
+
+	if rule.ConditionCode != nil {
+		code := catalog.NormalizeICD10Code(*rule.ConditionCode)
+		if code == "" {
+			rule.ConditionCode = nil
+		} else {
+			if len(code) > 10 {
+				return &ValidationError{Reason: "condition_code must be an ICD-10 code or code prefix"}
+			}
+			rule.ConditionCode = &code
+		}
+	}
+	if rule.PatientID != nil && rule.ConditionCode != nil {
+		return &ValidationError{Reason: "a rule targets either a patient or a condition, not both"}
+	}
+	return nil
+}
+
+// GetAlertRules lists every alert rule, or the rules targeting one patient when patientID is set
+func (s *medicalService) GetAlertRules(patientID *uint) ([]models.AlertRule, error) {
+	return s.repo.GetAlertRules(patientID)
+}
+
+// AlertRuleUpdate changes the fields of a rule that are set and keeps the others. A patient_id of 0
+// and an empty condition_code clear the target, so a rule can move from a patient to a condition.
+type AlertRuleUpdate struct {
+	Name                *string               `json:"name"`
+	PatientID           *uint                 `json:"patient_id"`
+	ConditionCode       *string               `json:"condition_code"`
+	Metric              *models.AlertMetric   `json:"metric"`
+	Operator            *models.AlertOperator `json:"operator"`
+	Threshold           *float64              `json:"threshold"`
+	ConsecutiveReadings *int                  `json:"consecutive_readings"`
+	Severity            *models.AlertSeverity `json:"severity"`
+	Active              *bool                 `json:"active"`
+}
+
+// appliesToEveryone reports whether a rule targets neither a patient nor a condition
+func appliesToEveryone(rule *models.AlertRule) bool {
+	return rule.PatientID == nil && rule.ConditionCode == nil
+}
+
+// checkAlertRuleAccess lets the author of a rule and admins change it; rules that apply to everyone are left to admins
+func checkAlertRuleAccess(rule *models.AlertRule, actorID uint, admin bool) error {
+	if admin {
+		return nil
+	}
+	if appliesToEveryone(rule) {
+		return &ForbiddenError{Reason: "only an admin can manage rules that apply to every patient"}
+	}
+	if rule.CreatedByID != actorID {
+		return &ForbiddenError{Reason: "only the author of an alert rule or an admin can change it"}
+	}
+	return nil
+}
+
+func (s *medicalService) CreateAlertRule(createdByID uint, admin bool, rule *models.AlertRule) error {
+	if err := validateAlertRule(rule); err != nil {
+		return err
+	}
+	if !admin && appliesToEveryone(rule) {
+		return &ForbiddenError{Reason: "only an admin can manage rules that apply to every patient"}
+	}
+	if rule.PatientID != nil {
+		if _, err := s.repo.GetPatientByID(*rule.PatientID); err != nil {
+			return err
+		}
+	}
+
+	rule.ID = 0
+	rule.Active = true
+	rule.CreatedByID = createdByID
+	return s.repo.CreateAlertRule(rule)
+}
+
+// UpdateAlertRule changes the fields of a rule set in the update. Alerts it already raised are left as they are.
+func (s *medicalService) UpdateAlertRule(id, actorID uint, admin bool, update AlertRuleUpdate) (*models.AlertRule, error) {
+	existing, err := s.repo.GetAlertRule(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAlertRuleAccess(existing, actorID, admin); err != nil {
+		return nil, err
+	}
+
+	rule := *existing
+	if update.Name != nil {
+		rule.Name = *update.Name
+	}
+	if update.PatientID != nil {
+		rule.PatientID = update.PatientID
+		if *update.PatientID == 0 {
+			rule.PatientID = nil
+		}
+	}
+	if update.ConditionCode != nil {
+		rule.ConditionCode = update.ConditionCode
+	}
+	if update.Metric != nil {
+		rule.Metric = *update.Metric
+	}
+	if update.Operator != nil {
+		rule.Operator = *update.Operator
+	}
+	if update.Threshold != nil {
+		rule.Threshold = *update.Threshold
+	}
+	if update.ConsecutiveReadings != nil {
+		rule.ConsecutiveReadings = *update.ConsecutiveReadings
+	}
+	if update.Severity != nil {
+		rule.Severity = *update.Severity
+	}
+	if update.Active != nil {
+		rule.Active = *update.Active
+	}
+
+	if err := validateAlertRule(&rule); err != nil {
+		return nil, err
+	}
+	if err := checkAlertRuleAccess(&rule, actorID, admin); err != nil {
+		return nil, err
+	}
+	if rule.PatientID != nil && update.PatientID != nil {
+		if _, err := s.repo.GetPatientByID(*rule.PatientID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.UpdateAlertRule(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (s *medicalService) DeleteAlertRule(id, actorID uint, admin bool) error {
+	rule, err := s.repo.GetAlertRule(id)
+	if err != nil {
+		return err
+	}
+	if err := checkAlertRuleAccess(rule, actorID, admin); err != nil {
+		return err
+	}
+	return s.repo.DeleteAlertRule(id)
+}
+
+// evaluateAlerts runs the patient's active rules against newly stored readings. A rule only looks at
+// a type of reading when one of the new readings is now the latest of that type, so back-filled
+// history does not raise alerts; it fires when the latest ConsecutiveReadings readings all breach it.
+func (s *medicalService) evaluateAlerts(patientID uint, fresh []models.Observation) error {
+	if len(fresh) == 0 {
+		return nil
+	}
+	rules, err := s.repo.GetActiveAlertRulesForPatient(patientID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	isFresh := make(map[uint]bool, len(fresh))
+	for _, o := range fresh {
+		isFresh[o.ID] = true
+	}
+	// The readings of each type are loaded once, as many as the most demanding rule needs
+	needed := make(map[models.ObservationType]int)
+	for _, rule := range rules {
+		obsType := alertMetricTypes[rule.Metric]
+		needed[obsType] = max(needed[obsType], rule.ConsecutiveReadings)
+	}
+	latest := make(map[models.ObservationType][]models.Observation, len(needed))
+	for obsType, limit := range needed {
+		readings, err := s.repo.GetObservations(patientID, obsType, time.Time{}, time.Time{}, limit)
+		if err != nil {
+			return err
+		}
+		if len(readings) > 0 && isFresh[readings[0].ID] {
+			latest[obsType] = readings
+		}
+	}
+
+	var doctorID *uint
+	doctorLoaded := false
+	for i := range rules {
+		rule := &rules[i]
+		readings := latest[alertMetricTypes[rule.Metric]]
+		if len(readings) < rule.ConsecutiveReadings {
+			continue
+		}
+		breached := true
+		for _, o := range readings[:rule.ConsecutiveReadings] {
+			if !breachesThreshold(rule, metricValue(rule.Metric, o)) {
+				breached = false
+				break
+			}
+		}
+		if !breached {
+			continue
+		}
+
+		if !doctorLoaded {
+			if doctorID, err = s.responsibleDoctorID(patientID); err != nil {
+				return err
+			}
+			doctorLoaded = true
+		}
+		trigger := readings[0]
+		value := metricValue(rule.Metric, trigger)
+		message := fmt.Sprintf("%s: %s %g %s %s %g", rule.Name, strings.ReplaceAll(string(rule.Metric), "_", " "),
+			value, trigger.Unit, alertOperatorSymbols[rule.Operator], rule.Threshold)
+		if rule.ConsecutiveReadings > 1 {
+			message += fmt.Sprintf(" for %d consecutive readings", rule.ConsecutiveReadings)
+		}
+		// A rule with an unresolved alert for the patient does not raise another one
+		if _, err := s.repo.CreateAlert(&models.Alert{
+			RuleID:        rule.ID,
+			PatientID:     patientID,
+			ObservationID: &trigger.ID,
+			DoctorID:      doctorID,
+			Value:         value,
+			Message:       message,
+			Status:        models.AlertOpen,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// responsibleDoctorID returns the doctor of the patient's latest completed appointment, falling back to the
+// latest one still to come. Cancelled and missed appointments say nothing about who treats the patient, without
+// any other appointment it returns nil and the alert is left to the admins to triage.
+func (s *medicalService) responsibleDoctorID(patientID uint) (*uint, error) {
+	for _, statuses := range [][]models.AppointmentStatus{{models.StatusCompleted}, models.ActiveAppointmentStatuses} {
+		appt, err := s.repo.GetLatestAppointment(patientID, statuses)
+		if err != nil {
+			return nil, err
+		}
+		if appt != nil {
+			return &appt.DoctorID, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetAlerts lists alerts, newest first
+func (s *medicalService) GetAlerts(filter repository.AlertFilter) ([]models.Alert, error) {
+	if filter.Status != "" && filter.Status != models.AlertOpen && filter.Status != models.AlertAcknowledged && filter.Status != models.AlertResolved {
+		return nil, &ValidationError{Reason: fmt.Sprintf("unknown alert status %q", filter.Status)}
+	}
+	return s.repo.GetAlerts(filter)
+}
+
+func (s *medicalService) GetAlert(id uint) (*models.Alert, error) {
+	return s.repo.GetAlert(id)
+}
+
+// AcknowledgeAlert records that a doctor has seen an open alert
+func (s *medicalService) AcknowledgeAlert(id, userID uint) (*models.Alert, error) {
+	alert, err := s.repo.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if alert.Status != models.AlertOpen {
+		return nil, &ConflictError{Reason: fmt.Sprintf("alert is already %s", alert.Status)}
+	}
+
+	now := time.Now()
+	alert.Status = models.AlertAcknowledged
+	alert.AcknowledgedByID = &userID
+	alert.AcknowledgedAt = &now
+	return s.updateAlertStatus(alert, models.AlertOpen)
+}
+
+// ResolveAlert closes an alert; an open alert is acknowledged by the same user on the way.
+// Once resolved, the rule can raise a new alert for the patient.
+func (s *medicalService) ResolveAlert(id, userID uint) (*models.Alert, error) {
+	alert, err := s.repo.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if alert.Status == models.AlertResolved {
+		return nil, &ConflictError{Reason: "alert is already resolved"}
+	}
+
+	from := alert.Status
+	now := time.Now()
+	if alert.AcknowledgedByID == nil {
+		alert.AcknowledgedByID = &userID
+		alert.AcknowledgedAt = &now
+	}
+	alert.Status = models.AlertResolved
+	alert.ResolvedByID = &userID
+	alert.ResolvedAt = &now
+	return s.updateAlertStatus(alert, from)
+}
+
+func (s *medicalService) updateAlertStatus(alert *models.Alert, from models.AlertStatus) (*models.Alert, error) {
+	if err := s.repo.UpdateAlertStatus(alert, from); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, &ConflictError{Reason: "alert was updated concurrently"}
+		}
+		return nil, err
+	}
+	return alert, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// appointmentRepository only serves the latest appointment of a patient, any other call panics
+type appointmentRepository struct {
+	repository.MedicalRepository
+	appointments []models.Appointment
+}
+
+func (r *appointmentRepository) GetLatestAppointment(patientID uint, statuses []models.AppointmentStatus) (*models.Appointment, error) {
+	var latest *models.Appointment
+	for i, a := range r.appointments {
+		if a.PatientID == patientID && slices.Contains(statuses, a.Status) && (latest == nil || a.AppointmentDate.After(latest.AppointmentDate)) {
+			latest = &r.appointments[i]
+		}
+	}
+	return latest, nil
+}
+
+func TestResponsibleDoctorID(t *testing.T) {
+	now := time.Now()
+	appt := func(doctorID uint, status models.AppointmentStatus, days int) models.Appointment {
+		return models.Appointment{PatientID: 1, DoctorID: doctorID, Status: status, AppointmentDate: now.AddDate(0, 0, days)}
+	}
+
+	tests := []struct {
+		name         string
+		appointments []models.Appointment
+		want         uint // 0 when the alert is left to the admins
+	}{
+		{
+			name:         "latest completed appointment",
+			appointments: []models.Appointment{appt(1, models.StatusCompleted, -30), appt(2, models.StatusCompleted, -7), appt(3, models.StatusScheduled, 7)},
+			want:         2,
+		},
+		{
+			name:         "upcoming appointment without a completed one",
+			appointments: []models.Appointment{appt(3, models.StatusScheduled, 7), appt(4, models.StatusCancelled, 14)},
+			want:         3,
+		},
+		{
+			name:         "cancelled and missed appointments are ignored",
+			appointments: []models.Appointment{appt(1, models.StatusCompleted, -30), appt(5, models.StatusNoShow, -2), appt(6, models.StatusCancelled, -1)},
+			want:         1,
+		},
+		{
+			name:         "only cancelled and missed appointments",
+			appointments: []models.Appointment{appt(5, models.StatusNoShow, -2), appt(6, models.StatusCancelled, -1)},
+		},
+		{
+			name:         "appointments of another patient",
+			appointments: []models.Appointment{{PatientID: 2, DoctorID: 7, Status: models.StatusCompleted, AppointmentDate: now}},
+		},
+		{name: "no appointment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &medicalService{repo: &appointmentRepository{appointments: tt.appointments}}
+			got, err := s.responsibleDoctorID(1)
+			if err != nil {
+				t.Fatalf("responsibleDoctorID() error = %v", err)
+			}
+			switch {
+			case tt.want == 0 && got != nil:
+				t.Errorf("got doctor %d, want none", *got)
+			case tt.want != 0 && (got == nil || *got != tt.want):
+				t.Errorf("got doctor %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"gorm.io/gorm"
+)
+
+// validatePatientAllergy normalizes the substance so it can be matched against drug ingredients and classes
+func validatePatientAllergy(a *models.PatientAllergy) error {
+	a.Substance = catalog.NormalizeIngredient(a.Substance)
+	if a.Substance == "" {
+		return &ValidationError{Reason: "substance is required"}
+	}
+	if a.Kind == "" {
+		a.Kind = models.AllergyKindAllergy
+	}
+	if a.Kind != models.AllergyKindAllergy && a.Kind != models.AllergyKindIntolerance {
+		return &ValidationError{Reason: fmt.Sprintf("unknown allergy kind %q", a.Kind)}
+	}
+	switch a.Severity {
+	case models.AllergyMild, models.AllergyModerate, models.AllergySevere:
+	default:
+		return &ValidationError{Reason: fmt.Sprintf("unknown allergy severity %q", a.Severity)}
+	}
+	a.Reaction = strings.TrimSpace(a.Reaction)
+	return nil
+}
+
+func (s *medicalService) GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error) {
+	return s.repo.GetPatientAllergies(patientID)
+}
+
+// patientAllergy loads an allergy and makes sure it belongs to the patient in the URL
+func (s *medicalService) patientAllergy(patientID, allergyID uint) (*models.PatientAllergy, error) {
+	allergy, err := s.repo.GetPatientAllergy(allergyID)
+	if err != nil {
+		return nil, err
+	}
+	if allergy.PatientID != patientID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return allergy, nil
+}
+
+func (s *medicalService) CreatePatientAllergy(patientID, doctorID uint, allergy *models.PatientAllergy) error {
+	if err := validatePatientAllergy(allergy); err != nil {
+		return err
+	}
+	if _, err := s.repo.GetPatientByID(patientID); err != nil {
+		return err
+	}
+
+	allergy.ID = 0
+	allergy.PatientID = patientID
+	allergy.VerifiedByID = doctorID
+	if err := s.repo.CreatePatientAllergy(allergy); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return &ConflictError{Reason: "an allergy to " + allergy.Substance + " is already recorded"}
+		}
+		return err
+	}
+	created, err := s.repo.GetPatientAllergy(allergy.ID)
+	if err != nil {
+		return err
+	}
+	*allergy = *created
+	return nil
+}
+
+// UpdatePatientAllergy replaces the details of an allergy; the updating doctor becomes its verifier
+func (s *medicalService) UpdatePatientAllergy(patientID, allergyID, doctorID uint, allergy *models.PatientAllergy) error {
+	existing, err := s.patientAllergy(patientID, allergyID)
+	if err != nil {
+		return err
+	}
+	if err := validatePatientAllergy(allergy); err != nil {
+		return err
+	}
+
+	existing.Substance = allergy.Substance
+	existing.Kind = allergy.Kind
+	existing.Reaction = allergy.Reaction
+	existing.Severity = allergy.Severity
+	existing.VerifiedByID = doctorID
+	if err := s.repo.UpdatePatientAllergy(existing); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return &ConflictError{Reason: "an allergy to " + existing.Substance + " is already recorded"}
+		}
+		return err
+	}
+	updated, err := s.repo.GetPatientAllergy(existing.ID)
+	if err != nil {
+		return err
+	}
+	*allergy = *updated
+	return nil
+}
+
+func (s *medicalService) DeletePatientAllergy(patientID, allergyID uint) error {
+	if _, err := s.patientAllergy(patientID, allergyID); err != nil {
+		return err
+	}
+	return s.repo.DeletePatientAllergy(allergyID)
+}
+
+// nameTokens splits a medication or substance name into lower case words
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// mentionsSubstance reports whether the words of the substance appear, in order, as whole words of the name.
+// A mention followed by "free", as in "Lactose-free syrup", says the product does not contain the substance.
+func mentionsSubstance(name, substance []string) bool {
+	if len(substance) == 0 {
+		return false
+	}
+	for i := 0; i+len(substance) <= len(name); i++ {
+		end := i + len(substance)
+		if slices.Equal(name[i:end], substance) && (end == len(name) || name[end] != "free") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllergies matches the new medications against the patient's recorded allergies, by the ingredient
+// or drug class of catalog drugs, or by the substance appearing as whole words of the medication name.
+// Allergies block prescribing and intolerances warn.
+func (s *medicalService) checkAllergies(patientID uint, meds []models.Prescription, drugs []*models.Drug) ([]PrescribingWarning, error) {
+	if len(meds) == 0 {
+		return nil, nil
+	}
+	allergies, err := s.repo.GetPatientAllergies(patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []PrescribingWarning
+	for i, m := range meds {
+		name := nameTokens(m.Medication)
+		for _, a := range allergies {
+			coded := false
+			if drug := drugs[i]; drug != nil {
+				coded = a.Substance == drug.Ingredient || (drug.DrugClass != "" && a.Substance == drug.DrugClass)
+			}
+			if !coded && !mentionsSubstance(name, nameTokens(a.Substance)) {
+				continue
+			}
+
+			message := fmt.Sprintf("patient has a recorded %s %s to %s", a.Severity, a.Kind, a.Substance)
+			if a.Reaction != "" {
+				message += " (" + a.Reaction + ")"
+			}
+			if !coded {
+				message += ", matched by the medication name only"
+			}
+			warnings = append(warnings, PrescribingWarning{
+				Kind:        string(a.Kind),
+				Severity:    string(a.Severity),
+				Medication:  m.Medication,
+				Conflicting: a.Substance,
+				Message:     m.Medication + ": " + message,
+				Blocking:    a.Kind == models.AllergyKindAllergy,
+			})
+		}
+	}
+	return warnings, nil
+}
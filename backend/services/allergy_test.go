@@ -0,0 +1,163 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// allergyRepository only serves the allergies of a patient, any other call panics
+type allergyRepository struct {
+	repository.MedicalRepository
+	allergies []models.PatientAllergy
+}
+
+func (r *allergyRepository) GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error) {
+	var out []models.PatientAllergy
+	for _, a := range r.allergies {
+		if a.PatientID == patientID {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func TestCheckAllergies(t *testing.T) {
+	amoxicillin := &models.Drug{Code: "AMX500", Name: "Amoxicillin 500mg", Ingredient: "amoxicillin", DrugClass: "penicillin"}
+	ibuprofen := &models.Drug{Code: "IBU400", Name: "Ibuprofen 400mg", Ingredient: "ibuprofen", DrugClass: "nsaid"}
+
+	allergy := func(substance string, kind models.AllergyKind) models.PatientAllergy {
+		return models.PatientAllergy{PatientID: 1, Substance: substance, Kind: kind, Severity: models.AllergySevere}
+	}
+
+	tests := []struct {
+		name         string
+		allergies    []models.PatientAllergy
+		medication   string
+		drug         *models.Drug
+		wantWarnings int
+		wantBlocking bool
+		wantNameOnly bool
+	}{
+		{
+			name:         "allergy to the ingredient of a catalog drug",
+			allergies:    []models.PatientAllergy{allergy("amoxicillin", models.AllergyKindAllergy)},
+			medication:   "Amoxicillin 500mg",
+			drug:         amoxicillin,
+			wantWarnings: 1,
+			wantBlocking: true,
+		},
+		{
+			name:         "allergy to the class of a catalog drug",
+			allergies:    []models.PatientAllergy{allergy("penicillin", models.AllergyKindAllergy)},
+			medication:   "Amoxicillin 500mg",
+			drug:         amoxicillin,
+			wantWarnings: 1,
+			wantBlocking: true,
+		},
+		{
+			name:         "intolerance to the ingredient of a catalog drug",
+			allergies:    []models.PatientAllergy{allergy("amoxicillin", models.AllergyKindIntolerance)},
+			medication:   "Amoxicillin 500mg",
+			drug:         amoxicillin,
+			wantWarnings: 1,
+		},
+		{
+			name:         "free text medication naming the allergen",
+			allergies:    []models.PatientAllergy{allergy("penicillin", models.AllergyKindAllergy)},
+			medication:   "Penicillin V",
+			wantWarnings: 1,
+			wantBlocking: true,
+			wantNameOnly: true,
+		},
+		{
+			name:         "free text medication naming a multi word allergen",
+			allergies:    []models.PatientAllergy{allergy("penicillin v", models.AllergyKindAllergy)},
+			medication:   "Penicillin V potassium 250mg",
+			wantWarnings: 1,
+			wantBlocking: true,
+			wantNameOnly: true,
+		},
+		{
+			name:         "free text medication naming an intolerance",
+			allergies:    []models.PatientAllergy{allergy("lactose", models.AllergyKindIntolerance)},
+			medication:   "Lactose syrup",
+			wantWarnings: 1,
+			wantNameOnly: true,
+		},
+		{
+			name:       "product free of the intolerance",
+			allergies:  []models.PatientAllergy{allergy("lactose", models.AllergyKindIntolerance)},
+			medication: "Lactose-free syrup",
+		},
+		{
+			name:       "product free of the allergen",
+			allergies:  []models.PatientAllergy{allergy("penicillin", models.AllergyKindAllergy)},
+			medication: "Penicillin free azithromycin",
+		},
+		{
+			name:         "allergen named again after a free mention",
+			allergies:    []models.PatientAllergy{allergy("penicillin", models.AllergyKindAllergy)},
+			medication:   "Penicillin-free blister, penicillin G",
+			wantWarnings: 1,
+			wantBlocking: true,
+			wantNameOnly: true,
+		},
+		{
+			name:       "allergen only part of a word",
+			allergies:  []models.PatientAllergy{allergy("iron", models.AllergyKindAllergy)},
+			medication: "Spironolactone 25mg",
+		},
+		{
+			name:       "words of the allergen out of order",
+			allergies:  []models.PatientAllergy{allergy("penicillin v", models.AllergyKindAllergy)},
+			medication: "V-Penicillin",
+		},
+		{
+			name:       "unrelated catalog drug",
+			allergies:  []models.PatientAllergy{allergy("penicillin", models.AllergyKindAllergy)},
+			medication: "Ibuprofen 400mg",
+			drug:       ibuprofen,
+		},
+		{
+			name:       "allergy of another patient",
+			allergies:  []models.PatientAllergy{{PatientID: 2, Substance: "amoxicillin", Kind: models.AllergyKindAllergy, Severity: models.AllergySevere}},
+			medication: "Amoxicillin 500mg",
+			drug:       amoxicillin,
+		},
+		{
+			name: "allergy and intolerance matching the same drug",
+			allergies: []models.PatientAllergy{
+				allergy("penicillin", models.AllergyKindAllergy),
+				allergy("amoxicillin", models.AllergyKindIntolerance),
+			},
+			medication:   "Amoxicillin 500mg",
+			drug:         amoxicillin,
+			wantWarnings: 2,
+			wantBlocking: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &medicalService{repo: &allergyRepository{allergies: tt.allergies}}
+			meds := []models.Prescription{{Medication: tt.medication}}
+			warnings, err := s.checkAllergies(1, meds, []*models.Drug{tt.drug})
+			if err != nil {
+				t.Fatalf("checkAllergies() error = %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Fatalf("got %d warnings, want %d: %+v", len(warnings), tt.wantWarnings, warnings)
+			}
+			if blocked := blockingWarnings(warnings) != nil; blocked != tt.wantBlocking {
+				t.Errorf("blocking = %v, want %v", blocked, tt.wantBlocking)
+			}
+			for _, w := range warnings {
+				if nameOnly := strings.Contains(w.Message, "matched by the medication name only"); nameOnly != tt.wantNameOnly {
+					t.Errorf("message %q, want name only match = %v", w.Message, tt.wantNameOnly)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// appointmentTransitions lists the statuses each appointment status may move to.
+// Completed, Cancelled and NoShow are terminal.
+var appointmentTransitions = map[models.AppointmentStatus][]models.AppointmentStatus{
+	models.StatusScheduled:  {models.StatusCheckedIn, models.StatusInProgress, models.StatusCompleted, models.StatusCancelled, models.StatusNoShow},
+	models.StatusCheckedIn:  {models.StatusInProgress, models.StatusCompleted, models.StatusCancelled},
+	models.StatusInProgress: {models.StatusCompleted},
+}
+
+func isKnownAppointmentStatus(status models.AppointmentStatus) bool {
+	switch status {
+	case models.StatusScheduled, models.StatusCheckedIn, models.StatusInProgress,
+		models.StatusCompleted, models.StatusCancelled, models.StatusNoShow:
+		return true
+	}
+	return false
+}
+
+func canTransitionAppointment(from, to models.AppointmentStatus) bool {
+	for _, next := range appointmentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionAppointment validates and applies a status change, recording it in the status event trail.
+// actorID is nil for transitions made by the system.
+func (s *medicalService) transitionAppointment(appt *models.Appointment, to models.AppointmentStatus, actorID *uint, reason string) error {
+	if !isKnownAppointmentStatus(to) {
+		return &ValidationError{Reason: fmt.Sprintf("unknown appointment status %q", to)}
+	}
+	if !canTransitionAppointment(appt.Status, to) {
+		return &ConflictError{Reason: fmt.Sprintf("appointment cannot move from %s to %s", appt.Status, to)}
+	}
+
+	event := &models.AppointmentStatusEvent{
+		AppointmentID: appt.ID,
+		FromStatus:    appt.Status,
+		ToStatus:      to,
+		ActorID:       actorID,
+		Reason:        reason,
+	}
+	if err := s.repo.UpdateAppointmentStatus(appt, event); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return &ConflictError{Reason: "appointment status was changed concurrently, reload and try again"}
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *medicalService) UpdateAppointmentStatus(apptID, actorID uint, status, reason string) (*models.Appointment, error) {
+	appt, err := s.repo.GetAppointmentByID(apptID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.transitionAppointment(appt, models.AppointmentStatus(status), &actorID, reason); err != nil {
+		return nil, err
+	}
+	return appt, nil
+}
+
+func (s *medicalService) GetAppointmentStatusEvents(apptID uint) ([]models.AppointmentStatusEvent, error) {
+	if _, err := s.repo.GetAppointmentByID(apptID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAppointmentStatusEvents(apptID)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// ConsultationRecord is the versioned view of a consultation: the untouched original,
+// every amendment filed since, and the content of the latest version.
+type ConsultationRecord struct {
+	Consultation   models.Consultation            `json:"consultation"`
+	Amendments     []models.ConsultationAmendment `json:"amendments"`
+	CurrentVersion int                            `json:"current_version"`
+	Diagnosis      string                         `json:"diagnosis"`
+	Notes          string                         `json:"notes"`
+	SOAP           models.SOAPNote                `json:"soap"`
+	Diagnoses      []models.ConsultationDiagnosis `json:"diagnoses"`
+}
+
+// AmendmentInput is the content of a new consultation version. Fields left nil are carried over
+// from the current version; the coded diagnoses are replaced only when a primary code is given.
+type AmendmentInput struct {
+	Diagnosis               *string          `json:"diagnosis"`
+	Notes                   *string          `json:"notes"`
+	SOAP                    *models.SOAPNote `json:"soap"`
+	PrimaryDiagnosisCode    *string          `json:"primary_diagnosis_code"`
+	SecondaryDiagnosisCodes []string         `json:"secondary_diagnosis_codes"`
+	Reason                  string           `json:"reason"`
+}
+
+func (s *medicalService) consultationRecord(cons *models.Consultation) (*ConsultationRecord, error) {
+	amendments, err := s.repo.GetConsultationAmendments(cons.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &ConsultationRecord{
+		Consultation:   *cons,
+		Amendments:     amendments,
+		CurrentVersion: 1,
+		Diagnosis:      cons.Diagnosis,
+		Notes:          cons.Notes,
+		SOAP:           cons.SOAP,
+		Diagnoses:      cons.Diagnoses,
+	}
+	if len(amendments) > 0 {
+		latest := amendments[len(amendments)-1]
+		record.CurrentVersion = latest.Version
+		record.Diagnosis = latest.Diagnosis
+		record.Notes = latest.Notes
+		record.SOAP = latest.SOAP
+		record.Diagnoses = latest.Diagnoses
+	}
+	return record, nil
+}
+
+func (s *medicalService) GetConsultation(consID uint) (*ConsultationRecord, error) {
+	cons, err := s.repo.GetConsultationByID(consID)
+	if err != nil {
+		return nil, err
+	}
+	return s.consultationRecord(cons)
+}
+
+func (s *medicalService) GetAppointmentConsultation(apptID uint) (*ConsultationRecord, error) {
+	cons, err := s.repo.GetConsultationByAppointment(apptID)
+	if err != nil {
+		return nil, err
+	}
+	return s.consultationRecord(cons)
+}
+
+// AmendConsultation files a new version of the consultation; the original and earlier versions stay untouched.
+func (s *medicalService) AmendConsultation(consID, authorID uint, input AmendmentInput) (*ConsultationRecord, error) {
+	if strings.TrimSpace(input.Reason) == "" {
+		return nil, &ValidationError{Reason: "a reason is required to amend a consultation"}
+	}
+	if input.PrimaryDiagnosisCode == nil && len(input.SecondaryDiagnosisCodes) > 0 {
+		return nil, &ValidationError{Reason: "secondary diagnoses require a primary diagnosis"}
+	}
+
+	cons, err := s.repo.GetConsultationByID(consID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.consultationRecord(cons)
+	if err != nil {
+		return nil, err
+	}
+
+	amendment := &models.ConsultationAmendment{
+		ConsultationID: consID,
+		Diagnosis:      current.Diagnosis,
+		Notes:          current.Notes,
+		SOAP:           current.SOAP,
+		AuthorID:       authorID,
+		Reason:         input.Reason,
+	}
+	if input.Diagnosis != nil {
+		amendment.Diagnosis = *input.Diagnosis
+	}
+	if input.Notes != nil {
+		amendment.Notes = *input.Notes
+	}
+	if input.SOAP != nil {
+		tmpl, err := s.resolveNoteTemplate(cons.TemplateID, &cons.Appointment.Doctor)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateSOAPNote(input.SOAP, tmpl); err != nil {
+			return nil, err
+		}
+		amendment.SOAP = *input.SOAP
+	}
+
+	// Every version carries its complete set of codes, so the current one never depends on earlier versions
+	var diagnoses []models.ConsultationDiagnosis
+	if input.PrimaryDiagnosisCode != nil {
+		if diagnoses, err = s.buildConsultationDiagnoses(*input.PrimaryDiagnosisCode, input.SecondaryDiagnosisCodes); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, d := range current.Diagnoses {
+			diagnoses = append(diagnoses, models.ConsultationDiagnosis{Code: d.Code, IsPrimary: d.IsPrimary})
+		}
+	}
+
+	err = s.uow.Transaction(func(tx repository.Repositories) error {
+		if err := tx.Medical.CreateConsultationAmendment(amendment); err != nil {
+			return err
+		}
+		for i := range diagnoses {
+			diagnoses[i].ConsultationID = consID
+			diagnoses[i].AmendmentID = &amendment.ID
+		}
+		return tx.Medical.CreateConsultationDiagnoses(diagnoses)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, &ConflictError{Reason: "consultation was amended concurrently, reload and try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.consultationRecord(cons)
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTelemetryReadings = 500
+	maxBatchIDLength     = 64
+)
+
+// ErrInvalidDeviceCredential is returned for unknown, malformed or revoked device credentials
+var ErrInvalidDeviceCredential = errors.New("invalid device credential")
+
+// errDuplicateBatch rolls back the ingestion transaction of a batch that was already accepted
+var errDuplicateBatch = errors.New("duplicate telemetry batch")
+
+// deviceObservationTypes lists what each kind of device may report
+var deviceObservationTypes = map[models.DeviceType][]models.ObservationType{
+	models.DeviceBPCuff:        {models.ObservationBloodPressure, models.ObservationHeartRate},
+	models.DevicePulseOximeter: {models.ObservationSpO2, models.ObservationHeartRate},
+	models.DeviceThermometer:   {models.ObservationTemperature},
+	models.DeviceScale:         {models.ObservationWeight},
+	models.DeviceGlucometer:    {models.ObservationGlucose},
+}
+
+// RegisteredDevice carries the device credential; it is only ever returned when issued
+type RegisteredDevice struct {
+	models.Device
+	Token string `json:"token"`
+}
+
+// TelemetryResult acknowledges a telemetry batch. Duplicate is set when the batch was accepted before.
+type TelemetryResult struct {
+	BatchID   string            `json:"batch_id"`
+	Received  int               `json:"received"`
+	Stored    int               `json:"stored"`
+	Rejected  []RejectedReading `json:"rejected,omitempty"`
+	Duplicate bool              `json:"duplicate"`
+}
+
+// RejectedReading tells a device which reading of its batch was invalid and why
+type RejectedReading struct {
+	Index  int    `json:"index"` // position in the batch, from 0
+	Reason string `json:"reason"`
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecret returns a random secret and its hash, for credentials of which only the hash is stored
+func newSecret() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	return secret, hashSecret(secret), nil
+}
+
+// deviceToken joins the device id and secret into the credential handed to the device
+func deviceToken(deviceID uint, secret string) string {
+	return strconv.FormatUint(uint64(deviceID), 10) + "." + secret
+}
+
+func canDeviceReport(deviceType models.DeviceType, obsType models.ObservationType) bool {
+	for _, t := range deviceObservationTypes[deviceType] {
+		if t == obsType {
+			return true
+		}
+	}
+	return false
+}
+
+// patientDevice loads a device and makes sure it belongs to the patient in the URL
+func (s *medicalService) patientDevice(patientID, deviceID uint) (*models.Device, error) {
+	device, err := s.repo.GetDeviceByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.PatientID != patientID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return device, nil
+}
+
+func (s *medicalService) GetPatientDevices(patientID uint) ([]models.Device, error) {
+	return s.repo.GetDevicesByPatient(patientID)
+}
+
+// RegisterDevice registers a device to a patient and issues its credential
+func (s *medicalService) RegisterDevice(patientID, registeredByID uint, device *models.Device) (*RegisteredDevice, error) {
+	if _, ok := deviceObservationTypes[device.Type]; !ok {
+		return nil, &ValidationError{Reason: fmt.Sprintf("unknown device type %q", device.Type)}
+	}
+	if _, err := s.repo.GetPatientByID(patientID); err != nil {
+		return nil, err
+	}
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	registered := &models.Device{
+		PatientID:      patientID,
+		Type:           device.Type,
+		Label:          strings.TrimSpace(device.Label),
+		SerialNumber:   strings.TrimSpace(device.SerialNumber),
+		SecretHash:     hash,
+		RegisteredByID: registeredByID,
+	}
+	if err := s.repo.CreateDevice(registered); err != nil {
+		return nil, err
+	}
+	return &RegisteredDevice{Device: *registered, Token: deviceToken(registered.ID, secret)}, nil
+}
+
+// RotateDeviceCredential issues a new credential, the previous one stops working immediately
+func (s *medicalService) RotateDeviceCredential(patientID, deviceID uint) (*RegisteredDevice, error) {
+	device, err := s.patientDevice(patientID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.RevokedAt != nil {
+		return nil, &ConflictError{Reason: "device has been revoked, register it again instead"}
+	}
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	device.SecretHash = hash
+	if err := s.repo.UpdateDevice(device); err != nil {
+		return nil, err
+	}
+	return &RegisteredDevice{Device: *device, Token: deviceToken(device.ID, secret)}, nil
+}
+
+// RevokeDevice disables the device credential; readings already received are kept
+func (s *medicalService) RevokeDevice(patientID, deviceID uint) error {
+	device, err := s.patientDevice(patientID, deviceID)
+	if err != nil {
+		return err
+	}
+	if device.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	device.RevokedAt = &now
+	return s.repo.UpdateDevice(device)
+}
+
+// AuthenticateDevice resolves a device credential of the form "<device id>.<secret>"
+func (s *medicalService) AuthenticateDevice(token string) (*models.Device, error) {
+	idStr, secret, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || secret == "" {
+		return nil, ErrInvalidDeviceCredential
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidDeviceCredential
+	}
+
+	device, err := s.repo.GetDeviceByID(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidDeviceCredential
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(device.SecretHash)) != 1 {
+		return nil, ErrInvalidDeviceCredential
+	}
+	return device, nil
+}
+
+// checkReading validates and normalizes a reading from the device, returning why it is rejected if it is
+func checkReading(device *models.Device, r *models.Observation, now time.Time) string {
+	if !canDeviceReport(device.Type, r.Type) {
+		return fmt.Sprintf("a %s cannot report %s", device.Type, r.Type)
+	}
+	// Without the device's own timestamp a retried reading could not be told apart from a new one
+	if r.ObservedAt.IsZero() {
+		return "observed_at is required"
+	}
+	if err := prepareObservation(r, now); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// IngestTelemetry stores a batch of readings from an authenticated device. Invalid readings are
+// reported back and the rest of the batch is stored, unless none of them is valid. A batch id that
+// was accepted before is acknowledged again without storing anything, and readings the device
+// already reported in an earlier batch are skipped.
+func (s *medicalService) IngestTelemetry(device *models.Device, batchID string, readings []models.Observation) (*TelemetryResult, error) {
+	batchID = strings.TrimSpace(batchID)
+	if batchID == "" || len(batchID) > maxBatchIDLength {
+		return nil, &ValidationError{Reason: fmt.Sprintf("batch_id is required and must be at most %d characters", maxBatchIDLength)}
+	}
+	if len(readings) == 0 || len(readings) > maxTelemetryReadings {
+		return nil, &ValidationError{Reason: fmt.Sprintf("a batch must contain between 1 and %d readings", maxTelemetryReadings)}
+	}
+
+	now := time.Now()
+	from, to := now, now
+	result := &TelemetryResult{BatchID: batchID, Received: len(readings)}
+	valid := make([]models.Observation, 0, len(readings))
+	for i := range readings {
+		r := &readings[i]
+		if reason := checkReading(device, r, now); reason != "" {
+			result.Rejected = append(result.Rejected, RejectedReading{Index: i, Reason: reason})
+			continue
+		}
+		r.ID = 0
+		r.PatientID = device.PatientID
+		r.Source = models.SourceDevice
+		r.RecordedByID = nil
+		r.ConsultationID = nil
+		r.DeviceID = &device.ID
+		if r.ObservedAt.Before(from) {
+			from = r.ObservedAt
+		}
+		if r.ObservedAt.After(to) {
+			to = r.ObservedAt
+		}
+		valid = append(valid, *r)
+	}
+	// The batch is not recorded, so the device can send it again once corrected
+	if len(valid) == 0 {
+		reasons := make([]string, len(result.Rejected))
+		for i, rejected := range result.Rejected {
+			reasons[i] = fmt.Sprintf("reading %d: %s", rejected.Index+1, rejected.Reason)
+		}
+		return nil, &ValidationError{Reason: "no valid readings: " + strings.Join(reasons, "; ")}
+	}
+
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		txs := s.withRepo(tx.Medical)
+
+		batch := &models.DeviceBatch{DeviceID: device.ID, BatchID: batchID, Readings: len(readings), ReceivedAt: now}
+		if err := txs.repo.CreateDeviceBatch(batch); err != nil {
+			if errors.Is(err, repository.ErrConflict) {
+				return errDuplicateBatch
+			}
+			return err
+		}
+
+		existing, err := txs.repo.GetDeviceReadings(device.ID, from, to)
+		if err != nil {
+			return err
+		}
+		type readingKey struct {
+			obsType models.ObservationType
+			at      int64
+		}
+		seen := make(map[readingKey]bool, len(existing)+len(valid))
+		for _, o := range existing {
+			seen[readingKey{o.Type, o.ObservedAt.UnixMicro()}] = true
+		}
+		fresh := make([]models.Observation, 0, len(valid))
+		for _, r := range valid {
+			key := readingKey{r.Type, r.ObservedAt.UnixMicro()}
+			if !seen[key] {
+				seen[key] = true
+				fresh = append(fresh, r)
+			}
+		}
+
+		if err := txs.repo.CreateObservations(fresh); err != nil {
+			if errors.Is(err, repository.ErrConflict) {
+				return &ConflictError{Reason: "readings were stored concurrently, retry the batch"}
+			}
+			return err
+		}
+		result.Stored = len(fresh)
+		if err := txs.evaluateAlerts(device.PatientID, fresh); err != nil {
+			return err
+		}
+		return txs.repo.TouchDevice(device.ID, now)
+	})
+
+	// A duplicate batch aborts the transaction, so the original is looked up afterwards
+	if errors.Is(err, errDuplicateBatch) {
+		original, err := s.repo.GetDeviceBatch(device.ID, batchID)
+		if err != nil {
+			return nil, err
+		}
+		return &TelemetryResult{BatchID: batchID, Received: original.Readings, Duplicate: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
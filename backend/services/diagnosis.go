@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+const (
+	defaultDiagnosisSearchLimit = 20
+	maxDiagnosisSearchLimit     = 100
+)
+
+func (s *medicalService) SearchDiagnosisCodes(query string, limit int) ([]models.DiagnosisCode, error) {
+	query = strings.TrimSpace(query)
+	if len([]rune(query)) < 2 {
+		return nil, &ValidationError{Reason: "search query must be at least 2 characters"}
+	}
+	if limit <= 0 {
+		limit = defaultDiagnosisSearchLimit
+	}
+	if limit > maxDiagnosisSearchLimit {
+		limit = maxDiagnosisSearchLimit
+	}
+	return s.repo.SearchDiagnosisCodes(catalog.NormalizeICD10Code(query), query, limit)
+}
+
+func (s *medicalService) GetDiagnosisCode(code string) (*models.DiagnosisCode, error) {
+	return s.repo.GetDiagnosisCode(catalog.NormalizeICD10Code(code))
+}
+
+// ImportDiagnosisCodes upserts a parsed ICD-10 catalog, dropping duplicate and empty entries.
+// It returns the number of codes written.
+func (s *medicalService) ImportDiagnosisCodes(codes []models.DiagnosisCode) (int, error) {
+	seen := make(map[string]bool, len(codes))
+	unique := make([]models.DiagnosisCode, 0, len(codes))
+	for _, c := range codes {
+		if c.Code == "" || c.Description == "" || seen[c.Code] {
+			continue
+		}
+		seen[c.Code] = true
+		unique = append(unique, c)
+	}
+	if len(unique) == 0 {
+		return 0, &ValidationError{Reason: "no diagnosis codes found in the import"}
+	}
+	if err := s.repo.UpsertDiagnosisCodes(unique); err != nil {
+		return 0, err
+	}
+	return len(unique), nil
+}
+
+// buildConsultationDiagnoses validates the coded diagnoses of a consultation against the catalog
+func (s *medicalService) buildConsultationDiagnoses(primary string, secondary []string) ([]models.ConsultationDiagnosis, error) {
+	if primary == "" {
+		if len(secondary) > 0 {
+			return nil, &ValidationError{Reason: "secondary diagnoses require a primary diagnosis"}
+		}
+		return nil, nil
+	}
+
+	codes := []string{catalog.NormalizeICD10Code(primary)}
+	seen := map[string]bool{codes[0]: true}
+	for _, code := range secondary {
+		code = catalog.NormalizeICD10Code(code)
+		if seen[code] {
+			return nil, &ValidationError{Reason: fmt.Sprintf("diagnosis %s is listed more than once", code)}
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+
+	found, err := s.repo.GetDiagnosisCodesByCodes(codes)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(found))
+	for _, dc := range found {
+		known[dc.Code] = true
+	}
+
+	diagnoses := make([]models.ConsultationDiagnosis, 0, len(codes))
+	for i, code := range codes {
+		if !known[code] {
+			return nil, &ValidationError{Reason: fmt.Sprintf("unknown ICD-10 code %s", code)}
+		}
+		diagnoses = append(diagnoses, models.ConsultationDiagnosis{Code: code, IsPrimary: i == 0})
+	}
+	return diagnoses, nil
+}
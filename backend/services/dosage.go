@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+const maxPrescriptionRefills = 11
+
+var strengthUnits = map[string]bool{
+	"mg": true, "g": true, "mcg": true, "ml": true, "IU": true, "unit": true,
+	"tablet": true, "capsule": true, "drop": true, "puff": true, "patch": true, "application": true,
+}
+
+// dosageRoutes maps each route to its wording in the sig
+var dosageRoutes = map[models.DosageRoute]string{
+	models.RouteOral:        "by mouth",
+	models.RouteSublingual:  "under the tongue",
+	models.RouteTopical:     "topically",
+	models.RouteTransdermal: "on the skin",
+	models.RouteInhaled:     "by inhalation",
+	models.RouteNasal:       "in the nose",
+	models.RouteOphthalmic:  "in the eye",
+	models.RouteOtic:        "in the ear",
+	models.RouteRectal:      "rectally",
+	models.RouteIV:          "intravenously",
+	models.RouteIM:          "intramuscularly",
+	models.RouteSC:          "subcutaneously",
+}
+
+type dosageFrequency struct {
+	text         string
+	dosesPerWeek int // 0 for as-needed dosing, where the quantity cannot be derived
+}
+
+var dosageFrequencies = map[models.DosageFrequency]dosageFrequency{
+	models.FrequencyOnceDaily:       {"once daily", 7},
+	models.FrequencyTwiceDaily:      {"twice daily", 14},
+	models.FrequencyThreeTimesDaily: {"three times daily", 21},
+	models.FrequencyFourTimesDaily:  {"four times daily", 28},
+	models.FrequencyEvery4Hours:     {"every 4 hours", 42},
+	models.FrequencyEvery6Hours:     {"every 6 hours", 28},
+	models.FrequencyEvery8Hours:     {"every 8 hours", 21},
+	models.FrequencyEvery12Hours:    {"every 12 hours", 14},
+	models.FrequencyAtBedtime:       {"at bedtime", 7},
+	models.FrequencyWeekly:          {"once weekly", 1},
+	models.FrequencyAsNeeded:        {"as needed", 0},
+}
+
+func hasStructuredDosage(p *models.Prescription) bool {
+	return p.Strength != 0 || p.StrengthUnit != "" || p.Route != "" || p.Frequency != "" ||
+		p.DurationDays != 0 || p.Quantity != 0
+}
+
+// preparePrescription validates a prescription as sent by the doctor. With structured dosage
+// fields it derives a missing quantity and renders the sig into Dosage; without them the free-text
+// Dosage is kept as is, for clients that do not send structured fields yet.
+func preparePrescription(p *models.Prescription) error {
+	// Fields owned by the server are never taken from the request
+	p.ID = 0
+	p.RenewedFromID = nil
+	p.Dispenses = nil
+	p.Drug = nil
+
+	p.Medication = strings.TrimSpace(p.Medication)
+	if p.Medication == "" {
+		return &ValidationError{Reason: "medication is required"}
+	}
+	if p.Refills < 0 || p.Refills > maxPrescriptionRefills {
+		return &ValidationError{Reason: fmt.Sprintf("%s: refills must be between 0 and %d", p.Medication, maxPrescriptionRefills)}
+	}
+
+	if !hasStructuredDosage(p) {
+		p.Dosage = strings.TrimSpace(p.Dosage)
+		if p.Dosage == "" {
+			return &ValidationError{Reason: p.Medication + ": dosage instructions are required"}
+		}
+		return nil
+	}
+
+	if p.Strength <= 0 {
+		return &ValidationError{Reason: p.Medication + ": strength must be a positive number"}
+	}
+	if !strengthUnits[p.StrengthUnit] {
+		return &ValidationError{Reason: fmt.Sprintf("%s: unknown strength unit %q", p.Medication, p.StrengthUnit)}
+	}
+	if _, ok := dosageRoutes[p.Route]; !ok {
+		return &ValidationError{Reason: fmt.Sprintf("%s: unknown route %q", p.Medication, p.Route)}
+	}
+	freq, ok := dosageFrequencies[p.Frequency]
+	if !ok {
+		return &ValidationError{Reason: fmt.Sprintf("%s: unknown frequency %q", p.Medication, p.Frequency)}
+	}
+	if p.DurationDays <= 0 {
+		return &ValidationError{Reason: p.Medication + ": duration must be at least one day"}
+	}
+	if p.Quantity < 0 {
+		return &ValidationError{Reason: p.Medication + ": quantity cannot be negative"}
+	}
+	if p.Quantity == 0 {
+		if freq.dosesPerWeek == 0 {
+			return &ValidationError{Reason: p.Medication + ": quantity is required for as-needed dosing"}
+		}
+		p.Quantity = (freq.dosesPerWeek*p.DurationDays + 6) / 7
+	}
+
+	p.Dosage = renderSig(p)
+	return nil
+}
+
+// renderSig writes the structured dosage as instructions, e.g. "500 mg by mouth twice daily for 7 days"
+func renderSig(p *models.Prescription) string {
+	days := "days"
+	if p.DurationDays == 1 {
+		days = "day"
+	}
+	return fmt.Sprintf("%s %s %s %s for %d %s",
+		strconv.FormatFloat(p.Strength, 'f', -1, 64), p.StrengthUnit,
+		dosageRoutes[p.Route], dosageFrequencies[p.Frequency].text,
+		p.DurationDays, days)
+}
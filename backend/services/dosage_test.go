@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// structured returns a valid structured prescription to be varied by the tests
+func structured(frequency models.DosageFrequency, durationDays, quantity int) models.Prescription {
+	return models.Prescription{
+		Medication:   "Amoxicillin",
+		Strength:     500,
+		StrengthUnit: "mg",
+		Route:        models.RouteOral,
+		Frequency:    frequency,
+		DurationDays: durationDays,
+		Quantity:     quantity,
+	}
+}
+
+func TestPreparePrescriptionFrequencies(t *testing.T) {
+	tests := []struct {
+		frequency    models.DosageFrequency
+		quantity     int // sent by the doctor, 0 to derive it
+		wantQuantity int
+		wantSig      string
+	}{
+		{frequency: models.FrequencyOnceDaily, wantQuantity: 10, wantSig: "500 mg by mouth once daily for 10 days"},
+		{frequency: models.FrequencyTwiceDaily, wantQuantity: 20, wantSig: "500 mg by mouth twice daily for 10 days"},
+		{frequency: models.FrequencyThreeTimesDaily, wantQuantity: 30, wantSig: "500 mg by mouth three times daily for 10 days"},
+		{frequency: models.FrequencyFourTimesDaily, wantQuantity: 40, wantSig: "500 mg by mouth four times daily for 10 days"},
+		{frequency: models.FrequencyEvery4Hours, wantQuantity: 60, wantSig: "500 mg by mouth every 4 hours for 10 days"},
+		{frequency: models.FrequencyEvery6Hours, wantQuantity: 40, wantSig: "500 mg by mouth every 6 hours for 10 days"},
+		{frequency: models.FrequencyEvery8Hours, wantQuantity: 30, wantSig: "500 mg by mouth every 8 hours for 10 days"},
+		{frequency: models.FrequencyEvery12Hours, wantQuantity: 20, wantSig: "500 mg by mouth every 12 hours for 10 days"},
+		{frequency: models.FrequencyAtBedtime, wantQuantity: 10, wantSig: "500 mg by mouth at bedtime for 10 days"},
+		// Partial weeks round up, so the patient does not run out before the end
+		{frequency: models.FrequencyWeekly, wantQuantity: 2, wantSig: "500 mg by mouth once weekly for 10 days"},
+		{frequency: models.FrequencyAsNeeded, quantity: 12, wantQuantity: 12, wantSig: "500 mg by mouth as needed for 10 days"},
+	}
+	covered := make(map[models.DosageFrequency]bool)
+	for _, tt := range tests {
+		covered[tt.frequency] = true
+		t.Run(string(tt.frequency), func(t *testing.T) {
+			p := structured(tt.frequency, 10, tt.quantity)
+			if err := preparePrescription(&p); err != nil {
+				t.Fatalf("preparePrescription() error = %v", err)
+			}
+			if p.Quantity != tt.wantQuantity {
+				t.Errorf("quantity = %d, want %d", p.Quantity, tt.wantQuantity)
+			}
+			if p.Dosage != tt.wantSig {
+				t.Errorf("dosage = %q, want %q", p.Dosage, tt.wantSig)
+			}
+		})
+	}
+	for frequency := range dosageFrequencies {
+		if !covered[frequency] {
+			t.Errorf("frequency %s is not tested", frequency)
+		}
+	}
+}
+
+func TestPreparePrescription(t *testing.T) {
+	renewedFrom := uint(3)
+
+	t.Run("explicit quantity is kept", func(t *testing.T) {
+		p := structured(models.FrequencyTwiceDaily, 7, 30)
+		if err := preparePrescription(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Quantity != 30 {
+			t.Errorf("quantity = %d, want 30", p.Quantity)
+		}
+	})
+
+	t.Run("free text dosage without structured fields", func(t *testing.T) {
+		p := models.Prescription{ID: 9, RenewedFromID: &renewedFrom, Medication: "  Paracetamol ", Dosage: " 1 tablet when needed ", Refills: 2}
+		if err := preparePrescription(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Medication != "Paracetamol" || p.Dosage != "1 tablet when needed" || p.Quantity != 0 {
+			t.Errorf("unexpected prescription %+v", p)
+		}
+		if p.ID != 0 || p.RenewedFromID != nil {
+			t.Errorf("fields owned by the server were taken from the request: %+v", p)
+		}
+	})
+
+	t.Run("structured fields replace a free text dosage", func(t *testing.T) {
+		p := structured(models.FrequencyOnceDaily, 1, 0)
+		p.Dosage = "whatever the client sent"
+		if err := preparePrescription(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Dosage != "500 mg by mouth once daily for 1 day" {
+			t.Errorf("dosage = %q", p.Dosage)
+		}
+	})
+}
+
+func TestPreparePrescriptionValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *models.Prescription)
+	}{
+		{name: "without medication", modify: func(p *models.Prescription) { p.Medication = "  " }},
+		{name: "negative refills", modify: func(p *models.Prescription) { p.Refills = -1 }},
+		{name: "too many refills", modify: func(p *models.Prescription) { p.Refills = maxPrescriptionRefills + 1 }},
+		{name: "without any dosage", modify: func(p *models.Prescription) { *p = models.Prescription{Medication: "Paracetamol", Dosage: " "} }},
+		{name: "without strength", modify: func(p *models.Prescription) { p.Strength = 0 }},
+		{name: "negative strength", modify: func(p *models.Prescription) { p.Strength = -5 }},
+		{name: "unknown unit", modify: func(p *models.Prescription) { p.StrengthUnit = "kg" }},
+		{name: "unit in the wrong case", modify: func(p *models.Prescription) { p.StrengthUnit = "MG" }},
+		{name: "without unit", modify: func(p *models.Prescription) { p.StrengthUnit = "" }},
+		{name: "unknown route", modify: func(p *models.Prescription) { p.Route = "intrathecal" }},
+		{name: "unknown frequency", modify: func(p *models.Prescription) { p.Frequency = "Q2H" }},
+		{name: "without duration", modify: func(p *models.Prescription) { p.DurationDays = 0 }},
+		{name: "negative quantity", modify: func(p *models.Prescription) { p.Quantity = -1 }},
+		{name: "as needed without quantity", modify: func(p *models.Prescription) { p.Frequency, p.Quantity = models.FrequencyAsNeeded, 0 }},
+		{name: "a structured field alone", modify: func(p *models.Prescription) {
+			*p = models.Prescription{Medication: "Paracetamol", Dosage: "1 tablet", Route: models.RouteOral}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := structured(models.FrequencyTwiceDaily, 7, 0)
+			tt.modify(&p)
+			var validation *ValidationError
+			if err := preparePrescription(&p); !errors.As(err, &validation) {
+				t.Errorf("got error %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestRenderSig(t *testing.T) {
+	tests := []struct {
+		name string
+		p    models.Prescription
+		want string
+	}{
+		{
+			name: "fractional strength",
+			p:    models.Prescription{Strength: 0.5, StrengthUnit: "mg", Route: models.RouteSublingual, Frequency: models.FrequencyAsNeeded, DurationDays: 30},
+			want: "0.5 mg under the tongue as needed for 30 days",
+		},
+		{
+			name: "single day",
+			p:    models.Prescription{Strength: 1, StrengthUnit: "drop", Route: models.RouteOphthalmic, Frequency: models.FrequencyFourTimesDaily, DurationDays: 1},
+			want: "1 drop in the eye four times daily for 1 day",
+		},
+		{
+			name: "units of insulin",
+			p:    models.Prescription{Strength: 10, StrengthUnit: "IU", Route: models.RouteSC, Frequency: models.FrequencyAtBedtime, DurationDays: 90},
+			want: "10 IU subcutaneously at bedtime for 90 days",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderSig(&tt.p); got != tt.want {
+				t.Errorf("renderSig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+const (
+	defaultDrugSearchLimit = 20
+	maxDrugSearchLimit     = 100
+)
+
+// PrescribingWarning is raised when a new prescription interacts with another medication of the patient
+// or with one of their recorded allergies
+type PrescribingWarning struct {
+	Kind        string `json:"kind"` // interaction, duplicate, allergy, intolerance
+	Severity    string `json:"severity"`
+	Medication  string `json:"medication"`
+	Conflicting string `json:"conflicting"` // the other medication or the allergy substance involved
+	Message     string `json:"message"`
+	Blocking    bool   `json:"blocking"`
+}
+
+// PrescribingBlockedError is returned when at least one warning forbids issuing the prescriptions.
+// All warnings found are carried along so the doctor sees the full picture.
+type PrescribingBlockedError struct {
+	Warnings []PrescribingWarning
+}
+
+func (e *PrescribingBlockedError) Error() string {
+	for _, w := range e.Warnings {
+		if w.Blocking {
+			return "prescribing blocked: " + w.Message
+		}
+	}
+	return "prescribing blocked"
+}
+
+func (s *medicalService) SearchDrugs(query string, limit int) ([]models.Drug, error) {
+	query = strings.TrimSpace(query)
+	if len([]rune(query)) < 2 {
+		return nil, &ValidationError{Reason: "search query must be at least 2 characters"}
+	}
+	if limit <= 0 {
+		limit = defaultDrugSearchLimit
+	}
+	if limit > maxDrugSearchLimit {
+		limit = maxDrugSearchLimit
+	}
+	return s.repo.SearchDrugs(query, limit)
+}
+
+func (s *medicalService) GetDrug(code string) (*models.Drug, error) {
+	return s.repo.GetDrug(catalog.NormalizeDrugCode(code))
+}
+
+// ImportDrugCatalog upserts the drugs and interactions of a parsed catalog; later duplicates win.
+// It returns the number of drugs and interactions written.
+func (s *medicalService) ImportDrugCatalog(cat *catalog.DrugCatalog) (int, int, error) {
+	drugs := make(map[string]models.Drug, len(cat.Drugs))
+	for _, d := range cat.Drugs {
+		drugs[d.Code] = d
+	}
+	if len(drugs) == 0 {
+		return 0, 0, &ValidationError{Reason: "no drugs found in the import"}
+	}
+	interactions := make(map[[2]string]models.DrugInteraction, len(cat.Interactions))
+	for _, in := range cat.Interactions {
+		interactions[[2]string{in.IngredientA, in.IngredientB}] = in
+	}
+
+	uniqueDrugs := make([]models.Drug, 0, len(drugs))
+	for _, d := range drugs {
+		uniqueDrugs = append(uniqueDrugs, d)
+	}
+	uniqueInteractions := make([]models.DrugInteraction, 0, len(interactions))
+	for _, in := range interactions {
+		uniqueInteractions = append(uniqueInteractions, in)
+	}
+
+	if err := s.repo.UpsertDrugs(uniqueDrugs); err != nil {
+		return 0, 0, err
+	}
+	if err := s.repo.UpsertDrugInteractions(uniqueInteractions); err != nil {
+		return 0, 0, err
+	}
+	return len(uniqueDrugs), len(uniqueInteractions), nil
+}
+
+// resolveDrugs links each medication to its catalog entry, by drug_code when given and otherwise by
+// exact name. Medications not in the catalog stay free text. The result is aligned with meds.
+func (s *medicalService) resolveDrugs(meds []models.Prescription) ([]*models.Drug, error) {
+	var codes, names []string
+	for i := range meds {
+		if meds[i].DrugCode != nil {
+			code := catalog.NormalizeDrugCode(*meds[i].DrugCode)
+			meds[i].DrugCode = &code
+			codes = append(codes, code)
+		} else if name := strings.TrimSpace(meds[i].Medication); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+
+	byCode := make(map[string]*models.Drug)
+	byName := make(map[string]*models.Drug)
+	if len(codes) > 0 {
+		found, err := s.repo.GetDrugsByCodes(codes)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			byCode[found[i].Code] = &found[i]
+		}
+	}
+	if len(names) > 0 {
+		found, err := s.repo.GetDrugsByNames(names)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			if key := strings.ToLower(found[i].Name); byName[key] == nil {
+				byName[key] = &found[i]
+			}
+		}
+	}
+
+	drugs := make([]*models.Drug, len(meds))
+	for i := range meds {
+		m := &meds[i]
+		if m.DrugCode != nil {
+			drug := byCode[*m.DrugCode]
+			if drug == nil {
+				return nil, &ValidationError{Reason: fmt.Sprintf("unknown drug code %s", *m.DrugCode)}
+			}
+			if strings.TrimSpace(m.Medication) == "" {
+				m.Medication = drug.Name
+			}
+			drugs[i] = drug
+			continue
+		}
+		if drug := byName[strings.ToLower(strings.TrimSpace(m.Medication))]; drug != nil {
+			m.DrugCode = &drug.Code
+			drugs[i] = drug
+		}
+	}
+	return drugs, nil
+}
+
+// prescribedDrug is a medication taking part in the interaction check
+type prescribedDrug struct {
+	medication string
+	drug       *models.Drug
+	isNew      bool
+}
+
+// checkInteractions compares the new medications with each other and with the patient's active
+// prescriptions. Contraindicated combinations block prescribing; everything else is a warning.
+func (s *medicalService) checkInteractions(patientID uint, meds []models.Prescription, drugs []*models.Drug) ([]PrescribingWarning, error) {
+	var entries []prescribedDrug
+	for i, drug := range drugs {
+		if drug != nil {
+			entries = append(entries, prescribedDrug{medication: meds[i].Medication, drug: drug, isNew: true})
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	active, err := s.repo.GetActivePrescriptionsByPatient(patientID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// A renewal takes the place of its original, the two are never taken together
+	replaced := make(map[uint]bool)
+	for _, m := range meds {
+		if m.RenewedFromID != nil {
+			replaced[*m.RenewedFromID] = true
+		}
+	}
+	for _, p := range active {
+		if p.Drug != nil && !replaced[p.ID] {
+			entries = append(entries, prescribedDrug{medication: p.Medication, drug: p.Drug})
+		}
+	}
+
+	seen := make(map[string]bool)
+	var ingredients []string
+	for _, e := range entries {
+		if !seen[e.drug.Ingredient] {
+			seen[e.drug.Ingredient] = true
+			ingredients = append(ingredients, e.drug.Ingredient)
+		}
+	}
+	sort.Strings(ingredients)
+
+	known, err := s.repo.GetInteractionsAmong(ingredients)
+	if err != nil {
+		return nil, err
+	}
+	interactions := make(map[[2]string]models.DrugInteraction, len(known))
+	for _, in := range known {
+		interactions[[2]string{in.IngredientA, in.IngredientB}] = in
+	}
+
+	var warnings []PrescribingWarning
+	for i, a := range entries {
+		if !a.isNew {
+			continue
+		}
+		for j, b := range entries {
+			// Pairs of new medications are checked once, new against active always
+			if j == i || (b.isNew && j < i) {
+				continue
+			}
+			if a.drug.Ingredient == b.drug.Ingredient {
+				warnings = append(warnings, PrescribingWarning{
+					Kind:        "duplicate",
+					Severity:    string(models.InteractionModerate),
+					Medication:  a.medication,
+					Conflicting: b.medication,
+					Message:     fmt.Sprintf("%s and %s both contain %s", a.medication, b.medication, a.drug.Ingredient),
+				})
+				continue
+			}
+
+			key := [2]string{a.drug.Ingredient, b.drug.Ingredient}
+			if key[1] < key[0] {
+				key[0], key[1] = key[1], key[0]
+			}
+			in, ok := interactions[key]
+			if !ok {
+				continue
+			}
+			message := fmt.Sprintf("%s interacts with %s (%s)", a.medication, b.medication, in.Severity)
+			if in.Description != "" {
+				message += ": " + in.Description
+			}
+			warnings = append(warnings, PrescribingWarning{
+				Kind:        "interaction",
+				Severity:    string(in.Severity),
+				Medication:  a.medication,
+				Conflicting: b.medication,
+				Message:     message,
+				Blocking:    in.Severity == models.InteractionContraindicated,
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// blockingWarnings fails with PrescribingBlockedError when any of the warnings blocks prescribing
+func blockingWarnings(warnings []PrescribingWarning) error {
+	for _, w := range warnings {
+		if w.Blocking {
+			return &PrescribingBlockedError{Warnings: warnings}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package services
+
+// ConflictError is returned when a request collides with existing state,
+// e.g. booking a slot the doctor or patient already has taken.
+type ConflictError struct {
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// ValidationError is returned when the request is well-formed JSON but violates a business rule,
+// e.g. booking outside the doctor's working hours.
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+// ForbiddenError is returned when the caller is not allowed to perform an otherwise valid action,
+// e.g. a patient over the no-show limit booking again.
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
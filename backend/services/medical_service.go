@@ -1,10 +1,15 @@
 package services
 
 import (
+	"errors"
+	"log"
 	"time"
 
+	"github.com/cristim67/med-monitor/backend/catalog"
+	"github.com/cristim67/med-monitor/backend/config"
 	"github.com/cristim67/med-monitor/backend/models"
 	"github.com/cristim67/med-monitor/backend/repository"
+	"github.com/cristim67/med-monitor/backend/rxsign"
 )
 
 type MedicalService interface {
@@ -16,35 +21,144 @@ type MedicalService interface {
 
 	// Doctors
 	GetDoctors() ([]models.Doctor, error)
+	GetDoctorSchedule(doctorID uint) ([]models.DoctorSchedule, error)
+	SetDoctorSchedule(doctorID uint, schedules []models.DoctorSchedule) error
+	GetAvailableSlots(doctorID uint, from, to string) ([]models.TimeSlot, error)
+
+	// Absences & closures
+	GetAbsences(from, to string) ([]models.Absence, error)
+	GetAbsence(id uint) (*models.Absence, error)
+	CreateAbsence(absence *models.Absence, flagAppointments bool) (int64, error)
+	DeleteAbsence(id uint) error
 
 	// Patients
 	GetPatients() ([]models.Patient, error)
 	GetPatient(id uint) (*models.Patient, error)
 
 	// Appointments
-	BookAppointment(patientID, doctorID uint, date string) (*models.Appointment, error)
+	// The no-show limit only applies when byPatient is set, staff booking are not held to it
+	BookAppointment(patientID, doctorID uint, date string, byPatient bool) (*models.Appointment, error)
+	RescheduleAppointment(apptID, actorID uint, date, reason string) (*models.Appointment, error)
+	GetAppointment(apptID uint) (*models.Appointment, error)
+	GetAppointmentHistory(apptID uint) ([]models.AppointmentHistory, error)
 	GetPatientAppointments(patientID uint) ([]models.Appointment, error)
 	GetDoctorAppointments(doctorID uint) ([]models.Appointment, error)
 	GetAllAppointments() ([]models.Appointment, error)
-	CompleteAppointment(apptID uint, diagnosis, notes string, medications []models.Prescription) error
-	CancelAppointment(apptID uint) error
+	CompleteAppointment(apptID, actorID uint, input ConsultationInput) ([]PrescribingWarning, error)
+	CancelAppointment(apptID, actorID uint, reason string) error
+	UpdateAppointmentStatus(apptID, actorID uint, status, reason string) (*models.Appointment, error)
+	GetAppointmentStatusEvents(apptID uint) ([]models.AppointmentStatusEvent, error)
+	MarkNoShows(grace time.Duration) (int, error)
+	GetPatientNoShows(patientID uint) (*NoShowSummary, error)
 	DeleteAppointment(apptID uint) error
 
+	// Consultations
+	GetConsultation(consID uint) (*ConsultationRecord, error)
+	GetAppointmentConsultation(apptID uint) (*ConsultationRecord, error)
+	AmendConsultation(consID, authorID uint, input AmendmentInput) (*ConsultationRecord, error)
+
+	// Diagnosis code catalog
+	SearchDiagnosisCodes(query string, limit int) ([]models.DiagnosisCode, error)
+	GetDiagnosisCode(code string) (*models.DiagnosisCode, error)
+	ImportDiagnosisCodes(codes []models.DiagnosisCode) (int, error)
+
+	// Patient allergies
+	GetPatientAllergies(patientID uint) ([]models.PatientAllergy, error)
+	CreatePatientAllergy(patientID, doctorID uint, allergy *models.PatientAllergy) error
+	UpdatePatientAllergy(patientID, allergyID, doctorID uint, allergy *models.PatientAllergy) error
+	DeletePatientAllergy(patientID, allergyID uint) error
+
+	// Observations
+	RecordObservations(patientID uint, source models.ObservationSource, recordedByID *uint, obs []models.Observation) ([]models.Observation, error)
+	GetObservations(patientID uint, obsType, from, to string) ([]models.Observation, error)
+
+	// Monitoring devices
+	GetPatientDevices(patientID uint) ([]models.Device, error)
+	RegisterDevice(patientID, registeredByID uint, device *models.Device) (*RegisteredDevice, error)
+	RotateDeviceCredential(patientID, deviceID uint) (*RegisteredDevice, error)
+	RevokeDevice(patientID, deviceID uint) error
+	AuthenticateDevice(token string) (*models.Device, error)
+	IngestTelemetry(device *models.Device, batchID string, readings []models.Observation) (*TelemetryResult, error)
+
+	// Alert rules & alerts
+	GetAlertRules(patientID *uint) ([]models.AlertRule, error)
+	CreateAlertRule(createdByID uint, admin bool, rule *models.AlertRule) error
+	UpdateAlertRule(id, actorID uint, admin bool, update AlertRuleUpdate) (*models.AlertRule, error)
+	DeleteAlertRule(id, actorID uint, admin bool) error
+	GetAlerts(filter repository.AlertFilter) ([]models.Alert, error)
+	GetAlert(id uint) (*models.Alert, error)
+	AcknowledgeAlert(id, userID uint) (*models.Alert, error)
+	ResolveAlert(id, userID uint) (*models.Alert, error)
+
+	// Drug catalog
+	SearchDrugs(query string, limit int) ([]models.Drug, error)
+	GetDrug(code string) (*models.Drug, error)
+	ImportDrugCatalog(cat *catalog.DrugCatalog) (int, int, error)
+
+	// Clinical note templates
+	GetNoteTemplates(departmentID *uint) ([]models.NoteTemplate, error)
+	GetNoteTemplate(id uint) (*models.NoteTemplate, error)
+	CreateNoteTemplate(tmpl *models.NoteTemplate) error
+	UpdateNoteTemplate(id uint, tmpl *models.NoteTemplate) error
+	DeleteNoteTemplate(id uint) error
+
 	// Prescriptions
 	GetPatientPrescriptions(patientID uint) ([]models.Prescription, error)
 	GetDoctorPrescriptions(doctorID uint) ([]models.Prescription, error)
 	UpdatePrescriptionStatus(prescID uint, status string) error
+	ExpirePrescriptions() (int64, error)
+
+	// Pharmacy
+	LookupPrescription(code string) (*PharmacyPrescription, error)
+	DispensePrescription(code string, pharmacistID uint, input DispenseInput) (*PharmacyPrescription, error)
+
+	// Signed prescription payloads
+	GetPrescription(id uint) (*models.Prescription, error)
+	SignPrescription(presc *models.Prescription) (*SignedPrescription, error)
+	PrescriptionPublicKey() PrescriptionPublicKey
+	VerifyPrescriptionPayload(payload string) (*VerifiedPrescription, error)
+
+	// Prescription renewals
+	RequestPrescriptionRenewal(prescID, patientID uint, note string) (*models.PrescriptionRenewal, error)
+	GetPrescriptionRenewal(id uint) (*models.PrescriptionRenewal, error)
+	GetPrescriptionRenewals(filter repository.RenewalFilter) ([]models.PrescriptionRenewal, error)
+	ApprovePrescriptionRenewal(renewalID, doctorID uint, decision RenewalDecision) (*models.PrescriptionRenewal, error)
+	DenyPrescriptionRenewal(renewalID, doctorID uint, reason string) (*models.PrescriptionRenewal, error)
 
 	// History
 	GetPatientHistory(patientID uint) (map[string]interface{}, error)
 }
 
+// DefaultAppointmentDuration is the slot length used for doctors without a configured schedule
+const DefaultAppointmentDuration = 30 * time.Minute
+
 type medicalService struct {
-	repo repository.MedicalRepository
+	repo         repository.MedicalRepository
+	uow          repository.UnitOfWork
+	loc          *time.Location // clinic timezone used to interpret working hours
+	noShowLimit  int
+	noShowWindow time.Duration
+
+	prescriptionValidity time.Duration
+	signer               *rxsign.Signer // signs prescription QR payloads
 }
 
-func NewMedicalService(repo repository.MedicalRepository) MedicalService {
-	return &medicalService{repo: repo}
+func NewMedicalService(repo repository.MedicalRepository, uow repository.UnitOfWork) MedicalService {
+	loc, err := time.LoadLocation(config.AppConfig.ClinicTimezone)
+	if err != nil {
+		log.Printf("Invalid CLINIC_TIMEZONE %q, falling back to UTC: %v", config.AppConfig.ClinicTimezone, err)
+		loc = time.UTC
+	}
+	return &medicalService{
+		repo:         repo,
+		uow:          uow,
+		loc:          loc,
+		noShowLimit:  config.AppConfig.NoShowLimit,
+		noShowWindow: config.AppConfig.NoShowWindow,
+
+		prescriptionValidity: config.AppConfig.PrescriptionValidity,
+		signer:               loadPrescriptionSigner(config.AppConfig.PrescriptionSigningKey, config.AppConfig.Environment),
+	}
 }
 
 func (s *medicalService) GetDepartments() ([]models.Department, error) {
@@ -81,34 +195,166 @@ func (s *medicalService) GetPatient(id uint) (*models.Patient, error) {
 	return s.repo.GetPatientByID(id)
 }
 
-func (s *medicalService) BookAppointment(patientID, doctorID uint, date string) (*models.Appointment, error) {
-	parsedDate, err := time.Parse(time.RFC3339, date)
-	if err != nil {
-		// Try alternative layout if RFC3339 fails (e.g. from datetime-local input usually has T but maybe not Z or offsets)
-		parsedDate, err = time.Parse("2006-01-02T15:04", date)
-		if err != nil {
+func (s *medicalService) BookAppointment(patientID, doctorID uint, date string, byPatient bool) (*models.Appointment, error) {
+	if byPatient {
+		if err := s.checkNoShowLimit(patientID); err != nil {
 			return nil, err
 		}
 	}
+	slot, err := s.resolveBookableSlot(doctorID, date)
+	if err != nil {
+		return nil, err
+	}
 
 	appt := &models.Appointment{
 		PatientID:       patientID,
 		DoctorID:        doctorID,
-		AppointmentDate: parsedDate,
+		AppointmentDate: slot.Start,
+		EndDate:         slot.End,
 		Status:          models.StatusScheduled,
 	}
 
-	err = s.repo.CreateAppointment(appt)
-	return appt, err
+	if err := s.checkAppointmentConflicts(appt); err != nil {
+		return nil, err
+	}
+
+	// The exclusion constraints on appointments catch concurrent bookings that slipped past the check above
+	if err := s.repo.CreateAppointment(appt); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, s.slotTakenError(appt)
+		}
+		return nil, err
+	}
+	return appt, nil
 }
 
-func (s *medicalService) CancelAppointment(apptID uint) error {
+// RescheduleAppointment moves a scheduled appointment to a new slot, keeping its identity and
+// recording the previous times in the appointment history
+func (s *medicalService) RescheduleAppointment(apptID, actorID uint, date, reason string) (*models.Appointment, error) {
 	appt, err := s.repo.GetAppointmentByID(apptID)
 	if err != nil {
+		return nil, err
+	}
+	if appt.Status != models.StatusScheduled {
+		return nil, &ConflictError{Reason: "only scheduled appointments can be rescheduled, this one is " + string(appt.Status)}
+	}
+
+	slot, err := s.resolveBookableSlot(appt.DoctorID, date)
+	if err != nil {
+		return nil, err
+	}
+	if slot.Start.Equal(appt.AppointmentDate) {
+		return nil, &ValidationError{Reason: "appointment is already scheduled at the requested time"}
+	}
+
+	history := &models.AppointmentHistory{
+		AppointmentID:   appt.ID,
+		PreviousDate:    appt.AppointmentDate,
+		PreviousEndDate: appt.EndDate,
+		NewDate:         slot.Start,
+		NewEndDate:      slot.End,
+		ChangedByID:     actorID,
+		Reason:          reason,
+	}
+
+	appt.AppointmentDate = slot.Start
+	appt.EndDate = slot.End
+	appt.NeedsReschedule = false
+	if err := s.checkAppointmentConflicts(appt); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.RescheduleAppointment(appt, history); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, s.slotTakenError(appt)
+		}
+		return nil, err
+	}
+	return appt, nil
+}
+
+// resolveBookableSlot validates that date is a future slot of the doctor's schedule outside any absence
+func (s *medicalService) resolveBookableSlot(doctorID uint, date string) (*models.TimeSlot, error) {
+	parsedDate, err := s.parseAppointmentDate(date)
+	if err != nil {
+		return nil, &ValidationError{Reason: "invalid appointment date: " + err.Error()}
+	}
+	if parsedDate.Before(time.Now()) {
+		return nil, &ValidationError{Reason: "appointments cannot be booked in the past"}
+	}
+
+	doc, err := s.repo.GetDoctorByID(doctorID)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := s.matchScheduleSlot(doctorID, parsedDate)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAbsences(doc, slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+func (s *medicalService) GetAppointment(apptID uint) (*models.Appointment, error) {
+	return s.repo.GetAppointmentByID(apptID)
+}
+
+func (s *medicalService) GetAppointmentHistory(apptID uint) ([]models.AppointmentHistory, error) {
+	if _, err := s.repo.GetAppointmentByID(apptID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAppointmentHistory(apptID)
+}
+
+// parseAppointmentDate reads the requested start of an appointment. A time with an offset is an exact
+// instant; a local time without one (e.g. from a datetime-local input) is wall-clock time in the clinic timezone.
+func (s *medicalService) parseAppointmentDate(date string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, date); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.ParseInLocation("2006-01-02T15:04:05", date, s.loc); err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", date, s.loc)
+}
+
+// checkAppointmentConflicts rejects appointments overlapping another scheduled visit of the same doctor or patient
+func (s *medicalService) checkAppointmentConflicts(appt *models.Appointment) error {
+	overlapping, err := s.repo.FindOverlappingAppointments(appt.DoctorID, appt.PatientID, appt.AppointmentDate, appt.EndDate, appt.ID)
+	if err != nil {
+		return err
+	}
+	for _, o := range overlapping {
+		at := o.AppointmentDate.Format(models.RFC3339NoNano)
+		switch {
+		case o.DoctorID == appt.DoctorID && o.PatientID == appt.PatientID:
+			return &ConflictError{Reason: "patient already has an appointment with this doctor at " + at}
+		case o.DoctorID == appt.DoctorID:
+			return &ConflictError{Reason: "doctor already has an appointment at " + at}
+		case o.PatientID == appt.PatientID:
+			return &ConflictError{Reason: "patient already has an appointment at " + at}
+		}
+	}
+	return nil
+}
+
+// slotTakenError explains a booking rejected by the exclusion constraints, naming the party whose
+// concurrent booking won when it can still be found
+func (s *medicalService) slotTakenError(appt *models.Appointment) error {
+	if err := s.checkAppointmentConflicts(appt); err != nil {
 		return err
 	}
-	appt.Status = models.StatusCancelled
-	return s.repo.UpdateAppointment(appt)
+	return &ConflictError{Reason: "the selected time slot is no longer available"}
+}
+
+func (s *medicalService) CancelAppointment(apptID, actorID uint, reason string) error {
+	appt, err := s.repo.GetAppointmentByID(apptID)
+	if err != nil {
+		return err
+	}
+	return s.transitionAppointment(appt, models.StatusCancelled, &actorID, reason)
 }
 
 func (s *medicalService) GetPatientAppointments(patientID uint) ([]models.Appointment, error) {
@@ -127,35 +373,144 @@ func (s *medicalService) DeleteAppointment(apptID uint) error {
 	return s.repo.DeleteAppointment(apptID)
 }
 
-func (s *medicalService) CompleteAppointment(apptID uint, diagnosis, notes string, medications []models.Prescription) error {
-	appt, err := s.repo.GetAppointmentByID(apptID)
-	if err != nil {
-		return err
-	}
+// ConsultationInput is the clinical content recorded when an appointment is completed
+type ConsultationInput struct {
+	Diagnosis   string                `json:"diagnosis"`
+	Notes       string                `json:"notes"`
+	TemplateID  *uint                 `json:"template_id"`
+	SOAP        *models.SOAPNote      `json:"soap"`
+	Medications []models.Prescription `json:"medications"`
 
-	appt.Status = models.StatusCompleted
-	if err := s.repo.UpdateAppointment(appt); err != nil {
-		return err
-	}
+	// Vital signs measured during the consultation
+	Observations []models.Observation `json:"observations"`
 
-	cons := &models.Consultation{
-		AppointmentID: apptID,
-		Diagnosis:     diagnosis,
-		Notes:         notes,
-	}
-	if err := s.repo.CreateConsultation(cons); err != nil {
-		return err
-	}
+	// ICD-10 coded diagnoses recorded alongside the narrative Diagnosis
+	PrimaryDiagnosisCode    string   `json:"primary_diagnosis_code"`
+	SecondaryDiagnosisCodes []string `json:"secondary_diagnosis_codes"`
+}
 
-	for _, m := range medications {
-		m.ConsultationID = cons.ID
-		m.Status = models.StatusIssued
-		if err := s.repo.CreatePrescription(&m); err != nil {
+// CompleteAppointment records the consultation and issues its prescriptions. It returns the
+// non-blocking prescribing warnings found, for the doctor to review.
+func (s *medicalService) CompleteAppointment(apptID, actorID uint, input ConsultationInput) ([]PrescribingWarning, error) {
+	var warnings []PrescribingWarning
+	// Status change, consultation and prescriptions are committed together or not at all
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		txs := s.withRepo(tx.Medical)
+
+		appt, err := txs.repo.GetAppointmentByID(apptID)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := txs.resolveNoteTemplate(input.TemplateID, &appt.Doctor)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil && input.SOAP == nil {
+			return &ValidationError{Reason: "a structured SOAP note is required when a template is selected"}
+		}
+		if input.SOAP != nil {
+			if err := validateSOAPNote(input.SOAP, tmpl); err != nil {
+				return err
+			}
+		}
+
+		diagnoses, err := txs.buildConsultationDiagnoses(input.PrimaryDiagnosisCode, input.SecondaryDiagnosisCodes)
+		if err != nil {
+			return err
+		}
+		drugs, err := txs.resolveDrugs(input.Medications)
+		if err != nil {
+			return err
+		}
+		for i := range input.Medications {
+			if err := preparePrescription(&input.Medications[i]); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for i := range input.Observations {
+			if err := prepareObservation(&input.Observations[i], now); err != nil {
+				return err
+			}
+		}
+		if warnings, err = txs.checkInteractions(appt.PatientID, input.Medications, drugs); err != nil {
+			return err
+		}
+		allergyWarnings, err := txs.checkAllergies(appt.PatientID, input.Medications, drugs)
+		if err != nil {
+			return err
+		}
+		warnings = append(allergyWarnings, warnings...)
+		if err := blockingWarnings(warnings); err != nil {
 			return err
 		}
+
+		if err := txs.transitionAppointment(appt, models.StatusCompleted, &actorID, ""); err != nil {
+			return err
+		}
+
+		cons := &models.Consultation{
+			AppointmentID: apptID,
+			Diagnosis:     input.Diagnosis,
+			Notes:         input.Notes,
+			TemplateID:    input.TemplateID,
+		}
+		if input.SOAP != nil {
+			cons.SOAP = *input.SOAP
+		}
+		if err := txs.repo.CreateConsultation(cons); err != nil {
+			return err
+		}
+		for i := range diagnoses {
+			diagnoses[i].ConsultationID = cons.ID
+		}
+		if err := txs.repo.CreateConsultationDiagnoses(diagnoses); err != nil {
+			return err
+		}
+
+		for i := range input.Observations {
+			o := &input.Observations[i]
+			o.ID = 0
+			o.PatientID = appt.PatientID
+			o.Source = models.SourceClinician
+			o.RecordedByID = &actorID
+			o.ConsultationID = &cons.ID
+		}
+		if err := txs.repo.CreateObservations(input.Observations); err != nil {
+			return err
+		}
+		if err := txs.evaluateAlerts(appt.PatientID, input.Observations); err != nil {
+			return err
+		}
+
+		for _, m := range input.Medications {
+			m.ConsultationID = cons.ID
+			m.Status = models.StatusIssued
+			if m.ExpiresAt, err = txs.prescriptionExpiry(m.ExpiresAt); err != nil {
+				return err
+			}
+			if m.VerificationCode, err = newVerificationCode(); err != nil {
+				return err
+			}
+			if err := txs.repo.CreatePrescription(&m); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return warnings, nil
+}
 
-	return nil
+// withRepo returns a copy of the service working against repo, used to run service logic inside a transaction
+func (s *medicalService) withRepo(repo repository.MedicalRepository) *medicalService {
+	txs := *s
+	txs.repo = repo
+	return &txs
 }
 
 func (s *medicalService) GetPatientPrescriptions(patientID uint) ([]models.Prescription, error) {
@@ -167,9 +522,16 @@ func (s *medicalService) GetDoctorPrescriptions(doctorID uint) ([]models.Prescri
 }
 
 func (s *medicalService) UpdatePrescriptionStatus(prescID uint, status string) error {
-	// Simplified update
-	presc := &models.Prescription{ID: prescID, Status: models.PrescriptionStatus(status)}
-	return s.repo.UpdatePrescription(presc)
+	// Dispensing records quantities and lots, only DispensePrescription may reach these statuses
+	switch models.PrescriptionStatus(status) {
+	case models.StatusDispensed, models.StatusPartiallyDispensed:
+		return &ValidationError{Reason: "prescriptions become " + status + " only by dispensing them"}
+	}
+	presc, err := s.repo.GetPrescriptionByID(prescID)
+	if err != nil {
+		return err
+	}
+	return s.transitionPrescription(presc, models.PrescriptionStatus(status))
 }
 
 func (s *medicalService) GetPatientHistory(patientID uint) (map[string]interface{}, error) {
@@ -183,8 +545,21 @@ func (s *medicalService) GetPatientHistory(patientID uint) (map[string]interface
 		return nil, err
 	}
 
+	allergies, err := s.repo.GetPatientAllergies(patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Readings accumulate quickly, the history carries the most recent ones only
+	observations, err := s.repo.GetObservations(patientID, "", time.Time{}, time.Time{}, historyObservationLimit)
+	if err != nil {
+		return nil, err
+	}
+
 	return map[string]interface{}{
 		"appointments":  appts,
 		"prescriptions": prescs,
+		"allergies":     allergies,
+		"observations":  observations,
 	}, nil
 }
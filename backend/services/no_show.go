@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// NoShowSummary reports how many appointments a patient missed within the configured window
+type NoShowSummary struct {
+	PatientID  uint  `json:"patient_id"`
+	NoShows    int64 `json:"no_shows"`
+	WindowDays int   `json:"window_days"`
+	Limit      int   `json:"limit"` // 0 when booking limits are disabled
+	Blocked    bool  `json:"blocked"`
+}
+
+// MarkNoShows moves every appointment still Scheduled grace after its start to NoShow and
+// returns how many were marked. Appointments changed concurrently are skipped.
+func (s *medicalService) MarkNoShows(grace time.Duration) (int, error) {
+	overdue, err := s.repo.GetOverdueScheduledAppointments(time.Now().Add(-grace))
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for i := range overdue {
+		reason := fmt.Sprintf("automatically marked as no-show %s after the scheduled time", grace)
+		if err := s.transitionAppointment(&overdue[i], models.StatusNoShow, nil, reason); err != nil {
+			log.Printf("Failed to mark appointment %d as no-show: %v", overdue[i].ID, err)
+			continue
+		}
+		marked++
+	}
+	return marked, nil
+}
+
+func (s *medicalService) GetPatientNoShows(patientID uint) (*NoShowSummary, error) {
+	if _, err := s.repo.GetPatientByID(patientID); err != nil {
+		return nil, err
+	}
+	count, err := s.repo.CountNoShowsByPatient(patientID, time.Now().Add(-s.noShowWindow))
+	if err != nil {
+		return nil, err
+	}
+	return &NoShowSummary{
+		PatientID:  patientID,
+		NoShows:    count,
+		WindowDays: int(s.noShowWindow / (24 * time.Hour)),
+		Limit:      s.noShowLimit,
+		Blocked:    s.noShowLimit > 0 && count >= int64(s.noShowLimit),
+	}, nil
+}
+
+// checkNoShowLimit refuses new bookings from patients who reached the configured no-show limit
+func (s *medicalService) checkNoShowLimit(patientID uint) error {
+	if s.noShowLimit <= 0 {
+		return nil
+	}
+	summary, err := s.GetPatientNoShows(patientID)
+	if err != nil {
+		return err
+	}
+	if summary.Blocked {
+		return &ForbiddenError{Reason: fmt.Sprintf("booking blocked: %d missed appointments in the last %d days", summary.NoShows, summary.WindowDays)}
+	}
+	return nil
+}
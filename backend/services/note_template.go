@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"gorm.io/gorm"
+)
+
+func isKnownNoteSection(section models.NoteSection) bool {
+	switch section {
+	case models.SectionSubjective, models.SectionObjective, models.SectionAssessment, models.SectionPlan:
+		return true
+	}
+	return false
+}
+
+// sectionText returns the free text of one SOAP section
+func sectionText(note *models.SOAPNote, section models.NoteSection) string {
+	switch section {
+	case models.SectionSubjective:
+		return note.Subjective
+	case models.SectionObjective:
+		return note.Objective
+	case models.SectionAssessment:
+		return note.Assessment
+	case models.SectionPlan:
+		return note.Plan
+	}
+	return ""
+}
+
+func validateNoteTemplate(tmpl *models.NoteTemplate) error {
+	if strings.TrimSpace(tmpl.Name) == "" {
+		return &ValidationError{Reason: "template name is required"}
+	}
+	for _, section := range tmpl.RequiredSections {
+		if !isKnownNoteSection(section) {
+			return &ValidationError{Reason: fmt.Sprintf("unknown SOAP section %q", section)}
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, field := range tmpl.Fields {
+		if field.Key == "" {
+			return &ValidationError{Reason: "template fields need a key"}
+		}
+		if keys[field.Key] {
+			return &ValidationError{Reason: fmt.Sprintf("template field %q is defined more than once", field.Key)}
+		}
+		keys[field.Key] = true
+
+		if !isKnownNoteSection(field.Section) {
+			return &ValidationError{Reason: fmt.Sprintf("field %q: unknown SOAP section %q", field.Key, field.Section)}
+		}
+		switch field.Type {
+		case models.FieldText, models.FieldNumber:
+		case models.FieldChoice:
+			if len(field.Options) == 0 {
+				return &ValidationError{Reason: fmt.Sprintf("field %q: choice fields need options", field.Key)}
+			}
+		default:
+			return &ValidationError{Reason: fmt.Sprintf("field %q: unknown type %q", field.Key, field.Type)}
+		}
+	}
+	return nil
+}
+
+// validateSOAPNote checks a structured note against its template; without a template only the
+// free-text sections may be used
+func validateSOAPNote(note *models.SOAPNote, tmpl *models.NoteTemplate) error {
+	if tmpl == nil {
+		if len(note.Fields) > 0 {
+			return &ValidationError{Reason: "structured note fields require a template"}
+		}
+		return nil
+	}
+
+	for _, section := range tmpl.RequiredSections {
+		if strings.TrimSpace(sectionText(note, section)) == "" {
+			return &ValidationError{Reason: fmt.Sprintf("template %q requires the %s section", tmpl.Name, section)}
+		}
+	}
+
+	defined := make(map[string]models.TemplateField, len(tmpl.Fields))
+	for _, field := range tmpl.Fields {
+		defined[field.Key] = field
+	}
+	for key := range note.Fields {
+		if _, ok := defined[key]; !ok {
+			return &ValidationError{Reason: fmt.Sprintf("field %q is not part of template %q", key, tmpl.Name)}
+		}
+	}
+
+	for _, field := range tmpl.Fields {
+		value := strings.TrimSpace(note.Fields[field.Key])
+		if value == "" {
+			if field.Required {
+				return &ValidationError{Reason: fmt.Sprintf("field %q is required", field.Label)}
+			}
+			continue
+		}
+		switch field.Type {
+		case models.FieldNumber:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return &ValidationError{Reason: fmt.Sprintf("field %q must be a number", field.Label)}
+			}
+		case models.FieldChoice:
+			valid := false
+			for _, option := range field.Options {
+				if option == value {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return &ValidationError{Reason: fmt.Sprintf("field %q must be one of %s", field.Label, strings.Join(field.Options, ", "))}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveNoteTemplate loads the template selected for a consultation and checks it may be used by the doctor
+func (s *medicalService) resolveNoteTemplate(templateID *uint, doctor *models.Doctor) (*models.NoteTemplate, error) {
+	if templateID == nil {
+		return nil, nil
+	}
+	tmpl, err := s.repo.GetNoteTemplateByID(*templateID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &ValidationError{Reason: fmt.Sprintf("note template %d does not exist", *templateID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.DepartmentID != nil && *tmpl.DepartmentID != doctor.DepartmentID {
+		return nil, &ValidationError{Reason: fmt.Sprintf("note template %q belongs to another department", tmpl.Name)}
+	}
+	return tmpl, nil
+}
+
+func (s *medicalService) GetNoteTemplates(departmentID *uint) ([]models.NoteTemplate, error) {
+	return s.repo.GetNoteTemplates(departmentID)
+}
+
+func (s *medicalService) GetNoteTemplate(id uint) (*models.NoteTemplate, error) {
+	return s.repo.GetNoteTemplateByID(id)
+}
+
+func (s *medicalService) CreateNoteTemplate(tmpl *models.NoteTemplate) error {
+	if err := validateNoteTemplate(tmpl); err != nil {
+		return err
+	}
+	if tmpl.DepartmentID != nil {
+		if _, err := s.repo.GetDepartmentByID(*tmpl.DepartmentID); err != nil {
+			return err
+		}
+	}
+	tmpl.ID = 0
+	return s.repo.CreateNoteTemplate(tmpl)
+}
+
+func (s *medicalService) UpdateNoteTemplate(id uint, tmpl *models.NoteTemplate) error {
+	existing, err := s.repo.GetNoteTemplateByID(id)
+	if err != nil {
+		return err
+	}
+	if err := validateNoteTemplate(tmpl); err != nil {
+		return err
+	}
+	if tmpl.DepartmentID != nil {
+		if _, err := s.repo.GetDepartmentByID(*tmpl.DepartmentID); err != nil {
+			return err
+		}
+	}
+
+	existing.DepartmentID = tmpl.DepartmentID
+	existing.Name = tmpl.Name
+	existing.Description = tmpl.Description
+	existing.RequiredSections = tmpl.RequiredSections
+	existing.Fields = tmpl.Fields
+	if err := s.repo.UpdateNoteTemplate(existing); err != nil {
+		return err
+	}
+	*tmpl = *existing
+	return nil
+}
+
+func (s *medicalService) DeleteNoteTemplate(id uint) error {
+	if _, err := s.repo.GetNoteTemplateByID(id); err != nil {
+		return err
+	}
+	return s.repo.DeleteNoteTemplate(id)
+}
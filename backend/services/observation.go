@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+const (
+	maxObservationsPerRequest = 100
+	maxObservationListDays    = 366
+	defaultObservationDays    = 30
+	historyObservationLimit   = 200
+	// observationClockSkew tolerates small clock differences on self-reported and device readings
+	observationClockSkew = 5 * time.Minute
+)
+
+// observationSpec describes how a type of observation is measured
+type observationSpec struct {
+	unit     string                           // canonical unit values are stored in
+	convert  map[string]func(float64) float64 // other accepted units, converted to the canonical one
+	min, max float64                          // plausible range in the canonical unit
+}
+
+var observationSpecs = map[models.ObservationType]observationSpec{
+	models.ObservationBloodPressure: {unit: "mmHg", min: 30, max: 300},
+	models.ObservationHeartRate:     {unit: "bpm", min: 20, max: 300},
+	models.ObservationTemperature: {unit: "C", min: 25, max: 45, convert: map[string]func(float64) float64{
+		"F": func(v float64) float64 { return (v - 32) * 5 / 9 },
+	}},
+	models.ObservationSpO2: {unit: "%", min: 50, max: 100},
+	models.ObservationWeight: {unit: "kg", min: 0.3, max: 500, convert: map[string]func(float64) float64{
+		"lb": func(v float64) float64 { return v * 0.45359237 },
+	}},
+	models.ObservationGlucose: {unit: "mg/dL", min: 10, max: 1000, convert: map[string]func(float64) float64{
+		"mmol/L": func(v float64) float64 { return v * 18.016 },
+	}},
+}
+
+func roundObservation(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// prepareObservation validates a reading and converts it to the canonical unit of its type
+func prepareObservation(o *models.Observation, now time.Time) error {
+	spec, ok := observationSpecs[o.Type]
+	if !ok {
+		return &ValidationError{Reason: fmt.Sprintf("unknown observation type %q", o.Type)}
+	}
+
+	if o.Unit == "" {
+		o.Unit = spec.unit
+	}
+	if o.Unit != spec.unit {
+		convert, ok := spec.convert[o.Unit]
+		if !ok {
+			return &ValidationError{Reason: fmt.Sprintf("unit %q is not accepted for %s", o.Unit, o.Type)}
+		}
+		o.Value = convert(o.Value)
+		if o.DiastolicValue != nil {
+			d := convert(*o.DiastolicValue)
+			o.DiastolicValue = &d
+		}
+		o.Unit = spec.unit
+	}
+	o.Value = roundObservation(o.Value)
+
+	if o.Value < spec.min || o.Value > spec.max {
+		return &ValidationError{Reason: fmt.Sprintf("%s of %g %s is outside the plausible range %g-%g", o.Type, o.Value, o.Unit, spec.min, spec.max)}
+	}
+	if o.Type == models.ObservationBloodPressure {
+		if o.DiastolicValue == nil {
+			return &ValidationError{Reason: "blood pressure requires a diastolic value"}
+		}
+		d := roundObservation(*o.DiastolicValue)
+		o.DiastolicValue = &d
+		if d < spec.min || d >= o.Value {
+			return &ValidationError{Reason: "diastolic pressure must be positive and below the systolic pressure"}
+		}
+	} else if o.DiastolicValue != nil {
+		return &ValidationError{Reason: "only blood pressure takes a diastolic value"}
+	}
+
+	if o.ObservedAt.IsZero() {
+		o.ObservedAt = now
+	}
+	if o.ObservedAt.After(now.Add(observationClockSkew)) {
+		return &ValidationError{Reason: "observation time must not be in the future"}
+	}
+	return nil
+}
+
+// RecordObservations validates and stores readings for a patient, attributed to source and, when known, to the recording user
+func (s *medicalService) RecordObservations(patientID uint, source models.ObservationSource, recordedByID *uint, obs []models.Observation) ([]models.Observation, error) {
+	if len(obs) == 0 {
+		return nil, &ValidationError{Reason: "at least one observation is required"}
+	}
+	if len(obs) > maxObservationsPerRequest {
+		return nil, &ValidationError{Reason: fmt.Sprintf("at most %d observations can be recorded at once", maxObservationsPerRequest)}
+	}
+	if _, err := s.repo.GetPatientByID(patientID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range obs {
+		o := &obs[i]
+		if err := prepareObservation(o, now); err != nil {
+			return nil, err
+		}
+		o.ID = 0
+		o.PatientID = patientID
+		o.Source = source
+		o.RecordedByID = recordedByID
+		o.ConsultationID = nil
+	}
+	// Readings and the alerts they raise are committed together
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		txs := s.withRepo(tx.Medical)
+		if err := txs.repo.CreateObservations(obs); err != nil {
+			return err
+		}
+		return txs.evaluateAlerts(patientID, obs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obs, nil
+}
+
+// GetObservations lists a patient's readings between the from and to dates (YYYY-MM-DD, inclusive),
+// by default those of the last 30 days. An empty type returns every type.
+func (s *medicalService) GetObservations(patientID uint, obsType, from, to string) ([]models.Observation, error) {
+	if obsType != "" {
+		if _, ok := observationSpecs[models.ObservationType(obsType)]; !ok {
+			return nil, &ValidationError{Reason: fmt.Sprintf("unknown observation type %q", obsType)}
+		}
+	}
+	if from == "" && to == "" {
+		today := midnight(time.Now(), s.loc)
+		from = today.AddDate(0, 0, 1-defaultObservationDays).Format("2006-01-02")
+		to = today.Format("2006-01-02")
+	}
+	fromDay, toDay, err := s.parseDateRange(from, to, maxObservationListDays)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetObservations(patientID, models.ObservationType(obsType), fromDay, toDay.AddDate(0, 0, 1), 0)
+}
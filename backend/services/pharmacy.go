@@ -0,0 +1,153 @@
+package services
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// verificationAlphabet is Crockford's base32, without the letters easily misread as digits
+const (
+	verificationAlphabet   = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	verificationCodeLength = 12
+)
+
+// PharmacyPrescription is what a pharmacy sees after looking a prescription up by its code:
+// the medication and who it was issued to and by, without the consultation notes.
+type PharmacyPrescription struct {
+	ID               uint                          `json:"id"`
+	Medication       string                        `json:"medication"`
+	Dosage           string                        `json:"dosage"`
+	Quantity         int                           `json:"quantity"`  // 0 when the prescription predates structured dosage
+	Dispensed        int                           `json:"dispensed"` // total quantity handed over so far
+	Status           models.PrescriptionStatus     `json:"status"`
+	VerificationCode string                        `json:"verification_code"`
+	IssuedAt         time.Time                     `json:"issued_at"`
+	ExpiresAt        time.Time                     `json:"expires_at"`
+	PatientName      string                        `json:"patient_name"`
+	DoctorName       string                        `json:"doctor_name"`
+	Dispenses        []models.PrescriptionDispense `json:"dispenses"`
+}
+
+// DispenseInput describes one hand-over at the pharmacy. Final marks the prescription fully dispensed;
+// prescriptions with a prescribed quantity are also fully dispensed once it has all been handed over.
+type DispenseInput struct {
+	Quantity int    `json:"quantity"`
+	Final    bool   `json:"final"`
+	Notes    string `json:"notes"`
+}
+
+// newVerificationCode returns a random code with 60 bits of entropy
+func newVerificationCode() (string, error) {
+	buf := make([]byte, verificationCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = verificationAlphabet[int(b)%len(verificationAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// normalizeVerificationCode accepts codes typed with dashes, spaces or in lower case
+func normalizeVerificationCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func dispensedQuantity(presc *models.Prescription) int {
+	total := 0
+	for _, d := range presc.Dispenses {
+		total += d.Quantity
+	}
+	return total
+}
+
+func pharmacyView(presc *models.Prescription) *PharmacyPrescription {
+	appt := presc.Consultation.Appointment
+	return &PharmacyPrescription{
+		ID:               presc.ID,
+		Medication:       presc.Medication,
+		Dosage:           presc.Dosage,
+		Quantity:         presc.Quantity,
+		Dispensed:        dispensedQuantity(presc),
+		Status:           presc.Status,
+		VerificationCode: presc.VerificationCode,
+		IssuedAt:         presc.CreatedAt,
+		ExpiresAt:        presc.ExpiresAt,
+		PatientName:      appt.Patient.User.Name,
+		DoctorName:       appt.Doctor.User.Name,
+		Dispenses:        presc.Dispenses,
+	}
+}
+
+func (s *medicalService) LookupPrescription(code string) (*PharmacyPrescription, error) {
+	presc, err := s.repo.GetPrescriptionByVerificationCode(normalizeVerificationCode(code))
+	if err != nil {
+		return nil, err
+	}
+	return pharmacyView(presc), nil
+}
+
+// DispensePrescription records a hand-over by a pharmacist and moves the prescription to
+// PartiallyDispensed, or to Dispensed when the input is final.
+func (s *medicalService) DispensePrescription(code string, pharmacistID uint, input DispenseInput) (*PharmacyPrescription, error) {
+	if input.Quantity <= 0 {
+		return nil, &ValidationError{Reason: "quantity must be a positive number"}
+	}
+
+	var updated *models.Prescription
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		txs := s.withRepo(tx.Medical)
+
+		// The row stays locked until commit, a concurrent dispense waits and then sees this one
+		presc, err := txs.repo.LockPrescriptionByVerificationCode(normalizeVerificationCode(code))
+		if err != nil {
+			return err
+		}
+
+		final := input.Final
+		if presc.Quantity > 0 {
+			remaining := presc.Quantity - dispensedQuantity(presc)
+			if input.Quantity > remaining {
+				return &ValidationError{Reason: fmt.Sprintf("only %d of %d prescribed remain to be dispensed", remaining, presc.Quantity)}
+			}
+			final = final || input.Quantity == remaining
+		}
+
+		to := models.StatusPartiallyDispensed
+		if final {
+			to = models.StatusDispensed
+		}
+		if to == presc.Status {
+			// A further partial hand-over is recorded as a dispense, the status stays as it is
+			if err := checkDispensable(presc); err != nil {
+				return err
+			}
+		} else if err := txs.transitionPrescription(presc, to); err != nil {
+			return err
+		}
+
+		dispense := &models.PrescriptionDispense{
+			PrescriptionID: presc.ID,
+			PharmacistID:   pharmacistID,
+			Quantity:       input.Quantity,
+			Notes:          strings.TrimSpace(input.Notes),
+			DispensedAt:    time.Now(),
+		}
+		if err := txs.repo.CreatePrescriptionDispense(dispense); err != nil {
+			return err
+		}
+
+		updated, err = txs.repo.GetPrescriptionByID(presc.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pharmacyView(updated), nil
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// RenewalDecision is the doctor's answer to a renewal request. Refills only applies when the
+// renewed prescription has none left to carry over, otherwise it must be omitted or match the carried over ones.
+type RenewalDecision struct {
+	Reason  string `json:"reason"`
+	Refills int    `json:"refills"`
+}
+
+// RequestPrescriptionRenewal files a renewal request for a prescription that has been at least partly dispensed.
+// A prescription that is still untouched, cancelled or already renewed cannot be renewed.
+func (s *medicalService) RequestPrescriptionRenewal(prescID, patientID uint, note string) (*models.PrescriptionRenewal, error) {
+	presc, err := s.repo.GetPrescriptionByID(prescID)
+	if err != nil {
+		return nil, err
+	}
+	switch presc.Status {
+	case models.StatusPartiallyDispensed, models.StatusDispensed, models.StatusExpired:
+	default:
+		return nil, &ConflictError{Reason: "a " + string(presc.Status) + " prescription cannot be renewed"}
+	}
+	renewed, err := s.repo.IsPrescriptionRenewed(presc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if renewed {
+		return nil, &ConflictError{Reason: "prescription has already been renewed"}
+	}
+	pending, err := s.repo.HasPendingRenewal(presc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, &ConflictError{Reason: "a renewal for this prescription is already pending"}
+	}
+
+	renewal := &models.PrescriptionRenewal{
+		PrescriptionID: presc.ID,
+		RequestedByID:  patientID,
+		Status:         models.RenewalPending,
+		Note:           strings.TrimSpace(note),
+	}
+	if err := s.repo.CreatePrescriptionRenewal(renewal); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, &ConflictError{Reason: "a renewal for this prescription is already pending"}
+		}
+		return nil, err
+	}
+	return s.repo.GetPrescriptionRenewal(renewal.ID)
+}
+
+func (s *medicalService) GetPrescriptionRenewal(id uint) (*models.PrescriptionRenewal, error) {
+	return s.repo.GetPrescriptionRenewal(id)
+}
+
+func (s *medicalService) GetPrescriptionRenewals(filter repository.RenewalFilter) ([]models.PrescriptionRenewal, error) {
+	return s.repo.GetPrescriptionRenewals(filter)
+}
+
+// ApprovePrescriptionRenewal issues a new prescription repeating the original and links it to the request.
+// The renewal goes through the same interaction and allergy checks as a new prescription.
+func (s *medicalService) ApprovePrescriptionRenewal(renewalID, doctorID uint, decision RenewalDecision) (*models.PrescriptionRenewal, error) {
+	if decision.Refills < 0 || decision.Refills > maxPrescriptionRefills {
+		return nil, &ValidationError{Reason: fmt.Sprintf("refills must be between 0 and %d", maxPrescriptionRefills)}
+	}
+
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		txs := s.withRepo(tx.Medical)
+
+		renewal, err := txs.repo.GetPrescriptionRenewal(renewalID)
+		if err != nil {
+			return err
+		}
+		if renewal.Status != models.RenewalPending {
+			return &ConflictError{Reason: "renewal request was already " + strings.ToLower(string(renewal.Status))}
+		}
+
+		orig := renewal.Prescription
+		refills := decision.Refills
+		if orig.Refills > 0 {
+			refills = orig.Refills - 1
+			if decision.Refills != 0 && decision.Refills != refills {
+				return &ValidationError{Reason: fmt.Sprintf("the renewal carries over %d refills of the original prescription, they cannot be changed", refills)}
+			}
+		}
+		renewed := &models.Prescription{
+			ConsultationID: orig.ConsultationID,
+			DrugCode:       orig.DrugCode,
+			Medication:     orig.Medication,
+			Dosage:         orig.Dosage,
+			Strength:       orig.Strength,
+			StrengthUnit:   orig.StrengthUnit,
+			Route:          orig.Route,
+			Frequency:      orig.Frequency,
+			DurationDays:   orig.DurationDays,
+			Quantity:       orig.Quantity,
+			Refills:        refills,
+			RenewedFromID:  &orig.ID,
+			Status:         models.StatusIssued,
+		}
+
+		// The patient's allergies and medications may have changed since the original was issued
+		meds := []models.Prescription{*renewed}
+		drugs, err := txs.resolveDrugs(meds)
+		if err != nil {
+			return err
+		}
+		patientID := orig.Consultation.Appointment.PatientID
+		warnings, err := txs.checkInteractions(patientID, meds, drugs)
+		if err != nil {
+			return err
+		}
+		allergyWarnings, err := txs.checkAllergies(patientID, meds, drugs)
+		if err != nil {
+			return err
+		}
+		if err := blockingWarnings(append(allergyWarnings, warnings...)); err != nil {
+			return err
+		}
+
+		if renewed.ExpiresAt, err = txs.prescriptionExpiry(time.Time{}); err != nil {
+			return err
+		}
+		if renewed.VerificationCode, err = newVerificationCode(); err != nil {
+			return err
+		}
+		if err := txs.repo.CreatePrescription(renewed); err != nil {
+			if errors.Is(err, repository.ErrConflict) {
+				return &ConflictError{Reason: "prescription has already been renewed"}
+			}
+			return err
+		}
+
+		now := time.Now()
+		renewal.Status = models.RenewalApproved
+		renewal.DecidedByID = &doctorID
+		renewal.DecisionReason = strings.TrimSpace(decision.Reason)
+		renewal.RenewalID = &renewed.ID
+		renewal.DecidedAt = &now
+		return txs.decideRenewal(renewal)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetPrescriptionRenewal(renewalID)
+}
+
+func (s *medicalService) DenyPrescriptionRenewal(renewalID, doctorID uint, reason string) (*models.PrescriptionRenewal, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, &ValidationError{Reason: "a reason is required to deny a renewal"}
+	}
+
+	renewal, err := s.repo.GetPrescriptionRenewal(renewalID)
+	if err != nil {
+		return nil, err
+	}
+	if renewal.Status != models.RenewalPending {
+		return nil, &ConflictError{Reason: "renewal request was already " + strings.ToLower(string(renewal.Status))}
+	}
+
+	now := time.Now()
+	renewal.Status = models.RenewalDenied
+	renewal.DecidedByID = &doctorID
+	renewal.DecisionReason = reason
+	renewal.DecidedAt = &now
+	if err := s.decideRenewal(renewal); err != nil {
+		return nil, err
+	}
+	return renewal, nil
+}
+
+func (s *medicalService) decideRenewal(renewal *models.PrescriptionRenewal) error {
+	if err := s.repo.DecidePrescriptionRenewal(renewal); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return &ConflictError{Reason: "renewal request was decided concurrently, reload and try again"}
+		}
+		return err
+	}
+	return nil
+}
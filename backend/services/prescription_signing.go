@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/base64"
+	"log"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/rxsign"
+)
+
+// SignedPrescription is the content of a prescription QR code along with the code to type in by hand
+type SignedPrescription struct {
+	PrescriptionID   uint   `json:"prescription_id"`
+	VerificationCode string `json:"verification_code"`
+	Payload          string `json:"payload"`
+}
+
+// PrescriptionPublicKey is the key pharmacies use to verify QR payloads offline
+type PrescriptionPublicKey struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"` // base64 encoded raw Ed25519 public key
+}
+
+// VerifiedPrescription pairs the signed claims of a payload with the prescription's current state
+type VerifiedPrescription struct {
+	Claims       *rxsign.Payload       `json:"claims"`
+	Prescription *PharmacyPrescription `json:"prescription"`
+}
+
+// loadPrescriptionSigner refuses to start with an invalid key, or without one outside development.
+// In development it falls back to a temporary key; payloads signed with it stop verifying once the
+// server restarts.
+func loadPrescriptionSigner(seed, environment string) *rxsign.Signer {
+	if seed != "" {
+		signer, err := rxsign.NewSigner(seed)
+		if err != nil {
+			log.Fatalf("Invalid PRESCRIPTION_SIGNING_KEY: %v", err)
+		}
+		return signer
+	}
+	if environment != "development" {
+		log.Fatalf("PRESCRIPTION_SIGNING_KEY is required in the %s environment", environment)
+	}
+	log.Println("WARNING: PRESCRIPTION_SIGNING_KEY is not set, using a temporary key")
+
+	seed, err := rxsign.GenerateSeed()
+	if err != nil {
+		log.Fatalf("Failed to generate a prescription signing key: %v", err)
+	}
+	signer, err := rxsign.NewSigner(seed)
+	if err != nil {
+		log.Fatalf("Failed to generate a prescription signing key: %v", err)
+	}
+	return signer
+}
+
+func (s *medicalService) GetPrescription(id uint) (*models.Prescription, error) {
+	return s.repo.GetPrescriptionByID(id)
+}
+
+// SignPrescription produces the QR payload for a prescription that can still be dispensed
+func (s *medicalService) SignPrescription(presc *models.Prescription) (*SignedPrescription, error) {
+	if presc.Status != models.StatusIssued && presc.Status != models.StatusPartiallyDispensed {
+		return nil, &ConflictError{Reason: "prescription is " + string(presc.Status) + " and can no longer be dispensed"}
+	}
+
+	appt := presc.Consultation.Appointment
+	payload, err := s.signer.Sign(rxsign.Payload{
+		PrescriptionID:   presc.ID,
+		VerificationCode: presc.VerificationCode,
+		Medication:       presc.Medication,
+		Dosage:           presc.Dosage,
+		PatientID:        appt.PatientID,
+		PatientName:      appt.Patient.User.Name,
+		DoctorID:         appt.DoctorID,
+		DoctorName:       appt.Doctor.User.Name,
+		IssuedAt:         presc.CreatedAt,
+		ExpiresAt:        presc.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SignedPrescription{
+		PrescriptionID:   presc.ID,
+		VerificationCode: presc.VerificationCode,
+		Payload:          payload,
+	}, nil
+}
+
+func (s *medicalService) PrescriptionPublicKey() PrescriptionPublicKey {
+	return PrescriptionPublicKey{
+		Algorithm: "Ed25519",
+		KeyID:     s.signer.KeyID(),
+		PublicKey: base64.StdEncoding.EncodeToString(s.signer.PublicKey()),
+	}
+}
+
+// VerifyPrescriptionPayload rejects tampered and expired payloads and looks up the prescription they name
+func (s *medicalService) VerifyPrescriptionPayload(payload string) (*VerifiedPrescription, error) {
+	claims, err := rxsign.Verify(s.signer.PublicKey(), payload, time.Now())
+	if err != nil {
+		return nil, &ValidationError{Reason: err.Error()}
+	}
+
+	presc, err := s.repo.GetPrescriptionByID(claims.PrescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if presc.VerificationCode != claims.VerificationCode {
+		return nil, &ValidationError{Reason: "prescription payload does not match the issued prescription"}
+	}
+	return &VerifiedPrescription{Claims: claims, Prescription: pharmacyView(presc)}, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+)
+
+// prescriptionTransitions lists the statuses each prescription status may move to.
+// Further partial hand-overs keep a prescription PartiallyDispensed without a transition.
+// Dispensed, Cancelled and Expired are terminal.
+var prescriptionTransitions = map[models.PrescriptionStatus][]models.PrescriptionStatus{
+	models.StatusIssued:             {models.StatusPartiallyDispensed, models.StatusDispensed, models.StatusPrescriptionCancelled, models.StatusExpired},
+	models.StatusPartiallyDispensed: {models.StatusDispensed, models.StatusPrescriptionCancelled, models.StatusExpired},
+}
+
+func isKnownPrescriptionStatus(status models.PrescriptionStatus) bool {
+	switch status {
+	case models.StatusIssued, models.StatusPartiallyDispensed, models.StatusDispensed,
+		models.StatusPrescriptionCancelled, models.StatusExpired:
+		return true
+	}
+	return false
+}
+
+func canTransitionPrescription(from, to models.PrescriptionStatus) bool {
+	for _, next := range prescriptionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionPrescription validates and applies a prescription status change
+func (s *medicalService) transitionPrescription(presc *models.Prescription, to models.PrescriptionStatus) error {
+	if !isKnownPrescriptionStatus(to) {
+		return &ValidationError{Reason: fmt.Sprintf("unknown prescription status %q", to)}
+	}
+	if !canTransitionPrescription(presc.Status, to) {
+		return &ConflictError{Reason: fmt.Sprintf("prescription cannot move from %s to %s", presc.Status, to)}
+	}
+	if to == models.StatusPartiallyDispensed || to == models.StatusDispensed {
+		if err := checkDispensable(presc); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.UpdatePrescriptionStatus(presc.ID, presc.Status, to); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return &ConflictError{Reason: "prescription status was changed concurrently, reload and try again"}
+		}
+		return err
+	}
+	presc.Status = to
+	return nil
+}
+
+// checkDispensable rejects hand-overs of a prescription past its expiry date
+func checkDispensable(presc *models.Prescription) error {
+	if time.Now().After(presc.ExpiresAt) {
+		return &ConflictError{Reason: "prescription expired on " + presc.ExpiresAt.Format(models.RFC3339NoNano)}
+	}
+	return nil
+}
+
+// prescriptionExpiry returns the requested expiry when it lies in the future, the default validity otherwise
+func (s *medicalService) prescriptionExpiry(requested time.Time) (time.Time, error) {
+	if requested.IsZero() {
+		return time.Now().Add(s.prescriptionValidity), nil
+	}
+	if !requested.After(time.Now()) {
+		return time.Time{}, &ValidationError{Reason: "prescription expiry date must be in the future"}
+	}
+	return requested, nil
+}
+
+// ExpirePrescriptions marks every open prescription past its expiry date as Expired
+func (s *medicalService) ExpirePrescriptions() (int64, error) {
+	return s.repo.ExpirePrescriptions(time.Now())
+}
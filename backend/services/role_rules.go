@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cristim67/med-monitor/backend/config"
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"github.com/cristim67/med-monitor/backend/utils"
+	"gorm.io/gorm"
+)
+
+// roleChange is a role the role rules give a user, with the reason recorded in the audit trail
+type roleChange struct {
+	role   models.UserRole
+	deptID uint
+	reason string
+}
+
+// event is the audit entry of the change; from is empty for a new account
+func (c *roleChange) event(userID uint, from models.UserRole) *models.UserRoleEvent {
+	event := &models.UserRoleEvent{UserID: userID, FromRole: from, ToRole: c.role, Reason: c.reason}
+	if c.deptID != 0 {
+		event.DepartmentID = &c.deptID
+	}
+	return event
+}
+
+// ValidateRoleRules checks the configured rules once at startup, so a typo is not discovered at someone's login
+func ValidateRoleRules(rules []config.RoleRule) error {
+	for i, rule := range rules {
+		// Group names and email addresses mean nothing outside the provider that asserts them
+		if rule.Issuer == "" {
+			return fmt.Errorf("role rule %d needs the issuer whose tokens it applies to", i+1)
+		}
+		if rule.Group == "" && rule.HostedDomain == "" && rule.EmailPattern == "" {
+			return fmt.Errorf("role rule %d has no condition", i+1)
+		}
+		if _, err := path.Match(rule.EmailPattern, ""); err != nil {
+			return fmt.Errorf("role rule %d has an invalid email_pattern: %v", i+1, err)
+		}
+		if err := checkRole(models.UserRole(rule.Role)); err != nil {
+			return fmt.Errorf("role rule %d: %v", i+1, err)
+		}
+		if models.UserRole(rule.Role) == models.RoleDoctor && rule.DepartmentID == 0 {
+			return fmt.Errorf("role rule %d assigns doctors and needs a department_id", i+1)
+		}
+	}
+	return nil
+}
+
+// matchRoleRule returns the first rule of the claims' issuer whose conditions all hold, and its position
+func matchRoleRule(rules []config.RoleRule, claims *utils.Claims) (int, *config.RoleRule) {
+	email := strings.ToLower(claims.Email)
+	for i := range rules {
+		rule := &rules[i]
+		if strings.TrimSuffix(rule.Issuer, "/") != strings.TrimSuffix(claims.Issuer, "/") {
+			continue
+		}
+		if rule.Group != "" && !slices.Contains(claims.Groups, rule.Group) {
+			continue
+		}
+		if rule.HostedDomain != "" && !strings.EqualFold(rule.HostedDomain, claims.Domain) {
+			continue
+		}
+		if rule.EmailPattern != "" {
+			if ok, _ := path.Match(strings.ToLower(rule.EmailPattern), email); !ok {
+				continue
+			}
+		}
+		return i, rule
+	}
+	return -1, nil
+}
+
+// describeRoleRule names a rule in the audit trail, e.g. `rule 2 (group "cardiology")`
+func describeRoleRule(i int, rule *config.RoleRule) string {
+	var conditions []string
+	if rule.Group != "" {
+		conditions = append(conditions, fmt.Sprintf("group %q", rule.Group))
+	}
+	if rule.HostedDomain != "" {
+		conditions = append(conditions, fmt.Sprintf("hosted domain %q", rule.HostedDomain))
+	}
+	if rule.EmailPattern != "" {
+		conditions = append(conditions, fmt.Sprintf("email %q", rule.EmailPattern))
+	}
+	return fmt.Sprintf("rule %d (%s)", i+1, strings.Join(conditions, ", "))
+}
+
+// newUserRole is the role a new account gets from the rules, nil when none matches
+func (s *userService) newUserRole(claims *utils.Claims) *roleChange {
+	i, rule := matchRoleRule(s.roleRules, claims)
+	if rule == nil {
+		return nil
+	}
+	return &roleChange{role: models.UserRole(rule.Role), deptID: rule.DepartmentID, reason: describeRoleRule(i, rule)}
+}
+
+// syncedRole works out what the rules make of an existing user, nil when nothing changes.
+// A role a rule granted is taken back once no rule matches any more; roles set by an admin stay.
+func (s *userService) syncedRole(user *models.User, claims *utils.Claims) (*roleChange, error) {
+	if user.RoleSource == models.RoleSourceAdmin {
+		return nil, nil
+	}
+	change := s.newUserRole(claims)
+	if change == nil {
+		if user.RoleSource == models.RoleSourceRule && user.Role != models.RolePatient {
+			return &roleChange{role: models.RolePatient, reason: "no role rule matches any more"}, nil
+		}
+		return nil, nil
+	}
+	if user.Role != change.role {
+		return change, nil
+	}
+	if change.role == models.RoleDoctor {
+		doc, err := s.medRepo.GetDoctorByID(user.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && doc.DepartmentID != change.deptID) {
+			return change, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// applyRoleChange saves the user with the new role, its profile and the audit entry together
+func (s *userService) applyRoleChange(user *models.User, change *roleChange) error {
+	event := change.event(user.ID, user.Role)
+	return s.uow.Transaction(func(tx repository.Repositories) error {
+		user.Role = change.role
+		user.RoleSource = models.RoleSourceRule
+		if err := tx.Users.UpdateUser(user); err != nil {
+			return err
+		}
+		if err := assignProfile(tx, user, change.deptID, nil); err != nil {
+			return err
+		}
+		return tx.Users.CreateRoleEvent(event)
+	})
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/cristim67/med-monitor/backend/config"
+	"github.com/cristim67/med-monitor/backend/utils"
+)
+
+const testIssuer = "https://sso.hospital.example.org/realms/staff"
+
+func TestMatchRoleRule(t *testing.T) {
+	rules := []config.RoleRule{
+		{Issuer: testIssuer, Group: "cardiology", Role: "doctor", DepartmentID: 2},
+		{Issuer: utils.GoogleIssuer, HostedDomain: "hospital.example.org", EmailPattern: "rx-*@hospital.example.org", Role: "pharmacist"},
+		{Issuer: utils.GoogleIssuer, HostedDomain: "hospital.example.org", Role: "doctor", DepartmentID: 1},
+	}
+	tests := []struct {
+		name   string
+		claims utils.Claims
+		want   int
+	}{
+		{name: "group", claims: utils.Claims{Issuer: testIssuer, Email: "a@gmail.com", Groups: []string{"staff", "cardiology"}}, want: 0},
+		{name: "issuer with a trailing slash", claims: utils.Claims{Issuer: testIssuer + "/", Email: "a@gmail.com", Groups: []string{"cardiology"}}, want: 0},
+		{name: "group of another issuer", claims: utils.Claims{Issuer: "https://evil.example.org", Email: "a@gmail.com", Groups: []string{"cardiology"}}, want: -1},
+		{name: "first matching rule wins", claims: utils.Claims{Issuer: utils.GoogleIssuer, Email: "rx-b@hospital.example.org", Domain: "hospital.example.org"}, want: 1},
+		{name: "all conditions of a rule must hold", claims: utils.Claims{Issuer: utils.GoogleIssuer, Email: "RX-Desk@Hospital.example.org", Domain: "HOSPITAL.example.org"}, want: 1},
+		{name: "email pattern without the domain", claims: utils.Claims{Issuer: utils.GoogleIssuer, Email: "rx-desk@hospital.example.org"}, want: -1},
+		{name: "hosted domain", claims: utils.Claims{Issuer: utils.GoogleIssuer, Email: "c@hospital.example.org", Domain: "hospital.example.org"}, want: 2},
+		{name: "no rule", claims: utils.Claims{Issuer: testIssuer, Email: "d@gmail.com", Groups: []string{"cardiology-nurses"}}, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := matchRoleRule(rules, &tt.claims); got != tt.want {
+				t.Errorf("matched rule %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRoleRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.RoleRule
+		wantErr bool
+	}{
+		{name: "valid", rule: config.RoleRule{Issuer: testIssuer, Group: "pharmacy", Role: "pharmacist"}},
+		{name: "without an issuer", rule: config.RoleRule{Group: "pharmacy", Role: "pharmacist"}, wantErr: true},
+		{name: "without a condition", rule: config.RoleRule{Issuer: testIssuer, Role: "admin"}, wantErr: true},
+		{name: "unknown role", rule: config.RoleRule{Issuer: testIssuer, Group: "pharmacy", Role: "pharmacists"}, wantErr: true},
+		{name: "doctor without a department", rule: config.RoleRule{Issuer: testIssuer, Group: "doctors", Role: "doctor"}, wantErr: true},
+		{name: "malformed email pattern", rule: config.RoleRule{Issuer: testIssuer, EmailPattern: "[a-@hospital.org", Role: "patient"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRoleRules([]config.RoleRule{tt.rule}); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRoleRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
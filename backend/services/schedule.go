@@ -0,0 +1,277 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+)
+
+// maxAvailabilityDays bounds how many days a single availability query may span
+const maxAvailabilityDays = 31
+
+// defaultSchedule applies to doctors who have not configured working hours yet
+func defaultSchedule(doctorID uint) []models.DoctorSchedule {
+	var schedules []models.DoctorSchedule
+	for day := time.Monday; day <= time.Friday; day++ {
+		schedules = append(schedules, models.DoctorSchedule{
+			DoctorID:    doctorID,
+			Weekday:     day,
+			StartTime:   "09:00",
+			EndTime:     "18:00",
+			SlotMinutes: int(DefaultAppointmentDuration / time.Minute),
+		})
+	}
+	return schedules
+}
+
+// atClock returns the instant at the given "HH:MM" time on the day of date, in date's location
+func atClock(date time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, &ValidationError{Reason: fmt.Sprintf("invalid time of day %q, expected HH:MM", clock)}
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, date.Location()), nil
+}
+
+// validateSchedule checks that the working hours, slot length and breaks of a day are consistent
+func validateSchedule(sched models.DoctorSchedule) error {
+	if sched.Weekday < time.Sunday || sched.Weekday > time.Saturday {
+		return &ValidationError{Reason: fmt.Sprintf("invalid weekday %d, expected 0 (Sunday) to 6 (Saturday)", sched.Weekday)}
+	}
+
+	// Any date works as reference, only the clock values are compared
+	ref := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	start, err := atClock(ref, sched.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := atClock(ref, sched.EndTime)
+	if err != nil {
+		return err
+	}
+	if !start.Before(end) {
+		return &ValidationError{Reason: fmt.Sprintf("%s: start time must be before end time", sched.Weekday)}
+	}
+	if sched.SlotMinutes <= 0 || time.Duration(sched.SlotMinutes)*time.Minute > end.Sub(start) {
+		return &ValidationError{Reason: fmt.Sprintf("%s: slot length must be positive and fit within working hours", sched.Weekday)}
+	}
+
+	for _, b := range sched.Breaks {
+		bStart, err := atClock(ref, b.StartTime)
+		if err != nil {
+			return err
+		}
+		bEnd, err := atClock(ref, b.EndTime)
+		if err != nil {
+			return err
+		}
+		if !bStart.Before(bEnd) || bStart.Before(start) || bEnd.After(end) {
+			return &ValidationError{Reason: fmt.Sprintf("%s: break %s-%s must lie within working hours", sched.Weekday, b.StartTime, b.EndTime)}
+		}
+	}
+	return nil
+}
+
+// daySlots splits the working hours of day into slots, skipping the ones that touch a break.
+// day must be midnight in the clinic timezone.
+func daySlots(day time.Time, sched models.DoctorSchedule) []models.TimeSlot {
+	start, err := atClock(day, sched.StartTime)
+	if err != nil {
+		return nil
+	}
+	end, err := atClock(day, sched.EndTime)
+	if err != nil {
+		return nil
+	}
+
+	type interval struct{ start, end time.Time }
+	var breaks []interval
+	for _, b := range sched.Breaks {
+		bStart, errStart := atClock(day, b.StartTime)
+		bEnd, errEnd := atClock(day, b.EndTime)
+		if errStart == nil && errEnd == nil {
+			breaks = append(breaks, interval{bStart, bEnd})
+		}
+	}
+
+	step := time.Duration(sched.SlotMinutes) * time.Minute
+	var slots []models.TimeSlot
+	for t := start; step > 0 && !t.Add(step).After(end); t = t.Add(step) {
+		slot := models.TimeSlot{Start: t, End: t.Add(step)}
+		inBreak := false
+		for _, b := range breaks {
+			if slot.Start.Before(b.end) && b.start.Before(slot.End) {
+				inBreak = true
+				break
+			}
+		}
+		if !inBreak {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// midnight truncates t to the start of its day in loc
+func midnight(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// doctorSchedules returns the configured weekly schedule of a doctor, keyed by weekday
+func (s *medicalService) doctorSchedules(doctorID uint) (map[time.Weekday]models.DoctorSchedule, error) {
+	schedules, err := s.repo.GetDoctorSchedules(doctorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(schedules) == 0 {
+		schedules = defaultSchedule(doctorID)
+	}
+
+	byDay := make(map[time.Weekday]models.DoctorSchedule, len(schedules))
+	for _, sched := range schedules {
+		byDay[sched.Weekday] = sched
+	}
+	return byDay, nil
+}
+
+// matchScheduleSlot returns the schedule slot of the doctor starting exactly at start
+func (s *medicalService) matchScheduleSlot(doctorID uint, start time.Time) (*models.TimeSlot, error) {
+	schedules, err := s.doctorSchedules(doctorID)
+	if err != nil {
+		return nil, err
+	}
+
+	day := midnight(start, s.loc)
+	sched, ok := schedules[day.Weekday()]
+	if !ok {
+		return nil, &ValidationError{Reason: fmt.Sprintf("doctor does not work on %ss", day.Weekday())}
+	}
+	for _, slot := range daySlots(day, sched) {
+		if slot.Start.Equal(start) {
+			return &slot, nil
+		}
+	}
+	return nil, &ValidationError{Reason: "requested time is outside the doctor's schedule"}
+}
+
+func (s *medicalService) GetDoctorSchedule(doctorID uint) ([]models.DoctorSchedule, error) {
+	if _, err := s.repo.GetDoctorByID(doctorID); err != nil {
+		return nil, err
+	}
+	schedules, err := s.repo.GetDoctorSchedules(doctorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(schedules) == 0 {
+		return defaultSchedule(doctorID), nil
+	}
+	return schedules, nil
+}
+
+func (s *medicalService) SetDoctorSchedule(doctorID uint, schedules []models.DoctorSchedule) error {
+	if _, err := s.repo.GetDoctorByID(doctorID); err != nil {
+		return err
+	}
+
+	seen := make(map[time.Weekday]bool)
+	for _, sched := range schedules {
+		if err := validateSchedule(sched); err != nil {
+			return err
+		}
+		if seen[sched.Weekday] {
+			return &ValidationError{Reason: fmt.Sprintf("%s is listed more than once", sched.Weekday)}
+		}
+		seen[sched.Weekday] = true
+	}
+
+	// Drop client supplied identifiers, the schedule is always recreated
+	for i := range schedules {
+		schedules[i].ID = 0
+		for j := range schedules[i].Breaks {
+			schedules[i].Breaks[j].ID = 0
+			schedules[i].Breaks[j].ScheduleID = 0
+		}
+	}
+	return s.repo.ReplaceDoctorSchedules(doctorID, schedules)
+}
+
+// parseDateRange parses the from and to dates (YYYY-MM-DD, inclusive) in the clinic timezone.
+// from defaults to today and to defaults to from; the range may span at most maxDays days.
+func (s *medicalService) parseDateRange(from, to string, maxDays int) (time.Time, time.Time, error) {
+	fromDay := midnight(time.Now(), s.loc)
+	if from != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", from, s.loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, &ValidationError{Reason: "invalid from date, expected YYYY-MM-DD"}
+		}
+		fromDay = parsed
+	}
+	toDay := fromDay
+	if to != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", to, s.loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, &ValidationError{Reason: "invalid to date, expected YYYY-MM-DD"}
+		}
+		toDay = parsed
+	}
+	if toDay.Before(fromDay) {
+		return time.Time{}, time.Time{}, &ValidationError{Reason: "to date must not be before from date"}
+	}
+	if toDay.After(fromDay.AddDate(0, 0, maxDays-1)) {
+		return time.Time{}, time.Time{}, &ValidationError{Reason: fmt.Sprintf("date range must not exceed %d days", maxDays)}
+	}
+	return fromDay, toDay, nil
+}
+
+// GetAvailableSlots lists the free slots of a doctor between the from and to dates (YYYY-MM-DD, inclusive)
+func (s *medicalService) GetAvailableSlots(doctorID uint, from, to string) ([]models.TimeSlot, error) {
+	doc, err := s.repo.GetDoctorByID(doctorID)
+	if err != nil {
+		return nil, err
+	}
+	fromDay, toDay, err := s.parseDateRange(from, to, maxAvailabilityDays)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules, err := s.doctorSchedules(doctorID)
+	if err != nil {
+		return nil, err
+	}
+	rangeEnd := toDay.AddDate(0, 0, 1)
+	booked, err := s.repo.GetActiveAppointmentsByDoctorBetween(doctorID, fromDay, rangeEnd)
+	if err != nil {
+		return nil, err
+	}
+	absences, err := s.repo.GetDoctorAbsencesBetween(doc.ID, doc.DepartmentID, fromDay, rangeEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	slots := []models.TimeSlot{}
+	for day := fromDay; !day.After(toDay); day = day.AddDate(0, 0, 1) {
+		sched, ok := schedules[day.Weekday()]
+		if !ok {
+			continue
+		}
+		for _, slot := range daySlots(day, sched) {
+			if slot.Start.Before(now) || overlapsAny(slot, booked) || absenceAt(slot, absences) != nil {
+				continue
+			}
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+func overlapsAny(slot models.TimeSlot, appts []models.Appointment) bool {
+	for _, a := range appts {
+		if slot.Start.Before(a.EndDate) && a.AppointmentDate.Before(slot.End) {
+			return true
+		}
+	}
+	return false
+}
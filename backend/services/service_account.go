@@ -0,0 +1,276 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAPIKey is returned for unknown, malformed, expired or revoked API keys
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// apiKeyTouchInterval is how often the last use of a key is written, so busy integrations do not
+// write on every request
+const apiKeyTouchInterval = time.Minute
+
+// serviceAccountDomain is the domain of the email addresses of service account users. It is reserved
+// (RFC 2606), so no identity provider can sign anyone in as a service account.
+const serviceAccountDomain = "service-accounts.invalid"
+
+var serviceAccountName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+// APIKeyInput is what an admin chooses for a new API key
+type APIKeyInput struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// IssuedAPIKey is a new API key with its secret, which is shown once and not stored
+type IssuedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// apiKeyToken joins the key id and secret into the key handed to the integration
+func apiKeyToken(keyID uint, secret string) string {
+	return strconv.FormatUint(uint64(keyID), 10) + "." + secret
+}
+
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return &ValidationError{Reason: "an API key needs at least one scope"}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.APIScopes, models.APIScope(scope)) {
+			return &ValidationError{Reason: fmt.Sprintf("unknown scope %q", scope)}
+		}
+	}
+	return nil
+}
+
+// CreateServiceAccount creates a service account together with the user it acts as
+func (s *userService) CreateServiceAccount(name, description string, createdByID uint) (*models.ServiceAccount, error) {
+	name = strings.TrimSpace(name)
+	if !serviceAccountName.MatchString(name) {
+		return nil, &ValidationError{Reason: "name must be 2 to 64 lowercase letters, digits or dashes"}
+	}
+	account := &models.ServiceAccount{Name: name, Description: strings.TrimSpace(description), CreatedByID: createdByID}
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		user := &models.User{
+			Email:      name + "@" + serviceAccountDomain,
+			Name:       name,
+			Role:       models.RoleService,
+			RoleSource: models.RoleSourceAdmin,
+		}
+		if err := tx.Users.CreateUser(user); err != nil {
+			return err
+		}
+		account.UserID = user.ID
+		return tx.Users.CreateServiceAccount(account)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, &ConflictError{Reason: fmt.Sprintf("a service account named %q already exists", name)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *userService) GetServiceAccounts() ([]models.ServiceAccount, error) {
+	return s.repo.GetServiceAccounts()
+}
+
+func (s *userService) GetServiceAccount(id uint) (*models.ServiceAccount, error) {
+	return s.repo.GetServiceAccount(id)
+}
+
+// DisableServiceAccount revokes a service account and all of its keys. The account is kept, since
+// the records it wrote still reference it.
+func (s *userService) DisableServiceAccount(id uint) error {
+	return s.uow.Transaction(func(tx repository.Repositories) error {
+		account, err := tx.Users.GetServiceAccount(id)
+		if err != nil {
+			return err
+		}
+		if account.DisabledAt != nil {
+			return nil
+		}
+		now := time.Now()
+		account.DisabledAt = &now
+		if err := tx.Users.UpdateServiceAccount(account); err != nil {
+			return err
+		}
+		return tx.Users.RevokeAPIKeys(account.ID, now)
+	})
+}
+
+// activeServiceAccount loads a service account that may still be given keys
+func activeServiceAccount(tx repository.Repositories, id uint) (*models.ServiceAccount, error) {
+	account, err := tx.Users.GetServiceAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if account.DisabledAt != nil {
+		return nil, &ConflictError{Reason: "service account has been disabled"}
+	}
+	return account, nil
+}
+
+// accountKey loads a key and makes sure it belongs to the service account in the URL
+func accountKey(tx repository.Repositories, accountID, keyID uint) (*models.APIKey, error) {
+	key, err := tx.Users.GetAPIKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.ServiceAccountID != accountID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return key, nil
+}
+
+// issueAPIKey stores a new key and returns it with its secret
+func issueAPIKey(tx repository.Repositories, key *models.APIKey) (*IssuedAPIKey, error) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	key.SecretHash = hash
+	if err := tx.Users.CreateAPIKey(key); err != nil {
+		return nil, err
+	}
+	return &IssuedAPIKey{APIKey: *key, Key: apiKeyToken(key.ID, secret)}, nil
+}
+
+// CreateAPIKey issues a key with the given scopes to a service account
+func (s *userService) CreateAPIKey(accountID, createdByID uint, input APIKeyInput) (*IssuedAPIKey, error) {
+	if err := checkScopes(input.Scopes); err != nil {
+		return nil, err
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, &ValidationError{Reason: "expires_at must be in the future"}
+	}
+
+	var issued *IssuedAPIKey
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		if _, err := activeServiceAccount(tx, accountID); err != nil {
+			return err
+		}
+		var err error
+		issued, err = issueAPIKey(tx, &models.APIKey{
+			ServiceAccountID: accountID,
+			Name:             strings.TrimSpace(input.Name),
+			Scopes:           models.StringList(input.Scopes),
+			ExpiresAt:        input.ExpiresAt,
+			CreatedByID:      createdByID,
+		})
+		return err
+	})
+	return issued, err
+}
+
+// RotateAPIKey replaces a key with a new one of the same name, scopes and expiry. The old key keeps
+// working for the grace period, so the integration can switch over without downtime.
+func (s *userService) RotateAPIKey(accountID, keyID, createdByID uint, grace time.Duration) (*IssuedAPIKey, error) {
+	if grace < 0 {
+		return nil, &ValidationError{Reason: "grace period must not be negative"}
+	}
+
+	var issued *IssuedAPIKey
+	err := s.uow.Transaction(func(tx repository.Repositories) error {
+		if _, err := activeServiceAccount(tx, accountID); err != nil {
+			return err
+		}
+		old, err := accountKey(tx, accountID, keyID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if old.RevokedAt != nil || (old.ExpiresAt != nil && !now.Before(*old.ExpiresAt)) {
+			return &ConflictError{Reason: "API key is no longer active, create a new one instead"}
+		}
+
+		issued, err = issueAPIKey(tx, &models.APIKey{
+			ServiceAccountID: accountID,
+			Name:             old.Name,
+			Scopes:           old.Scopes,
+			ExpiresAt:        old.ExpiresAt,
+			RotatedFromID:    &old.ID,
+			CreatedByID:      createdByID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if grace == 0 {
+			old.RevokedAt = &now
+		} else if until := now.Add(grace); old.ExpiresAt == nil || until.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &until
+		}
+		return tx.Users.UpdateAPIKey(old)
+	})
+	return issued, err
+}
+
+// RevokeAPIKey disables a key immediately
+func (s *userService) RevokeAPIKey(accountID, keyID uint) error {
+	return s.uow.Transaction(func(tx repository.Repositories) error {
+		key, err := accountKey(tx, accountID, keyID)
+		if err != nil {
+			return err
+		}
+		if key.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		key.RevokedAt = &now
+		return tx.Users.UpdateAPIKey(key)
+	})
+}
+
+// AuthenticateAPIKey resolves an API key of the form "<key id>.<secret>" to the key with its service
+// account, and records its use
+func (s *userService) AuthenticateAPIKey(token string) (*models.APIKey, error) {
+	idStr, secret, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetAPIKey(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) ||
+		key.ServiceAccount == nil || key.ServiceAccount.DisabledAt != nil || key.ServiceAccount.User.ID == 0 {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.repo.TouchAPIKey(key.ID, now); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
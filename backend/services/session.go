@@ -0,0 +1,229 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/models"
+	"github.com/cristim67/med-monitor/backend/repository"
+	"github.com/cristim67/med-monitor/backend/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidSession is returned for access tokens that do not verify or whose session has ended
+	ErrInvalidSession = errors.New("invalid or expired session, sign in again")
+	// ErrInvalidRefreshToken is returned for unknown, malformed, expired or already used refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token, sign in again")
+)
+
+// Reasons recorded on revoked sessions
+const (
+	revokedByLogout    = "logout"
+	revokedByLogoutAll = "logout of all devices"
+	revokedByAdmin     = "revoked by an admin"
+	revokedByReuse     = "refresh token reused"
+)
+
+// SessionTokens is what a client receives when a session starts or is refreshed
+type SessionTokens struct {
+	AccessToken      string       `json:"access_token"`
+	TokenType        string       `json:"token_type"`
+	ExpiresAt        time.Time    `json:"expires_at"` // of the access token
+	RefreshToken     string       `json:"refresh_token"`
+	RefreshExpiresAt time.Time    `json:"refresh_expires_at"`
+	User             *models.User `json:"user"`
+}
+
+// SessionClient identifies the client a session was started from, so users and admins can tell sessions apart
+type SessionClient struct {
+	UserAgent string
+	IPAddress string
+}
+
+// loadAccessTokens builds the access token signer; like the prescription signing key, only
+// development may run without a configured secret
+func loadAccessTokens(secret, environment string, ttl time.Duration) *utils.AccessTokens {
+	if secret == "" {
+		if environment != "development" {
+			log.Fatalf("SESSION_SECRET is required in the %s environment", environment)
+		}
+		log.Println("WARNING: SESSION_SECRET is not set, using a temporary secret; sessions end on restart")
+		var err error
+		if secret, _, err = newSecret(); err != nil {
+			log.Fatalf("Failed to generate a session secret: %v", err)
+		}
+	}
+	tokens, err := utils.NewAccessTokens(secret, ttl)
+	if err != nil {
+		log.Fatalf("Invalid session configuration: %v", err)
+	}
+	return tokens
+}
+
+// refreshToken joins the session id and secret into the refresh token handed to the client
+func refreshToken(sessionID uint, secret string) string {
+	return strconv.FormatUint(uint64(sessionID), 10) + "." + secret
+}
+
+// StartSession opens a session for a signed-in user and issues its first token pair
+func (s *userService) StartSession(user *models.User, client SessionClient) (*SessionTokens, error) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hash,
+		UserAgent:        client.UserAgent,
+		IPAddress:        client.IPAddress,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.refreshTTL),
+	}
+	if err := s.repo.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return s.sessionTokens(user, session, secret)
+}
+
+// ExchangeToken signs in with a verified identity provider token and starts a session. This is the
+// only place the account is created or its profile updated from the provider's claims.
+func (s *userService) ExchangeToken(claims *utils.Claims, client SessionClient) (*SessionTokens, error) {
+	user, err := s.GetOrCreateUserByClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return s.StartSession(user, client)
+}
+
+// RefreshSession trades a refresh token for a new token pair. The refresh token is single use:
+// presenting the one the last refresh replaced means it leaked, and ends the session. Other wrong
+// secrets are only refused, the session id is not secret and guessing must not log anyone out.
+func (s *userService) RefreshSession(token string) (*SessionTokens, error) {
+	idStr, secret, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.repo.GetSession(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || !now.Before(session.ExpiresAt) || session.User.ID == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session.RefreshTokenHash)) != 1 {
+		if session.PreviousHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(session.PreviousHash)) != 1 {
+			return nil, ErrInvalidRefreshToken
+		}
+		if err := s.repo.RevokeSession(session.ID, revokedByReuse, now); err != nil {
+			return nil, err
+		}
+		log.Printf("Revoked session %d of user %d: a used refresh token was presented", session.ID, session.UserID)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	secret, newHash, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = now.Add(s.refreshTTL)
+	err = s.repo.RotateSessionToken(session.ID, session.RefreshTokenHash, newHash, now, session.ExpiresAt)
+	if errors.Is(err, repository.ErrConflict) {
+		// A concurrent refresh with the same token won, this one is the stale copy
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.sessionTokens(&session.User, session, secret)
+}
+
+func (s *userService) sessionTokens(user *models.User, session *models.Session, secret string) (*SessionTokens, error) {
+	access, expiresAt, err := s.tokens.Issue(user.ID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken(session.ID, secret),
+		RefreshExpiresAt: session.ExpiresAt,
+		User:             user,
+	}, nil
+}
+
+// AuthenticateAccessToken resolves an access token to its user and session. It only reads: the role
+// and profile come from the database as they are now, so role changes and revocations apply at once.
+func (s *userService) AuthenticateAccessToken(token string) (*models.User, uint, error) {
+	userID, sessionID, err := s.tokens.Verify(token)
+	if err != nil {
+		return nil, 0, ErrInvalidSession
+	}
+	session, err := s.repo.GetSession(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, 0, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if session.UserID != userID || session.User.ID == 0 || session.RevokedAt != nil || !time.Now().Before(session.ExpiresAt) {
+		return nil, 0, ErrInvalidSession
+	}
+	return &session.User, session.ID, nil
+}
+
+// EndSession logs a user out of one of their own sessions
+func (s *userService) EndSession(userID, sessionID uint) error {
+	session, err := s.repo.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	return s.repo.RevokeSession(sessionID, revokedByLogout, time.Now())
+}
+
+// EndAllSessions logs a user out on every device and returns how many sessions were open
+func (s *userService) EndAllSessions(userID uint) (int64, error) {
+	return s.repo.RevokeUserSessions(userID, revokedByLogoutAll, time.Now())
+}
+
+func (s *userService) GetActiveSessions(userID uint) ([]models.Session, error) {
+	if _, err := s.repo.FindByID(userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetActiveSessions(userID, time.Now())
+}
+
+// RevokeSession ends any session on behalf of an admin
+func (s *userService) RevokeSession(sessionID uint) error {
+	if _, err := s.repo.GetSession(sessionID); err != nil {
+		return err
+	}
+	return s.repo.RevokeSession(sessionID, revokedByAdmin, time.Now())
+}
+
+// RevokeUserSessions ends every session of a user on behalf of an admin
+func (s *userService) RevokeUserSessions(userID uint) (int64, error) {
+	if _, err := s.repo.FindByID(userID); err != nil {
+		return 0, err
+	}
+	return s.repo.RevokeUserSessions(userID, revokedByAdmin, time.Now())
+}
@@ -2,7 +2,11 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"slices"
+	"time"
 
+	"github.com/cristim67/med-monitor/backend/config"
 	"github.com/cristim67/med-monitor/backend/models"
 	"github.com/cristim67/med-monitor/backend/repository"
 	"github.com/cristim67/med-monitor/backend/utils"
@@ -10,70 +14,141 @@ import (
 )
 
 type UserService interface {
-	GetOrCreateUserByClaims(claims *utils.GoogleClaims) (*models.User, error)
+	GetOrCreateUserByClaims(claims *utils.Claims) (*models.User, error)
+	GetOrCreateDevUser(claims *utils.Claims, role models.UserRole, deptID uint, spec string) (*models.User, error)
 	GetAllUsers() ([]models.User, error)
 	UpdateUserRole(id uint, role string, deptID uint, spec string) error
+	GetRoleEvents(userID uint) ([]models.UserRoleEvent, error)
+
+	// Sessions
+	ExchangeToken(claims *utils.Claims, client SessionClient) (*SessionTokens, error)
+	StartSession(user *models.User, client SessionClient) (*SessionTokens, error)
+	RefreshSession(token string) (*SessionTokens, error)
+	AuthenticateAccessToken(token string) (*models.User, uint, error)
+	EndSession(userID, sessionID uint) error
+	EndAllSessions(userID uint) (int64, error)
+	GetActiveSessions(userID uint) ([]models.Session, error)
+	RevokeSession(sessionID uint) error
+	RevokeUserSessions(userID uint) (int64, error)
+
+	// Service accounts and API keys
+	CreateServiceAccount(name, description string, createdByID uint) (*models.ServiceAccount, error)
+	GetServiceAccounts() ([]models.ServiceAccount, error)
+	GetServiceAccount(id uint) (*models.ServiceAccount, error)
+	DisableServiceAccount(id uint) error
+	CreateAPIKey(accountID, createdByID uint, input APIKeyInput) (*IssuedAPIKey, error)
+	RotateAPIKey(accountID, keyID, createdByID uint, grace time.Duration) (*IssuedAPIKey, error)
+	RevokeAPIKey(accountID, keyID uint) error
+	AuthenticateAPIKey(token string) (*models.APIKey, error)
 }
 
 type userService struct {
 	repo    repository.UserRepository
 	medRepo repository.MedicalRepository
+	uow     repository.UnitOfWork
+
+	roleRules []config.RoleRule
+	syncRoles bool // re-apply the role rules on every login
+
+	tokens     *utils.AccessTokens
+	refreshTTL time.Duration
 }
 
-func NewUserService(repo repository.UserRepository, medRepo repository.MedicalRepository) UserService {
-	return &userService{repo: repo, medRepo: medRepo}
+func NewUserService(repo repository.UserRepository, medRepo repository.MedicalRepository, uow repository.UnitOfWork) UserService {
+	return &userService{
+		repo:      repo,
+		medRepo:   medRepo,
+		uow:       uow,
+		roleRules: config.AppConfig.RoleRules,
+		syncRoles: config.AppConfig.RoleSyncOnLogin,
+
+		tokens:     loadAccessTokens(config.AppConfig.SessionSecret, config.AppConfig.Environment, config.AppConfig.AccessTokenTTL),
+		refreshTTL: config.AppConfig.RefreshTokenTTL,
+	}
 }
 
 func (s *userService) GetAllUsers() ([]models.User, error) {
 	return s.repo.GetAllUsers()
 }
 
-func (s *userService) UpdateUserRole(id uint, role string, deptID uint, spec string) error {
-	user, err := s.repo.FindByID(id)
-	if err != nil {
-		return err
+func (s *userService) GetRoleEvents(userID uint) ([]models.UserRoleEvent, error) {
+	if _, err := s.repo.FindByID(userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetRoleEvents(userID)
+}
+
+// checkRole rejects roles no Casbin policy knows about, such a user could not use the API at all
+func checkRole(role models.UserRole) error {
+	switch role {
+	case models.RoleAdmin, models.RoleDoctor, models.RolePatient, models.RolePharmacist:
+		return nil
 	}
+	return &ValidationError{Reason: fmt.Sprintf("unknown role %q", role)}
+}
 
-	user.Role = models.UserRole(role)
-	if err := s.repo.UpdateUser(user); err != nil {
+func (s *userService) UpdateUserRole(id uint, role string, deptID uint, spec string) error {
+	if err := checkRole(models.UserRole(role)); err != nil {
 		return err
 	}
 
-	// Create/Update specific profile if needed
-	if user.Role == models.RoleDoctor {
-		doc, err := s.medRepo.GetDoctorByID(id)
+	// The role change and the matching profile are written together or not at all
+	return s.uow.Transaction(func(tx repository.Repositories) error {
+		user, err := tx.Users.FindByID(id)
 		if err != nil {
-			// Create doctor profile
-			doctor := &models.Doctor{
-				ID:             id,
-				DepartmentID:   deptID,
-				Specialization: spec,
-			}
-			return s.medRepo.CreateDoctor(doctor)
-		} else {
-			// Update doctor profile
-			doc.DepartmentID = deptID
-			doc.Specialization = spec
-			return s.medRepo.UpdateDoctor(doc)
-		}
-	} else if user.Role == models.RolePatient {
-		_, err := s.medRepo.GetPatientByID(id)
+			return err
+		}
+		if user.Role == models.RoleService {
+			return &ValidationError{Reason: "service accounts get their permissions from the scopes of their API keys"}
+		}
+
+		user.Role = models.UserRole(role)
+		user.RoleSource = models.RoleSourceAdmin
+		if err := tx.Users.UpdateUser(user); err != nil {
+			return err
+		}
+		return assignProfile(tx, user, deptID, &spec)
+	})
+}
+
+// assignProfile creates or updates the doctor or patient profile matching the user's role.
+// A nil spec keeps the specialization of an existing doctor profile.
+func assignProfile(tx repository.Repositories, user *models.User, deptID uint, spec *string) error {
+	switch user.Role {
+	case models.RoleDoctor:
+		doc, err := tx.Medical.GetDoctorByID(user.ID)
 		if err != nil {
-			patient := &models.Patient{
-				ID: id,
+			doctor := &models.Doctor{ID: user.ID, DepartmentID: deptID}
+			if spec != nil {
+				doctor.Specialization = *spec
 			}
-			return s.repo.CreatePatient(patient)
+			return tx.Medical.CreateDoctor(doctor)
+		}
+		doc.DepartmentID = deptID
+		if spec != nil {
+			doc.Specialization = *spec
+		}
+		return tx.Medical.UpdateDoctor(doc)
+	case models.RolePatient:
+		if _, err := tx.Medical.GetPatientByID(user.ID); err != nil {
+			return tx.Users.CreatePatient(&models.Patient{ID: user.ID})
 		}
 	}
-
 	return nil
 }
 
-func (s *userService) GetOrCreateUserByClaims(claims *utils.GoogleClaims) (*models.User, error) {
+func (s *userService) GetOrCreateUserByClaims(claims *utils.Claims) (*models.User, error) {
 	user, err := s.repo.FindByEmail(claims.Email)
 	if err == nil {
+		if err := checkIdentity(user, claims); err != nil {
+			return nil, err
+		}
 		// User exists, optionally update picture and name if they changed
 		updated := false
+		if user.Issuer == "" {
+			user.Issuer, user.Subject = claims.Issuer, claims.Subject
+			updated = true
+		}
 		if user.Picture != claims.Picture && claims.Picture != "" {
 			user.Picture = claims.Picture
 			updated = true
@@ -86,6 +161,25 @@ func (s *userService) GetOrCreateUserByClaims(claims *utils.GoogleClaims) (*mode
 			user.GoogleID = claims.GoogleID
 			updated = true
 		}
+		// Providers without a groups claim leave the stored groups alone
+		if claims.Groups != nil && !slices.Equal(user.Groups, claims.Groups) {
+			user.Groups = claims.Groups
+			updated = true
+		}
+
+		if s.syncRoles {
+			change, err := s.syncedRole(user, claims)
+			if err != nil {
+				return nil, err
+			}
+			if change != nil {
+				// Saves the profile fields updated above as well
+				if err := s.applyRoleChange(user, change); err != nil {
+					return nil, err
+				}
+				return user, nil
+			}
+		}
 
 		if updated {
 			if err := s.repo.UpdateUser(user); err != nil {
@@ -97,26 +191,47 @@ func (s *userService) GetOrCreateUserByClaims(claims *utils.GoogleClaims) (*mode
 	}
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		// Create default admin for dev purposes (or patient as per actual business logic)
+		// New accounts are patients unless a role rule says otherwise
 		newUser := &models.User{
-			Email:    claims.Email,
-			GoogleID: claims.GoogleID,
-			Name:     claims.Name,
-			Picture:  claims.Picture,
-			Role:     models.RolePatient,
+			Email:      claims.Email,
+			Issuer:     claims.Issuer,
+			Subject:    claims.Subject,
+			GoogleID:   claims.GoogleID,
+			Name:       claims.Name,
+			Picture:    claims.Picture,
+			Groups:     claims.Groups,
+			Role:       models.RolePatient,
+			RoleSource: models.RoleSourceDefault,
 		}
-
-		if err := s.repo.CreateUser(newUser); err != nil {
-			return nil, err
+		change := s.newUserRole(claims)
+		if change != nil {
+			newUser.Role = change.role
+			newUser.RoleSource = models.RoleSourceRule
 		}
 
-		if newUser.Role == models.RolePatient {
-			patientDetails := &models.Patient{
-				ID: newUser.ID,
+		// The user, its profile and the audit entry are created atomically, so a failure never leaves a user without a profile
+		err := s.uow.Transaction(func(tx repository.Repositories) error {
+			if err := tx.Users.CreateUser(newUser); err != nil {
+				return err
+			}
+			if change == nil {
+				return assignProfile(tx, newUser, 0, nil)
+			}
+			if err := assignProfile(tx, newUser, change.deptID, nil); err != nil {
+				return err
 			}
-			if err := s.repo.CreatePatient(patientDetails); err != nil {
+			return tx.Users.CreateRoleEvent(change.event(newUser.ID, ""))
+		})
+		if errors.Is(err, repository.ErrConflict) {
+			// A concurrent first request for the same account created it in the meantime
+			user, err := s.repo.FindByEmail(claims.Email)
+			if err != nil {
 				return nil, err
 			}
+			return user, checkIdentity(user, claims)
+		}
+		if err != nil {
+			return nil, err
 		}
 
 		return newUser, nil
@@ -124,3 +239,50 @@ func (s *userService) GetOrCreateUserByClaims(claims *utils.GoogleClaims) (*mode
 
 	return nil, err
 }
+
+// checkIdentity refuses a sign-in through another identity provider account than the one the user signed up
+// with. Accounts are found by email, without the check any provider asserting the address would get the account.
+func checkIdentity(user *models.User, claims *utils.Claims) error {
+	if user.Role == models.RoleService {
+		return &ForbiddenError{Reason: "service accounts authenticate with API keys"}
+	}
+	// Accounts created before identities were recorded are bound by their next sign-in
+	if user.Issuer == "" {
+		return nil
+	}
+	if user.Issuer != claims.Issuer || user.Subject != claims.Subject {
+		return &ForbiddenError{Reason: "the account of " + user.Email + " is linked to another identity provider account"}
+	}
+	return nil
+}
+
+// GetOrCreateDevUser signs in a local development account and gives it the requested role,
+// so every role can be exercised without an identity provider
+func (s *userService) GetOrCreateDevUser(claims *utils.Claims, role models.UserRole, deptID uint, spec string) (*models.User, error) {
+	if role == "" {
+		role = models.RolePatient
+	}
+	if err := checkRole(role); err != nil {
+		return nil, err
+	}
+	if role == models.RoleDoctor {
+		if deptID == 0 {
+			return nil, &ValidationError{Reason: "department_id is required for doctors"}
+		}
+		if _, err := s.medRepo.GetDepartmentByID(deptID); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.GetOrCreateUserByClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role && role != models.RoleDoctor {
+		return user, nil
+	}
+	if err := s.UpdateUserRole(user.ID, string(role), deptID, spec); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByID(user.ID)
+}
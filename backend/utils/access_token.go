@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	sessionIssuer   = "med-monitor"
+	sessionAudience = "med-monitor-api"
+
+	minSessionSecretLength = 32
+)
+
+type accessTokenClaims struct {
+	SessionID uint `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokens issues and verifies the short-lived HS256 access tokens of an API session.
+// They carry only the user and session ids, everything else is read from the session.
+type AccessTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewAccessTokens(secret string, ttl time.Duration) (*AccessTokens, error) {
+	if len(secret) < minSessionSecretLength {
+		return nil, errors.New("session secret must be at least 32 characters")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("access token lifetime must be positive")
+	}
+	return &AccessTokens{secret: []byte(secret), ttl: ttl}, nil
+}
+
+// Issue signs an access token for the session and returns it with its expiry
+func (a *AccessTokens) Issue(userID, sessionID uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    sessionIssuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Audience:  jwt.ClaimStrings{sessionAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	signed, err := token.SignedString(a.secret)
+	return signed, expiresAt, err
+}
+
+// Verify checks the signature and expiry of an access token and returns its user and session ids
+func (a *AccessTokens) Verify(token string) (uint, uint, error) {
+	var claims accessTokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(sessionIssuer),
+		jwt.WithAudience(sessionAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, 0, errors.New("invalid access token: " + err.Error())
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil || userID == 0 || claims.SessionID == 0 {
+		return 0, 0, errors.New("invalid access token: missing user or session")
+	}
+	return uint(userID), claims.SessionID, nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessTokens(t *testing.T) {
+	if _, err := NewAccessTokens("too short", time.Minute); err == nil {
+		t.Error("expected a short secret to be refused")
+	}
+
+	a, err := NewAccessTokens(testLocalSecret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := a.Issue(7, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, sessionID, err := a.Verify(token)
+	if err != nil || userID != 7 || sessionID != 42 {
+		t.Fatalf("Verify() = %d, %d, %v, want 7, 42", userID, sessionID, err)
+	}
+
+	other, err := NewAccessTokens(testLocalSecret+"-other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := other.Verify(token); err == nil {
+		t.Error("expected a token signed with another secret to be refused")
+	}
+
+	expired, err := NewAccessTokens(testLocalSecret, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err = expired.Issue(7, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, _, err := expired.Verify(token); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+
+	// ID tokens of the local provider are not access tokens
+	idToken, _, err := newTestLocalJWT(t, testLocalSecret, time.Minute).Issue(Claims{Email: "doctor@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Verify(idToken); err == nil {
+		t.Error("expected an ID token to be refused")
+	}
+}
@@ -3,52 +3,121 @@ package utils
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
-	"github.com/cristim67/med-monitor/backend/config"
+	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/api/idtoken"
 )
 
-type GoogleClaims struct {
+// Claims is the identity asserted by a verified token
+type Claims struct {
+	Issuer   string // the provider that asserted the identity
+	Subject  string // the provider's stable id of the account, unlike the email it is never reassigned
 	Email    string
-	GoogleID string
+	GoogleID string // set only for Google ID tokens
 	Name     string
 	Picture  string
+	Groups   []string // nil when the provider does not assert groups
+	Domain   string   // hd claim: the Google Workspace or organization domain of the account
 }
 
-func ValidateAndExtractClaims(authHeader string) (*GoogleClaims, error) {
+// TokenVerifier checks a bearer token and returns the identity it asserts
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// IssuerVerifier is a TokenVerifier responsible for the tokens of particular issuers
+type IssuerVerifier interface {
+	TokenVerifier
+	Accepts(issuer string) bool
+}
+
+// Verifiers hands each token to the verifier responsible for its issuer
+type Verifiers []IssuerVerifier
+
+func (v Verifiers) Verify(ctx context.Context, token string) (*Claims, error) {
+	issuer, err := tokenIssuer(token)
+	if err != nil {
+		return nil, err
+	}
+	for _, verifier := range v {
+		if verifier.Accepts(issuer) {
+			return verifier.Verify(ctx, token)
+		}
+	}
+	return nil, fmt.Errorf("tokens issued by %q are not accepted", issuer)
+}
+
+// tokenIssuer reads the iss claim of a JWT without verifying it, only to pick the verifier
+func tokenIssuer(token string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return "", errors.New("malformed token: " + err.Error())
+	}
+	if claims.Issuer == "" {
+		return "", errors.New("token has no issuer")
+	}
+	return claims.Issuer, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>" header
+func BearerToken(authHeader string) (string, error) {
 	if authHeader == "" {
-		return nil, errors.New("unauthorized, provide Bearer token in Authorization header")
+		return "", errors.New("unauthorized, provide Bearer token in Authorization header")
 	}
 
 	parts := strings.SplitN(authHeader, " ", 2)
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return nil, errors.New("authorization header format must be Bearer {token}")
+		return "", errors.New("authorization header format must be Bearer {token}")
 	}
+	return parts[1], nil
+}
+
+// GoogleIssuer is the issuer recorded for Google accounts, Google tokens carry it with or without the scheme
+const GoogleIssuer = "https://accounts.google.com"
+
+// GoogleVerifier validates Google ID tokens issued to the configured OAuth client
+type GoogleVerifier struct {
+	ClientID string
+}
+
+func NewGoogleVerifier(clientID string) *GoogleVerifier {
+	return &GoogleVerifier{ClientID: clientID}
+}
 
-	tokenStr := parts[1]
+func (g *GoogleVerifier) Accepts(issuer string) bool {
+	return issuer == "accounts.google.com" || issuer == "https://accounts.google.com"
+}
 
-	// Validate with Google
-	payload, err := idtoken.Validate(context.Background(), tokenStr, config.AppConfig.GoogleClientID)
+func (g *GoogleVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	payload, err := idtoken.Validate(ctx, token, g.ClientID)
 	if err != nil {
 		return nil, errors.New("invalid Google Token: " + err.Error())
 	}
 
-	if payload.Claims["email"] == nil {
+	email, ok := payload.Claims["email"].(string)
+	if !ok || email == "" {
 		return nil, errors.New("email not found in token")
 	}
+	if verified, _ := payload.Claims["email_verified"].(bool); !verified {
+		return nil, errors.New("email in token is not verified")
+	}
 
-	claims := &GoogleClaims{
-		Email:    payload.Claims["email"].(string),
+	claims := &Claims{
+		Issuer:   GoogleIssuer,
+		Subject:  payload.Subject,
+		Email:    email,
 		GoogleID: payload.Subject,
 	}
-
 	if name, ok := payload.Claims["name"].(string); ok {
 		claims.Name = name
 	}
 	if pic, ok := payload.Claims["picture"].(string); ok {
 		claims.Picture = pic
 	}
-
+	if hd, ok := payload.Claims["hd"].(string); ok {
+		claims.Domain = hd
+	}
 	return claims, nil
 }
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testLocalSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestLocalJWT(t *testing.T, secret string, ttl time.Duration) *LocalJWT {
+	t.Helper()
+	l, err := NewLocalJWT(secret, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestNewLocalJWT(t *testing.T) {
+	if _, err := NewLocalJWT("too short", time.Hour); err == nil {
+		t.Error("expected a short secret to be refused")
+	}
+	if _, err := NewLocalJWT(testLocalSecret, 0); err == nil {
+		t.Error("expected a zero lifetime to be refused")
+	}
+}
+
+func TestLocalJWTIssueVerify(t *testing.T) {
+	l := newTestLocalJWT(t, testLocalSecret, time.Hour)
+	token, expiresAt, err := l.Issue(Claims{Email: "doctor@example.org", Name: "Dr. Smith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want an hour", until)
+	}
+
+	claims, err := l.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.Email != "doctor@example.org" || claims.Name != "Dr. Smith" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestLocalJWTRejects(t *testing.T) {
+	l := newTestLocalJWT(t, testLocalSecret, time.Hour)
+
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		t.Helper()
+		base := jwt.MapClaims{
+			"iss":   LocalIssuer,
+			"aud":   "med-monitor",
+			"sub":   "doctor@example.org",
+			"email": "doctor@example.org",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			if v == nil {
+				delete(base, k)
+			} else {
+				base[k] = v
+			}
+		}
+		signed, err := jwt.NewWithClaims(method, base).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	other, _, err := newTestLocalJWT(t, strings.Repeat("x", 32), time.Hour).Issue(Claims{Email: "doctor@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "signed with another secret", token: other},
+		{name: "expired", token: sign(jwt.SigningMethodHS256, []byte(testLocalSecret), jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})},
+		{name: "without expiry", token: sign(jwt.SigningMethodHS256, []byte(testLocalSecret), jwt.MapClaims{"exp": nil})},
+		{name: "another issuer", token: sign(jwt.SigningMethodHS256, []byte(testLocalSecret), jwt.MapClaims{"iss": "https://accounts.google.com"})},
+		{name: "another audience", token: sign(jwt.SigningMethodHS256, []byte(testLocalSecret), jwt.MapClaims{"aud": "someone-else"})},
+		{name: "without email", token: sign(jwt.SigningMethodHS256, []byte(testLocalSecret), jwt.MapClaims{"email": nil})},
+		{name: "HS512 with the same secret", token: sign(jwt.SigningMethodHS512, []byte(testLocalSecret), nil)},
+		{name: "unsigned", token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := l.Verify(context.Background(), tt.token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+const stubEmail = "patient@idp.example.org"
+
+// stubVerifier accepts any token of its issuer as the same account
+type stubVerifier struct{ issuer string }
+
+func (v stubVerifier) Accepts(issuer string) bool { return issuer == v.issuer }
+
+func (v stubVerifier) Verify(_ context.Context, _ string) (*Claims, error) {
+	return &Claims{Email: stubEmail}, nil
+}
+
+func TestVerifiersRouteByIssuer(t *testing.T) {
+	const provider = "https://idp.example.org"
+	local := newTestLocalJWT(t, testLocalSecret, time.Hour)
+	verifiers := Verifiers{stubVerifier{provider}, local}
+
+	localToken, _, err := local.Issue(Claims{Email: "admin@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verifiers.Verify(context.Background(), localToken)
+	if err != nil || claims.Email != "admin@example.org" {
+		t.Fatalf("local token: claims %+v, error %v", claims, err)
+	}
+
+	providerToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": provider}).SignedString([]byte("irrelevant"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = verifiers.Verify(context.Background(), providerToken)
+	if err != nil || claims.Email != stubEmail {
+		t.Fatalf("provider token: claims %+v, error %v", claims, err)
+	}
+
+	// Without local login configured, its tokens are refused instead of reaching another verifier
+	if _, err := (Verifiers{stubVerifier{provider}}).Verify(context.Background(), localToken); err == nil {
+		t.Error("expected a local token to be refused when local login is disabled")
+	}
+	if _, err := verifiers.Verify(context.Background(), "not a token"); err == nil {
+		t.Error("expected a malformed token to be refused")
+	}
+}
@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksMinRefresh throttles refetches triggered by tokens signed with an unknown key,
+	// so garbage tokens or an unreachable provider do not make us hammer it
+	jwksMinRefresh  = time.Minute
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+// OIDCConfig describes one OpenID Connect identity provider. The claim names may be dotted
+// paths into nested objects, e.g. "realm_access.roles" for Keycloak realm roles.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string // expected in the aud claim
+	EmailClaim   string // defaults to "email"
+	NameClaim    string // defaults to "name"
+	PictureClaim string // defaults to "picture"
+	GroupsClaim  string // defaults to "groups"
+	KeyCacheTTL  time.Duration
+	// TrustEmail accepts tokens without email_verified, for providers that only assert addresses
+	// they manage and do not send the claim, such as Azure AD
+	TrustEmail bool
+}
+
+// OIDCVerifier validates ID tokens of an OpenID Connect provider against the keys published in its
+// JWKS. The JWKS location comes from the discovery document, both are fetched on first use and the
+// keys are refetched once their cache expires or a token names a key we have not seen yet.
+type OIDCVerifier struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	jwksURI     string
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	minRefresh  time.Duration
+}
+
+func NewOIDCVerifier(cfg OIDCConfig) (*OIDCVerifier, error) {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Issuer == "" {
+		return nil, errors.New("OIDC issuer is required")
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("OIDC provider %s needs a client_id", cfg.Issuer)
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "name"
+	}
+	if cfg.PictureClaim == "" {
+		cfg.PictureClaim = "picture"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.KeyCacheTTL <= 0 {
+		cfg.KeyCacheTTL = time.Hour
+	}
+	return &OIDCVerifier{cfg: cfg, client: &http.Client{Timeout: oidcHTTPTimeout}, minRefresh: jwksMinRefresh}, nil
+}
+
+func (o *OIDCVerifier) Accepts(issuer string) bool {
+	return strings.TrimSuffix(issuer, "/") == o.cfg.Issuer
+}
+
+func (o *OIDCVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	payload := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, payload, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithAudience(o.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token of %s: %v", o.cfg.Issuer, err)
+	}
+	// Some providers publish the issuer with a trailing slash, compare like Accepts does
+	if iss, _ := payload["iss"].(string); strings.TrimSuffix(iss, "/") != o.cfg.Issuer {
+		return nil, fmt.Errorf("invalid token of %s: unexpected issuer %q", o.cfg.Issuer, iss)
+	}
+
+	subject, _ := payload["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("subject not found in token")
+	}
+	email, _ := claimValue(payload, o.cfg.EmailClaim).(string)
+	if email == "" {
+		return nil, errors.New("email not found in token")
+	}
+	// Accounts are linked by email, an address the provider has not verified could take over another account
+	if verified, _ := payload["email_verified"].(bool); !verified && !o.cfg.TrustEmail {
+		return nil, errors.New("email in token is not verified")
+	}
+
+	claims := &Claims{Issuer: o.cfg.Issuer, Subject: subject, Email: email, Groups: stringList(claimValue(payload, o.cfg.GroupsClaim))}
+	claims.Name, _ = claimValue(payload, o.cfg.NameClaim).(string)
+	claims.Picture, _ = claimValue(payload, o.cfg.PictureClaim).(string)
+	claims.Domain, _ = payload["hd"].(string)
+	return claims, nil
+}
+
+// claimValue follows a dotted claim path through nested objects
+func claimValue(payload map[string]interface{}, path string) interface{} {
+	var value interface{} = payload
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+	return value
+}
+
+// stringList accepts a claim holding either a list of strings or a single string
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// key returns the signing key with the given id, refetching the JWKS when the cache has expired
+// or the key is unknown, e.g. right after the provider rotated its keys
+func (o *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key, known := o.lookup(kid)
+	if known && time.Since(o.fetchedAt) < o.cfg.KeyCacheTTL {
+		return key, nil
+	}
+	if time.Since(o.attemptedAt) >= o.minRefresh {
+		o.attemptedAt = time.Now()
+		if err := o.refresh(ctx); err == nil {
+			key, known = o.lookup(kid)
+		} else if !known {
+			return nil, err
+		}
+		// On failure a key we already trust stays usable while the provider is unreachable
+	}
+	if !known {
+		return nil, fmt.Errorf("signing key %q is not published by %s", kid, o.cfg.Issuer)
+	}
+	return key, nil
+}
+
+// lookup finds a cached key; tokens without a kid are only accepted when there is a single key
+func (o *OIDCVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+func (o *OIDCVerifier) refresh(ctx context.Context) error {
+	if o.jwksURI == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.getJSON(ctx, o.cfg.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("OIDC discovery of %s failed: %v", o.cfg.Issuer, err)
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != o.cfg.Issuer {
+			return fmt.Errorf("OIDC discovery of %s returned issuer %q", o.cfg.Issuer, discovery.Issuer)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("OIDC discovery of %s returned no jwks_uri", o.cfg.Issuer)
+		}
+		o.jwksURI = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, o.jwksURI, &set); err != nil {
+		return fmt.Errorf("fetching the keys of %s failed: %v", o.cfg.Issuer, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped, the provider may publish keys for other purposes
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	o.keys = keys
+	o.fetchedAt = time.Now()
+	return nil
+}
+
+func (o *OIDCVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jsonWebKey is the subset of RFC 7517 needed for RSA and EC signature keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64BigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64BigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64BigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64BigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC key is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func base64BigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
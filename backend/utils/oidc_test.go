@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "med-monitor-api"
+
+// testIdP serves a discovery document and a JWKS whose keys can be rotated
+type testIdP struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]crypto.Signer
+	fetches int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{keys: make(map[string]crypto.Signer)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.fetches++
+		var keys []map[string]string
+		for kid, signer := range idp.keys {
+			keys = append(keys, publicJWK(kid, signer.Public()))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func publicJWK(kid string, key crypto.PublicKey) map[string]string {
+	enc := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": enc(k.N), "e": enc(big.NewInt(int64(k.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name, "x": enc(k.X), "y": enc(k.Y)}
+	}
+	panic("unsupported key")
+}
+
+// setKeys replaces the published keys, as a provider rotating its keys does
+func (idp *testIdP) setKeys(t *testing.T, kids ...string) {
+	t.Helper()
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	keys := make(map[string]crypto.Signer)
+	for _, kid := range kids {
+		if key, ok := idp.keys[kid]; ok {
+			keys[kid] = key
+			continue
+		}
+		var key crypto.Signer
+		var err error
+		if kid == "ec" {
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		} else {
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = key
+	}
+	idp.keys = keys
+}
+
+func (idp *testIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	base := jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            testClientID,
+		"sub":            "f3a1",
+		"email":          "nurse@hospital.example.org",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email_verified": true,
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(base, k)
+		} else {
+			base[k] = v
+		}
+	}
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, base)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestOIDCVerifier(t *testing.T, cfg OIDCConfig) *OIDCVerifier {
+	t.Helper()
+	o, err := NewOIDCVerifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOIDCVerifierClaimMapping(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.setKeys(t, "rsa", "ec")
+
+	tests := []struct {
+		name   string
+		cfg    OIDCConfig
+		kid    string
+		claims jwt.MapClaims
+		want   Claims
+	}{
+		{
+			name:   "standard claims",
+			kid:    "rsa",
+			claims: jwt.MapClaims{"name": "Nurse Joy", "picture": "https://idp/joy.png", "groups": []string{"nurses", "icu"}},
+			want:   Claims{Email: "nurse@hospital.example.org", Name: "Nurse Joy", Picture: "https://idp/joy.png", Groups: []string{"nurses", "icu"}},
+		},
+		{
+			name:   "nested groups of Keycloak and an Azure AD style email",
+			cfg:    OIDCConfig{EmailClaim: "preferred_username", GroupsClaim: "realm_access.roles"},
+			kid:    "ec",
+			claims: jwt.MapClaims{"email": nil, "preferred_username": "joy@hospital.example.org", "realm_access": map[string]interface{}{"roles": []string{"doctor"}}},
+			want:   Claims{Email: "joy@hospital.example.org", Groups: []string{"doctor"}},
+		},
+		{
+			name:   "a single group as a string",
+			kid:    "rsa",
+			claims: jwt.MapClaims{"groups": "pharmacy"},
+			want:   Claims{Email: "nurse@hospital.example.org", Groups: []string{"pharmacy"}},
+		},
+		{
+			name:   "a trusted provider without email_verified",
+			cfg:    OIDCConfig{TrustEmail: true},
+			kid:    "rsa",
+			claims: jwt.MapClaims{"email_verified": nil},
+			want:   Claims{Email: "nurse@hospital.example.org"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Issuer, cfg.ClientID = idp.URL, testClientID
+			o := newTestOIDCVerifier(t, cfg)
+			claims, err := o.Verify(context.Background(), idp.sign(t, tt.kid, tt.claims))
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.Issuer != idp.URL || claims.Subject != "f3a1" {
+				t.Errorf("got identity %q/%q, want %q/f3a1", claims.Issuer, claims.Subject, idp.URL)
+			}
+			if claims.Email != tt.want.Email || claims.Name != tt.want.Name || claims.Picture != tt.want.Picture || !slices.Equal(claims.Groups, tt.want.Groups) {
+				t.Errorf("got claims %+v, want %+v", claims, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierRejects(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.setKeys(t, "rsa")
+	o := newTestOIDCVerifier(t, OIDCConfig{Issuer: idp.URL, ClientID: testClientID})
+
+	other := newTestIdP(t)
+	other.setKeys(t, "rsa")
+	foreign := other.sign(t, "rsa", jwt.MapClaims{"iss": idp.URL})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "signed by a key the provider does not publish", token: foreign},
+		{name: "another audience", token: idp.sign(t, "rsa", jwt.MapClaims{"aud": "another-client"})},
+		{name: "another issuer", token: idp.sign(t, "rsa", jwt.MapClaims{"iss": "https://evil.example.org"})},
+		{name: "expired", token: idp.sign(t, "rsa", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})},
+		{name: "without email", token: idp.sign(t, "rsa", jwt.MapClaims{"email": nil})},
+		{name: "unverified email", token: idp.sign(t, "rsa", jwt.MapClaims{"email_verified": false})},
+		{name: "without email_verified", token: idp.sign(t, "rsa", jwt.MapClaims{"email_verified": nil})},
+		{name: "without subject", token: idp.sign(t, "rsa", jwt.MapClaims{"sub": nil})},
+		{name: "HS256 signed with the public modulus", token: func() string {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": idp.URL, "aud": testClientID, "email": "x@example.org", "exp": time.Now().Add(time.Hour).Unix()}).
+				SignedString([]byte(publicJWK("rsa", idp.keys["rsa"].Public())["n"]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := o.Verify(context.Background(), tt.token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierKeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.setKeys(t, "2026-01")
+	o := newTestOIDCVerifier(t, OIDCConfig{Issuer: idp.URL, ClientID: testClientID})
+	o.minRefresh = 0
+
+	verify := func(kid string) error {
+		_, err := o.Verify(context.Background(), idp.sign(t, kid, nil))
+		return err
+	}
+	if err := verify("2026-01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify("2026-01"); err != nil || idp.jwksServed() != 1 {
+		t.Fatalf("expected the cached key to be used, error %v after %d fetches", err, idp.jwksServed())
+	}
+
+	// A token signed with the new key makes the verifier refetch the JWKS
+	idp.setKeys(t, "2026-01", "2026-02")
+	if err := verify("2026-02"); err != nil || idp.jwksServed() != 2 {
+		t.Fatalf("expected the new key to be fetched, error %v after %d fetches", err, idp.jwksServed())
+	}
+
+	// Once the cache expires, keys the provider retired are no longer accepted
+	retired := idp.sign(t, "2026-01", nil)
+	idp.setKeys(t, "2026-02")
+	o.fetchedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := o.Verify(context.Background(), retired); err == nil {
+		t.Error("expected a retired key to be refused")
+	}
+	if err := verify("2026-02"); err != nil {
+		t.Errorf("current key refused: %v", err)
+	}
+
+	// Unknown keys are refetched at most once per minRefresh
+	other := newTestIdP(t)
+	other.setKeys(t, "unknown")
+	unknown := other.sign(t, "unknown", jwt.MapClaims{"iss": idp.URL})
+	o.minRefresh = time.Hour
+	served := idp.jwksServed()
+	for range 3 {
+		if _, err := o.Verify(context.Background(), unknown); err == nil {
+			t.Fatal("expected a token signed with an unknown key to be refused")
+		}
+	}
+	if idp.jwksServed() != served {
+		t.Errorf("expected refetches to be throttled, JWKS fetched %d more times", idp.jwksServed()-served)
+	}
+}
+
+func (idp *testIdP) jwksServed() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.fetches
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/services"
+)
+
+// StartNoShowSweeper marks overdue Scheduled appointments as NoShow every interval, in the background
+func StartNoShowSweeper(service services.MedicalService, interval, grace time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			marked, err := service.MarkNoShows(grace)
+			if err != nil {
+				log.Printf("No-show sweep failed: %v", err)
+			} else if marked > 0 {
+				log.Printf("No-show sweep marked %d appointment(s)", marked)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/cristim67/med-monitor/backend/services"
+)
+
+// StartPrescriptionExpirySweeper marks prescriptions past their expiry date as Expired every interval, in the background
+func StartPrescriptionExpirySweeper(service services.MedicalService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			expired, err := service.ExpirePrescriptions()
+			if err != nil {
+				log.Printf("Prescription expiry sweep failed: %v", err)
+			} else if expired > 0 {
+				log.Printf("Prescription expiry sweep expired %d prescription(s)", expired)
+			}
+			<-ticker.C
+		}
+	}()
+}
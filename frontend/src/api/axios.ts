@@ -1,10 +1,23 @@
 import axios from 'axios';
+import type { AxiosError, InternalAxiosRequestConfig } from 'axios';
 import { API_URL } from '../config';
 
 const api = axios.create({
   baseURL: API_URL,
 });
 
+// Stores the token pair returned by the token exchange and refresh endpoints
+export const saveSession = (data: { access_token: string; refresh_token: string }) => {
+  localStorage.setItem('token', data.access_token);
+  localStorage.setItem('refresh_token', data.refresh_token);
+};
+
+export const clearSession = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('user_role');
+};
+
 api.interceptors.request.use((config) => {
   const token = localStorage.getItem('token');
   if (token) {
@@ -13,12 +26,35 @@ api.interceptors.request.use((config) => {
   return config;
 });
 
+// Refresh tokens work once, so concurrent 401s share a single refresh
+let refreshing: Promise<void> | null = null;
+
+const refreshSession = () => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshing = (refreshToken
+      ? axios.post(`${API_URL}/api/v1/auth/refresh`, { refresh_token: refreshToken }).then((res) => saveSession(res.data))
+      : Promise.reject(new Error('no refresh token'))
+    ).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    if (error.response?.status === 401) {
-      localStorage.removeItem('token');
-      window.location.href = '/login';
+  async (error: AxiosError) => {
+    const request = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined;
+    if (error.response?.status === 401 && request && !request._retried) {
+      request._retried = true;
+      try {
+        await refreshSession();
+        return api(request);
+      } catch {
+        clearSession();
+        window.location.href = '/login';
+      }
     }
     return Promise.reject(error);
   }
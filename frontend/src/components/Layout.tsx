@@ -1,14 +1,7 @@
 import { useState, useEffect } from 'react';
 import { Outlet, NavLink, useNavigate } from 'react-router-dom';
 import { LayoutDashboard, Calendar, Users, LogOut, Activity, Sun, Moon, Menu, X, ClipboardList, Shield } from 'lucide-react';
-import api from '../api/axios';
-import { jwtDecode } from 'jwt-decode';
-
-interface GoogleJwtPayload {
-  name?: string;
-  picture?: string;
-  email?: string;
-}
+import api, { clearSession } from '../api/axios';
 
 import { useTheme } from '../context/ThemeContext';
 
@@ -21,26 +14,20 @@ export default function Layout() {
     setIsSidebarOpen(!isSidebarOpen);
   };
 
-  const handleLogout = () => {
-    localStorage.removeItem('token');
-    localStorage.removeItem('user_role');
+  const handleLogout = async () => {
+    try {
+      await api.post('/api/v1/auth/logout');
+    } catch (err) {
+      console.error('Failed to end the session', err);
+    }
+    clearSession();
     navigate('/login');
   };
 
   const token = localStorage.getItem('token') || '';
   const role = localStorage.getItem('user_role') || 'patient';
-  let name = 'User';
-  let picture = '';
-  
-  try {
-    if (token) {
-      const decoded = jwtDecode<GoogleJwtPayload>(token);
-      name = decoded.name || 'User';
-      picture = decoded.picture || '';
-    }
-  } catch (e) {
-    console.error('Failed to decode token', e);
-  }
+  const name = localStorage.getItem('user_name') || 'User';
+  const picture = localStorage.getItem('user_picture') || '';
 
   const userAvatar = picture || `https://ui-avatars.com/api/?name=${encodeURIComponent(name)}&background=0D8ABC&color=fff&size=128`;
   
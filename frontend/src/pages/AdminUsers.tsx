@@ -154,6 +154,7 @@ export default function AdminUsers() {
                       >
                         <option value="patient">Patient</option>
                         <option value="doctor">Doctor</option>
+                        <option value="pharmacist">Pharmacist</option>
                         <option value="admin">Admin</option>
                       </select>
                       {updating === user.id && <Clock size={18} className="spin" style={{ alignSelf: 'center' }} />}
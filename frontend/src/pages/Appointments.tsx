@@ -42,7 +42,7 @@ export default function Appointments() {
   const [selectedDoctorObj, setSelectedDoctorObj] = useState<Doctor | null>(null);
   const [bookingDate, setBookingDate] = useState(new Date().toISOString().split('T')[0]);
   const [selectedTime, setSelectedTime] = useState('');
-  const [busySlots, setBusySlots] = useState<{date: string; status: string}[]>([]);
+  const [freeSlots, setFreeSlots] = useState<{start: string; end: string}[]>([]);
   const [loadingSlots, setLoadingSlots] = useState(false);
 
   // Form States
@@ -81,8 +81,10 @@ export default function Appointments() {
     if (!selectedDoctorObj) return;
     setLoadingSlots(true);
     try {
-      const res = await api.get(`/api/v1/doctors/${selectedDoctorObj.id}/availability`);
-      setBusySlots(res.data || []);
+      const res = await api.get(`/api/v1/doctors/${selectedDoctorObj.id}/availability`, {
+        params: { from: bookingDate, to: bookingDate }
+      });
+      setFreeSlots(res.data || []);
     } catch (err) {
       console.error('Failed to fetch availability', err);
     } finally {
@@ -110,8 +112,13 @@ export default function Appointments() {
 
   const isSlotBusy = (time: string) => {
     if (!bookingDate) return false;
-    // Check if any busy slot matches bookingDate T time
-    return busySlots.some(s => s.date.startsWith(`${bookingDate}T${time}`) && s.status !== 'Cancelled');
+    // A slot is bookable only if the backend returned one starting at that wall-clock time in the
+    // clinic timezone. Slots carry the clinic's offset, so the grid time is read with the same offset
+    // and the two instants are compared.
+    return !freeSlots.some(s => {
+      const offset = s.start.match(/(Z|[+-]\d{2}:\d{2})$/)?.[0] ?? 'Z';
+      return Date.parse(s.start) === Date.parse(`${bookingDate}T${time}:00${offset}`);
+    });
   };
 
   const handleBook = async (e: React.FormEvent) => {
@@ -121,7 +128,8 @@ export default function Appointments() {
     try {
       await api.post('/api/v1/appointments', {
         doctor_id: selectedDoctorObj.id,
-        date: `${bookingDate}T${selectedTime}:00Z`,
+        // Local clinic time without an offset; the backend reads it in the clinic timezone
+        date: `${bookingDate}T${selectedTime}:00`,
       });
       setShowBookModal(false);
       resetBookForm();
@@ -1,7 +1,7 @@
 import { GoogleLogin } from '@react-oauth/google';
 import type { CredentialResponse } from '@react-oauth/google';
 import { useNavigate } from 'react-router-dom';
-import api from '../api/axios';
+import api, { saveSession } from '../api/axios';
 import { Activity, Sun, Moon } from 'lucide-react';
 import { useTheme } from '../context/ThemeContext';
 
@@ -12,15 +12,13 @@ export default function Login() {
   const handleSuccess = async (credentialResponse: CredentialResponse) => {
     try {
       if (credentialResponse.credential) {
-        // Save the google token
-        localStorage.setItem('token', credentialResponse.credential);
-        
-        // Fetch profile to get Role
-        const res = await api.get('/api/v1/profile');
+        // Exchange the google token for an API session
+        const res = await api.post('/api/v1/auth/token', { id_token: credentialResponse.credential });
         if (res.status === 200) {
-          localStorage.setItem('user_role', res.data.role);
-          localStorage.setItem('user_name', res.data.name);
-          localStorage.setItem('user_picture', res.data.picture);
+          saveSession(res.data);
+          localStorage.setItem('user_role', res.data.user.role);
+          localStorage.setItem('user_name', res.data.user.name);
+          localStorage.setItem('user_picture', res.data.user.picture);
           navigate('/');
         }
       }